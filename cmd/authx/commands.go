@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/api"
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	commandTimeout = 30 * time.Second
+	keysUsage      = "usage: authx keys list|rotate|maintain"
+	clientsUsage   = "usage: authx clients list|create <id> public|confidential <grant-types> [redirect-uris] [scopes]|remove <id>"
+	invitesUsage   = "usage: authx invites create"
+	unlockUsage    = "usage: authx unlock user <username>|ip <address>"
+)
+
+// runCommand runs the subcommand named by the first argument.
+func runCommand(cfg *config.Config, ds store.DataStore, args []string) error {
+	switch args[0] {
+	case "keys":
+		return runKeysCommand(cfg, ds, args[1:])
+	case "clients":
+		return runClientsCommand(cfg, ds, args[1:])
+	case "invites":
+		return runInvitesCommand(cfg, ds, args[1:])
+	case "unlock":
+		return runUnlockCommand(ds, args[1:])
+	}
+
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// runKeysCommand manages the signing keys in the keyring.
+//
+//	list     - lists the keys, the last activated one is the signing key.
+//	rotate   - publishes a new signing key, which signs once it's activated. The previous keys still verify
+//	           tokens until they are retired.
+//	maintain - rotates the signing key if due, and retires the keys past the access token TTL.
+func runKeysCommand(cfg *config.Config, ds store.DataStore, args []string) error {
+	if len(args) != 1 {
+		return errors.New(keysUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	keyring := api.NewKeyring(cfg, ds)
+	switch args[0] {
+	case "list":
+		// Listed below.
+	case "rotate":
+		key, err := keyring.Rotate(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Published signing key %s, which signs from %s\n", key.ID, key.ActivateAt.Format(time.RFC3339))
+	case "maintain":
+		if err := keyring.Maintain(ctx); err != nil {
+			return err
+		}
+	default:
+		return errors.New(keysUsage)
+	}
+
+	keys, err := ds.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY ID\tALGORITHM\tCREATED\tSIGNS FROM")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.CreatedAt.Format(time.RFC3339),
+			key.ActiveFrom().Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+// runClientsCommand manages the clients in the client registry. The grant types, redirect uris and
+// scopes are comma-separated lists.
+//
+//	list   - lists the clients.
+//	create - registers a client, the secret of a confidential client is printed only once.
+//	remove - removes a client.
+func runClientsCommand(cfg *config.Config, ds store.DataStore, args []string) error {
+	if len(args) == 0 {
+		return errors.New(clientsUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		// Listed below.
+	case args[0] == "create" && len(args) >= 4 && len(args) <= 6:
+		if args[2] != "public" && args[2] != "confidential" {
+			return errors.New(clientsUsage)
+		}
+		client := models.Client{
+			ID:         args[1],
+			Name:       args[1],
+			GrantTypes: splitList(args[3]),
+		}
+		if len(args) > 4 {
+			client.RedirectURIs = splitList(args[4])
+		}
+		if len(args) > 5 {
+			client.Scopes = splitList(args[5])
+		}
+		secret, err := auth.RegisterClient(ctx, ds, &client, args[2] == "confidential", api.NewPasswordParams(cfg))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created client %s\n", client.ID)
+		if secret != "" {
+			fmt.Printf("Client secret: %s\n", secret)
+		}
+		return nil
+	case args[0] == "remove" && len(args) == 2:
+		if err := ds.RemoveClient(ctx, args[1]); err != nil {
+			return err
+		}
+	default:
+		return errors.New(clientsUsage)
+	}
+
+	clients, err := ds.GetClients(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT ID\tTYPE\tGRANT TYPES\tREDIRECT URIS\tSCOPES")
+	for _, client := range clients {
+		clientType := "confidential"
+		if client.IsPublic() {
+			clientType = "public"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", client.ID, clientType, strings.Join(client.GrantTypes, ","),
+			strings.Join(client.RedirectURIs, ","), strings.Join(client.Scopes, ","))
+	}
+
+	return w.Flush()
+}
+
+// runInvitesCommand manages the invites for an invite-only sign-up.
+//
+//	create - creates an invite code, which expires after the invite TTL and can be used only once.
+func runInvitesCommand(cfg *config.Config, ds store.DataStore, args []string) error {
+	if len(args) != 1 || args[0] != "create" {
+		return errors.New(invitesUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	code, err := auth.CreateInvite(ctx, ds, time.Duration(cfg.InviteTTL)*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Invite code: %s\n", code)
+
+	return nil
+}
+
+// runUnlockCommand lifts the lockout after too many failed sign-ins.
+//
+//	user - forgets the failed sign-ins of the username.
+//	ip   - forgets the failed sign-ins from the client IP address.
+func runUnlockCommand(ds store.DataStore, args []string) error {
+	if len(args) != 2 {
+		return errors.New(unlockUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "user":
+		if err := auth.UnlockAccount(ctx, ds, args[1]); err != nil {
+			return err
+		}
+	case "ip":
+		if err := auth.UnlockIP(ctx, ds, args[1]); err != nil {
+			return err
+		}
+	default:
+		return errors.New(unlockUsage)
+	}
+	fmt.Printf("Unlocked %s %s\n", args[0], args[1])
+
+	return nil
+}
+
+func splitList(str string) []string {
+	var vals []string
+	for _, val := range strings.Split(str, ",") {
+		if val = strings.TrimSpace(val); val != "" {
+			vals = append(vals, val)
+		}
+	}
+
+	return vals
+}
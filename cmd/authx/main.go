@@ -1,13 +1,15 @@
 package main
 
 import (
+	"log"
+
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/cybersamx/authx/pkg/api"
 	"github.com/cybersamx/authx/pkg/app"
 	"github.com/cybersamx/authx/pkg/config"
 	"github.com/cybersamx/authx/pkg/server"
-	"github.com/cybersamx/authx/pkg/store/mongo"
 )
 
 func main() {
@@ -17,10 +19,26 @@ func main() {
 	cfg.BindConfig(v)
 	cfg.LoadConfig(v)
 
-	// Mongo
-	ds := mongo.New(cfg)
+	// Data store
+	ds, err := app.NewDataStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer ds.Close()
-	if err := api.SeedUserData(ds); err != nil {
+
+	// Subcommands eg. `authx keys rotate`. The first argument is the program name.
+	if args := pflag.Args(); len(args) > 1 {
+		if err := runCommand(cfg, ds, args[1:]); err != nil {
+			ds.Close()
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := api.SeedUserData(cfg, ds); err != nil {
+		panic(err)
+	}
+	if err := api.SeedClientData(cfg, ds); err != nil {
 		panic(err)
 	}
 
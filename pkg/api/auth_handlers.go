@@ -2,37 +2,113 @@ package api
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cybersamx/authx/pkg/avatar"
 	"github.com/gin-gonic/gin"
+	ut "github.com/go-playground/universal-translator"
+	"golang.org/x/oauth2"
 
 	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/models"
 	"github.com/cybersamx/authx/pkg/store"
 )
 
+const (
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeClientCredentials = "client_credentials"
+)
+
 var (
-	ErrInvalidCredentials = errors.New("invalid authentication credentials")
-	ErrInvalidRequest     = errors.New("invalid request payload")
+	ErrInvalidCredentials   = errors.New("invalid authentication credentials")
+	ErrInvalidRequest       = errors.New("invalid request payload")
+	ErrUnsupportedGrantType = errors.New("unsupported grant type")
 )
 
 type AuthHandlers struct {
-	cfg *config.Config
-	ds  store.DataStore
+	cfg     *config.Config
+	ds      store.DataStore
+	tc      *auth.TokenConfig
+	checker auth.TokenChecker
+	trans   ut.Translator
+	policy  *auth.PasswordPolicy
 }
 
-func NewAuthHandlers(cfg *config.Config, ds store.DataStore) *AuthHandlers {
+func NewAuthHandlers(cfg *config.Config, ds store.DataStore, tc *auth.TokenConfig, checker auth.TokenChecker,
+	trans ut.Translator, policy *auth.PasswordPolicy) *AuthHandlers {
 	handlers := new(AuthHandlers)
 
 	handlers.cfg = cfg
 	handlers.ds = ds
+	handlers.tc = tc
+	handlers.checker = checker
+	handlers.trans = trans
+	handlers.policy = policy
+
+	// Unknown users are checked against a dummy hash, which is ready before the first sign-in.
+	auth.PrepareDummyPassword(NewPasswordParams(cfg))
 
 	return handlers
 }
 
+// createSignInToken strips the sensitive data of the user and issues the tokens of a sign-in.
+func createSignInToken(ctx *gin.Context, cfg *config.Config, ds store.DataStore, tc *auth.TokenConfig,
+	user *models.User) (*oauth2.Token, error) {
+	// Strip sensitive data like password.
+	user.RemoveSensitiveData()
+
+	// Generate and save oauth2 object, which includes.
+	aTTL := time.Duration(cfg.AccessTTL) * time.Second
+	rTTL := time.Duration(cfg.RefreshTTL) * time.Second
+
+	return auth.CreateOAuthToken(ctx, ds, tc, &auth.CreateOAuthTokenParams{
+		UID:        user.ID,
+		AccessTTL:  aTTL,
+		RefreshTTL: rTTL,
+	})
+}
+
+// retryAfterSeconds rounds the wait up to whole seconds.
+func retryAfterSeconds(wait time.Duration) int {
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+// setThrottleStatus tells the client how long to wait before signing in again.
+// throttlePasswordCheck counts the check of the password of a signed-in user, which returns
+// auth.ErrInvalidCredentials if the password is wrong, as a sign-in attempt of the user, so that a
+// stolen access token or session can't be used to guess the password. It returns a ThrottleError
+// without running the check if the sign-ins are throttled.
+func throttlePasswordCheck(ctx *gin.Context, cfg *config.Config, ds store.DataStore, username string,
+	check func() error) error {
+	throttle := NewSignInThrottle(cfg, ds)
+	if err := throttle.Attempt(ctx, username, ctx.ClientIP()); err != nil {
+		return err
+	}
+
+	err := check()
+	switch err {
+	case auth.ErrInvalidCredentials:
+	case nil:
+		throttle.Succeed(ctx, username, ctx.ClientIP())
+	default:
+		throttle.Release(ctx, username, ctx.ClientIP())
+	}
+
+	return err
+}
+
+func setThrottleStatus(ctx *gin.Context, terr *auth.ThrottleError) {
+	ctx.Header("Retry-After", strconv.Itoa(retryAfterSeconds(terr.RetryAfter)))
+	setErrorStatus(ctx, terr, http.StatusTooManyRequests)
+}
+
 func (ah *AuthHandlers) SignIn() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Bind inputs
@@ -42,29 +118,51 @@ func (ah *AuthHandlers) SignIn() gin.HandlerFunc {
 			return
 		}
 
-		// Check if user exists
-		user, err := ah.ds.GetUserByUsername(ctx, signin.Username)
-		if user == nil || err == store.ErrorNotFound {
-			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+		// Hold off the sign-ins of the user, or from the client, after too many failures.
+		throttle := NewSignInThrottle(ah.cfg, ah.ds)
+		if err := throttle.Attempt(ctx, signin.Username, ctx.ClientIP()); err != nil {
+			if terr, ok := err.(*auth.ThrottleError); ok {
+				setThrottleStatus(ctx, terr)
+				return
+			}
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Authenticate, without revealing whether the user exists.
+		user, err := auth.SignInUser(ctx, ah.ds, signin.Username, signin.Password, NewPasswordParams(ah.cfg))
+		if err == auth.ErrInvalidCredentials {
+			setErrorStatus(ctx, ErrInvalidCredentials, http.StatusUnauthorized)
 			return
 		} else if err != nil {
+			throttle.Release(ctx, signin.Username, ctx.ClientIP())
 			setErrorStatus(ctx, err, http.StatusInternalServerError)
 			return
 		}
-
-		// Authenticate
-		if ok := auth.Authenticate(user.Password, signin.Password, user.Salt); !ok {
-			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+		if ah.cfg.RequireVerified && !user.EmailVerified {
+			// The password is correct all the same.
+			throttle.Succeed(ctx, signin.Username, ctx.ClientIP())
+			setErrorStatus(ctx, auth.ErrEmailNotVerified, http.StatusForbidden)
 			return
 		}
 
-		// Strip sensitive data like password.
-		user.RemoveSensitiveData()
+		// Complete the sign-in with the second factor, in which case the failures are only forgotten
+		// once it's done.
+		if auth.MFARequired(user, ah.cfg.MFARequiredRoles) {
+			throttle.Release(ctx, signin.Username, ctx.ClientIP())
+			resp, err := newMFARequiredResponse(ctx, ah.cfg, ah.ds, user)
+			if err != nil {
+				setErrorStatus(ctx, err, http.StatusInternalServerError)
+				return
+			}
+
+			ctx.JSON(http.StatusUnauthorized, resp)
+			_ = ctx.Error(auth.ErrMFARequired)
+			return
+		}
+		throttle.Succeed(ctx, signin.Username, ctx.ClientIP())
 
-		// Generate and save oauth2 object, which includes.
-		aTTL := time.Duration(ah.cfg.AccessTTL) * time.Second
-		rTTL := time.Duration(ah.cfg.RefreshTTL) * time.Second
-		otoken, err := auth.CreateOAuthToken(ctx, ah.ds, user.ID, ah.cfg.AccessSecret, aTTL, rTTL)
+		otoken, err := createSignInToken(ctx, ah.cfg, ah.ds, ah.tc, user)
 		if err != nil {
 			setErrorStatus(ctx, err, http.StatusInternalServerError)
 			return
@@ -74,31 +172,366 @@ func (ah *AuthHandlers) SignIn() gin.HandlerFunc {
 	}
 }
 
+// SignUp creates a user if the sign-up is open, or if it's invite-only and the invite code is valid.
+func (ah *AuthHandlers) SignUp() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var signup SignUpRequest
+		if err := ctx.ShouldBindJSON(&signup); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := auth.SignUp(ctx, ah.ds, ah.cfg.SignUp, signUpRequest2Params(&signup), NewPasswordParams(ah.cfg),
+			ah.policy)
+		if perr, ok := err.(*auth.PolicyError); ok {
+			setPolicyError(ctx, perr, ah.trans)
+			return
+		}
+		switch err {
+		case nil:
+		case auth.ErrSignUpDisabled, auth.ErrInvalidInvite:
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		case auth.ErrUsernameTaken:
+			setErrorStatus(ctx, err, http.StatusConflict)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		if user.Email != "" {
+			if err := sendVerificationEmail(ctx, ah.cfg, user); err != nil {
+				log.Printf("failed to send verification email to user %s: %v\n", user.ID, err)
+			}
+		}
+
+		ctx.JSON(http.StatusCreated, user2UserInfoResponse(user, firstPartyScope))
+	}
+}
+
+// Token is the OAuth2 token endpoint.
+func (ah *AuthHandlers) Token() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var treq TokenRequest
+		if err := ctx.ShouldBind(&treq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		switch treq.GrantType {
+		case grantTypeRefreshToken:
+			ah.refreshTokenGrant(ctx, &treq)
+		case grantTypeAuthorizationCode:
+			ah.authorizationCodeGrant(ctx, &treq)
+		case grantTypeClientCredentials:
+			ah.clientCredentialsGrant(ctx, &treq)
+		default:
+			setErrorStatus(ctx, ErrUnsupportedGrantType, http.StatusBadRequest)
+		}
+	}
+}
+
+func (ah *AuthHandlers) refreshTokenGrant(ctx *gin.Context, treq *TokenRequest) {
+	if treq.RefreshToken == "" {
+		setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	// A refresh token issued by a first-party sign-in is redeemed without a client, any other one by
+	// the client it was issued to.
+	clientID, ok := ah.authenticateOptionalClient(ctx, grantTypeRefreshToken)
+	if !ok {
+		return
+	}
+
+	aTTL := time.Duration(ah.cfg.AccessTTL) * time.Second
+	rTTL := time.Duration(ah.cfg.RefreshTTL) * time.Second
+	otoken, err := auth.RefreshOAuthToken(ctx, ah.ds, ah.tc, clientID, treq.RefreshToken, aTTL, rTTL,
+		ah.cfg.RefreshTokenRotate)
+	if err == auth.ErrInvalidRefreshToken || err == auth.ErrReusedRefreshToken {
+		setErrorStatus(ctx, err, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, newTokenResponse(otoken))
+}
+
+// getClientCredentials gets the client credentials from the basic authorization header or the
+// request body.
+func getClientCredentials(ctx *gin.Context) (string, string) {
+	if id, secret, ok := ctx.Request.BasicAuth(); ok {
+		return id, secret
+	}
+
+	return ctx.PostForm("client_id"), ctx.PostForm("client_secret")
+}
+
+// authenticateIntrospectionClient authenticates the client of the introspection request, which must
+// be a confidential client with the introspect scope.
+func (ah *AuthHandlers) authenticateIntrospectionClient(ctx *gin.Context) bool {
+	id, secret := getClientCredentials(ctx)
+	client, err := auth.AuthenticateClient(ctx, ah.ds, id, secret)
+	if err != nil && err != auth.ErrInvalidClient {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return false
+	}
+	if err == auth.ErrInvalidClient || client.IsPublic() || !client.HasScope(auth.ScopeIntrospect) {
+		ctx.Header("WWW-Authenticate", `Basic realm="authx"`)
+		setErrorStatus(ctx, auth.ErrInvalidClient, http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// Introspect is the OAuth2 token introspection endpoint (RFC 7662).
+func (ah *AuthHandlers) Introspect() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !ah.authenticateIntrospectionClient(ctx) {
+			return
+		}
+
+		// Bind inputs
+		var ireq IntrospectionRequest
+		if err := ctx.ShouldBind(&ireq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		info, err := auth.IntrospectToken(ctx, ah.ds, ah.tc, ireq.Token, ireq.TokenTypeHint)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Header("Cache-Control", "no-store")
+		ctx.JSON(http.StatusOK, tokenInfo2IntrospectionResponse(info))
+	}
+}
+
+// Revoke is the OAuth2 token revocation endpoint (RFC 7009). Other replicas may still accept a
+// revoked access token until the cached revocation status expires.
+func (ah *AuthHandlers) Revoke() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var rreq RevocationRequest
+		if err := ctx.ShouldBind(&rreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// A client revokes the tokens issued to it, while the tokens of a first-party sign-in are
+		// revoked without a client.
+		clientID, ok := ah.authenticateOptionalClient(ctx, "")
+		if !ok {
+			return
+		}
+
+		atids, err := auth.RevokeToken(ctx, ah.ds, ah.tc, clientID, rreq.Token, rreq.TokenTypeHint)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		for _, atid := range atids {
+			ah.checker.Invalidate(atid)
+		}
+
+		// Invalid tokens and the tokens of other clients are also responded with 200 as per the spec.
+		ctx.Status(http.StatusOK)
+	}
+}
+
+// authenticateClient authenticates the client of the request and checks that it's allowed to use the
+// grant type, if it isn't empty.
+func (ah *AuthHandlers) authenticateClient(ctx *gin.Context, grantType string) (*models.Client, bool) {
+	id, secret := getClientCredentials(ctx)
+	client, err := auth.AuthenticateClient(ctx, ah.ds, id, secret)
+	if err == auth.ErrInvalidClient {
+		setErrorStatus(ctx, err, http.StatusUnauthorized)
+		return nil, false
+	} else if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if grantType != "" && !client.HasGrantType(grantType) {
+		setErrorStatus(ctx, auth.ErrUnauthorizedClient, http.StatusBadRequest)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// authenticateOptionalClient authenticates the client of the request like authenticateClient if the
+// request has client credentials. It returns the id of the client, or empty if there's none.
+func (ah *AuthHandlers) authenticateOptionalClient(ctx *gin.Context, grantType string) (string, bool) {
+	if id, _ := getClientCredentials(ctx); id == "" {
+		return "", true
+	}
+
+	client, ok := ah.authenticateClient(ctx, grantType)
+	if !ok {
+		return "", false
+	}
+
+	return client.ID, true
+}
+
+func (ah *AuthHandlers) authorizationCodeGrant(ctx *gin.Context, treq *TokenRequest) {
+	if treq.Code == "" || treq.RedirectURI == "" {
+		setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	client, ok := ah.authenticateClient(ctx, grantTypeAuthorizationCode)
+	if !ok {
+		return
+	}
+
+	ac, err := auth.ExchangeAuthCode(ctx, ah.ds, treq.Code, client.ID, treq.RedirectURI, treq.CodeVerifier)
+	if err == auth.ErrInvalidAuthCode || err == auth.ErrInvalidCodeVerifier {
+		setErrorStatus(ctx, err, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+
+	aTTL := time.Duration(ah.cfg.AccessTTL) * time.Second
+	rTTL := time.Duration(ah.cfg.RefreshTTL) * time.Second
+	otoken, err := auth.CreateOAuthToken(ctx, ah.ds, ah.tc, &auth.CreateOAuthTokenParams{
+		UID:        ac.UserID,
+		ClientID:   ac.ClientID,
+		Scope:      ac.Scope,
+		AccessTTL:  aTTL,
+		RefreshTTL: rTTL,
+	})
+	if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := newTokenResponse(otoken)
+	if auth.HasScope(ac.Scope, auth.ScopeOpenID) {
+		resp.IDToken, err = ah.newIDToken(ctx, ac, otoken.AccessToken)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// newIDToken returns the id token for the OpenID Connect authentication request that the
+// authorization code was issued for.
+func (ah *AuthHandlers) newIDToken(ctx *gin.Context, ac *models.AuthCode, accessToken string) (string, error) {
+	user, err := ah.ds.GetUser(ctx, ac.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	params := auth.IDTokenParams{
+		ClientID:    ac.ClientID,
+		Scope:       ac.Scope,
+		Nonce:       ac.Nonce,
+		AuthTime:    ac.AuthTime,
+		AccessToken: accessToken,
+		TTL:         time.Duration(ah.cfg.AccessTTL) * time.Second,
+	}
+
+	return auth.NewIDToken(user, &params, ah.tc)
+}
+
+func (ah *AuthHandlers) clientCredentialsGrant(ctx *gin.Context, treq *TokenRequest) {
+	client, ok := ah.authenticateClient(ctx, grantTypeClientCredentials)
+	if !ok {
+		return
+	}
+
+	// Only a confidential client can authenticate on its own behalf.
+	if client.IsPublic() {
+		setErrorStatus(ctx, auth.ErrInvalidClient, http.StatusUnauthorized)
+		return
+	}
+
+	scope, err := auth.GrantScope(client, treq.Scope)
+	if err != nil {
+		setErrorStatus(ctx, err, http.StatusBadRequest)
+		return
+	}
+
+	aTTL := time.Duration(ah.cfg.AccessTTL) * time.Second
+	otoken, err := auth.CreateClientOAuthToken(ctx, ah.ds, ah.tc, client, scope, aTTL)
+	if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, newTokenResponse(otoken))
+}
+
 func (ah *AuthHandlers) SignOut() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		atid := getAccessTokenIDFromContext(ctx)
-		if atid == "" {
+		bearerToken, err := parseBearerFromHeader(ctx.Request.Header.Get("Authorization"))
+		if err != nil {
 			ctx.JSON(http.StatusOK, "no access token")
 			return
 		}
 
-		// Delete the token in the data store.
-		claims, err := auth.UnsafeParseJWT(atid)
-		if err != nil {
+		// Delete the token and the other tokens from the same sign-in in the data store. A forged token
+		// can't sign anyone out, but an expired one still signs out its sign-in.
+		claims, err := auth.ParseJWT(bearerToken, ah.tc)
+		if err != nil && err != auth.ErrExpiredJWT {
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		}
+		at, err := ah.ds.GetAccessToken(ctx, claims.ID)
+		if err != nil && err != store.ErrorNotFound {
 			setErrorStatus(ctx, err, http.StatusInternalServerError)
 			return
 		}
-		if err := ah.ds.RemoveAccessToken(ctx, claims.ID); err != nil {
+		if at != nil && at.FamilyID != "" {
+			var atids []string
+			atids, err = auth.RevokeTokenFamily(ctx, ah.ds, at.FamilyID)
+			for _, atid := range atids {
+				ah.checker.Invalidate(atid)
+			}
+		} else {
+			err = ah.ds.RemoveAccessToken(ctx, claims.ID)
+		}
+		if err != nil {
 			setErrorStatus(ctx, err, http.StatusInternalServerError)
 			return
 		}
+		ah.checker.Invalidate(claims.ID)
 
 		ctx.JSON(http.StatusOK, "logout")
 	}
 }
 
+// UserInfo returns the claims of the user of the access token. The access token of a client must have
+// been granted the openid scope, and only gets the claims of its scope.
 func (ah *AuthHandlers) UserInfo() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		scope := firstPartyScope
+		if getClientIDFromContext(ctx) != "" {
+			scope = getScopeFromContext(ctx)
+			if !auth.HasScope(scope, auth.ScopeOpenID) {
+				setErrorStatus(ctx, ErrInsufficientScope, http.StatusForbidden)
+				return
+			}
+		}
+
 		val, ok := ctx.Get(keyUserID)
 		if !ok {
 			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
@@ -120,7 +553,55 @@ func (ah *AuthHandlers) UserInfo() gin.HandlerFunc {
 			return
 		}
 
-		ctx.JSON(http.StatusOK, user2UserInfoResponse(user))
+		ctx.JSON(http.StatusOK, user2UserInfoResponse(user, scope))
+	}
+}
+
+// Discovery returns the OpenID Connect provider metadata. The openid scope is only supported with an
+// asymmetric signing algorithm, as the clients verify the id tokens with the public keys.
+func (ah *AuthHandlers) Discovery() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		issuer := strings.TrimSuffix(ah.tc.Issuer, "/")
+		scopes := []string{auth.ScopeProfile, auth.ScopeEmail}
+		algs := []string{}
+		if auth.IsAsymmetric(ah.cfg.SigningAlg) {
+			scopes = append([]string{auth.ScopeOpenID}, scopes...)
+			algs = append(algs, ah.cfg.SigningAlg)
+		}
+
+		ctx.JSON(http.StatusOK, &DiscoveryResponse{
+			Issuer:                 issuer,
+			AuthorizationEndpoint:  issuer + authorizePath,
+			TokenEndpoint:          issuer + apiPath + tokenPath,
+			UserInfoEndpoint:       issuer + apiPath + userInfoPath,
+			JWKSURI:                issuer + jwksPath,
+			IntrospectionEndpoint:  issuer + apiPath + introspectPath,
+			RevocationEndpoint:     issuer + apiPath + revokePath,
+			ScopesSupported:        scopes,
+			ResponseTypesSupported: []string{responseTypeCode},
+			GrantTypesSupported: []string{
+				grantTypeAuthorizationCode,
+				grantTypeRefreshToken,
+				grantTypeClientCredentials,
+			},
+			SubjectTypesSupported:             []string{"public"},
+			IDTokenSigningAlgValuesSupported:  algs,
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			CodeChallengeMethodsSupported:     []string{auth.CodeChallengeMethodS256},
+			ClaimsSupported: []string{
+				"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+				"preferred_username", "name", "email",
+			},
+		})
+	}
+}
+
+// JWKS returns the public keys for verifying the JWTs issued by the service. The keys may be cached
+// as long as a new key is published before it signs.
+func (ah *AuthHandlers) JWKS() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", ah.cfg.JWKSCacheTTL))
+		ctx.JSON(http.StatusOK, ah.tc.Keys.PublicKeys())
 	}
 }
 
@@ -1,11 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +15,8 @@ import (
 	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/cybersamx/authx/pkg/config"
 	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/memory"
 	"github.com/gavv/httpexpect/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,8 +32,12 @@ var (
 
 func newTestAccessToken(t *testing.T, cfg *config.Config, targetDate time.Time) *models.AccessToken {
 	distantTTL := time.Until(targetDate)
-	at, err := auth.NewAccessToken(testUser.ID, cfg.AccessSecret, distantTTL)
+	at, err := auth.NewAccessToken(NewTokenConfig(cfg, testapp.Store), &auth.AccessTokenParams{
+		UserID: testUser.ID,
+		TTL:    distantTTL,
+	})
 	assert.NoError(t, err)
+	require.NoError(t, testapp.Store.SaveAccessToken(context.Background(), at))
 
 	return at
 }
@@ -104,6 +112,147 @@ func Test_PostSignIn(t *testing.T) {
 	assert.NotEmpty(t, rt)
 }
 
+func Test_SignIn_UniformError(t *testing.T) {
+	// Setup
+	setSignInThrottle(t, 0, 0, 0)
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	unknown := signIn(expect, newTestUsername(), testUser.Password).Status(http.StatusUnauthorized)
+	wrong := signIn(expect, testUser.Username, "wrong password").Status(http.StatusUnauthorized)
+	assert.Equal(t, wrong.Body().Raw(), unknown.Body().Raw())
+
+	browser := newBrowserHTTPExpect(t)
+	for _, username := range []string{newTestUsername(), testUser.Username} {
+		browser.POST("/").
+			WithFormField("username", username).
+			WithFormField("password", "wrong password").
+			Expect().
+			Status(http.StatusOK).
+			Body().Contains("Invalid username or password").NotContains("User not found")
+	}
+}
+
+// setMFARequiredRoles sets the roles of the users who must sign in with a second factor.
+func setMFARequiredRoles(t *testing.T, roles ...string) {
+	prev := testapp.Config.MFARequiredRoles
+	testapp.Config.MFARequiredRoles = roles
+	t.Cleanup(func() {
+		testapp.Config.MFARequiredRoles = prev
+	})
+}
+
+// setSigningAlg sets the signing algorithm that the handlers see, while the JWTs are still signed with
+// the key of the test app.
+func setSigningAlg(t *testing.T, alg string) {
+	prev := testapp.Config.SigningAlg
+	testapp.Config.SigningAlg = alg
+	t.Cleanup(func() {
+		testapp.Config.SigningAlg = prev
+	})
+}
+
+// setSignInThrottle sets the limits on the failed sign-ins, and forgets the failures of the test
+// client afterwards so that they don't throttle the other tests.
+func setSignInThrottle(t *testing.T, free, max, maxIP int) {
+	cfg := testapp.Config
+	prev := *cfg
+	cfg.SignInFreeFailures, cfg.SignInMaxFailures, cfg.SignInIPFailures = free, max, maxIP
+	t.Cleanup(func() {
+		cfg.SignInFreeFailures, cfg.SignInMaxFailures, cfg.SignInIPFailures =
+			prev.SignInFreeFailures, prev.SignInMaxFailures, prev.SignInIPFailures
+		require.NoError(t, auth.UnlockIP(context.Background(), testapp.Store, "127.0.0.1"))
+	})
+}
+
+func signIn(expect *httpexpect.Expect, username, password string) *httpexpect.Response {
+	return expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": password}).
+		Expect()
+}
+
+func Test_SignIn_Backoff(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	setSignInThrottle(t, 1, 0, 0)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+
+	// Run and validate
+	signIn(expect, username, "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, username, "correct horse").Status(http.StatusOK)
+
+	// A successful sign-in resets the failures.
+	signIn(expect, username, "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, username, "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, username, "correct horse").
+		Status(http.StatusTooManyRequests).
+		Header("Retry-After").Equal("1")
+
+	time.Sleep(time.Second)
+	signIn(expect, username, "correct horse").Status(http.StatusOK)
+}
+
+func Test_SignIn_Lockout(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	setSignInThrottle(t, 0, 2, 0)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+
+	// Run and validate
+	signIn(expect, username, "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, username, "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, username, "correct horse").
+		Status(http.StatusTooManyRequests).
+		Header("Retry-After").Equal(strconv.Itoa(testapp.Config.SignInLockout))
+
+	// Until an admin unlocks the user.
+	require.NoError(t, auth.UnlockAccount(context.Background(), testapp.Store, username))
+	signIn(expect, username, "correct horse").Status(http.StatusOK)
+}
+
+func Test_SignIn_IPLockout(t *testing.T) {
+	// Setup
+	setSignInThrottle(t, 0, 0, 2)
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	signIn(expect, newTestUsername(), "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, newTestUsername(), "wrong password").Status(http.StatusUnauthorized)
+	signIn(expect, testUser.Username, testUser.Password).
+		Status(http.StatusTooManyRequests).
+		Header("Retry-After").NotEmpty()
+}
+
+func Test_SignIn_ThrottledPage(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	setSignInThrottle(t, 0, 1, 0)
+	expect := newBrowserHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	t.Cleanup(func() {
+		require.NoError(t, auth.UnlockAccount(context.Background(), testapp.Store, username))
+	})
+
+	// Run and validate
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "wrong password").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Invalid username or password")
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Too many failed sign-in attempts. Please try again in 15 minutes.")
+}
+
 func Test_SignOutHandler(t *testing.T) {
 	// Setup
 	expect := newHTTPExpect(t)
@@ -132,6 +281,9 @@ func Test_ProfileHandler_ValidAccessToken(t *testing.T) {
 	assert.NotNil(t, obj)
 	assert.Equal(t, obj.Value("username").String().Raw(), "chan")
 	assert.Equal(t, obj.Value("id").String().Raw(), "1")
+	obj.ValueEqual("sub", "1")
+	obj.ValueEqual("preferred_username", "chan")
+	obj.ValueEqual("email", "chan@example.com")
 }
 
 func Test_ProfileHandler_ExpiredAccessToken(t *testing.T) {
@@ -145,3 +297,637 @@ func Test_ProfileHandler_ExpiredAccessToken(t *testing.T) {
 		Expect().
 		Status(http.StatusUnauthorized)
 }
+
+func Test_SignOutHandler_RevokesAccessToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at := signInTestUser(t, expect).Value("access_token").String().Raw()
+	bearer := fmt.Sprintf("Bearer %s", at)
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusOK)
+
+	// Run
+	expect.POST("/v1/signout").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusOK)
+
+	// Validate
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_SignOutHandler_ForgedAccessToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at := signInTestUser(t, expect).Value("access_token").String().Raw()
+	chunks := strings.Split(at, ".")
+	forged := chunks[0] + "." + chunks[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged"))
+
+	// Run
+	expect.POST("/v1/signout").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", forged)).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// Validate that the sign-in of the token id isn't signed out.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusOK)
+}
+
+func Test_SignOutHandler_ExpiredAccessToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at := newExpiredAccessToken(t, testapp.Config)
+
+	// Run and validate
+	expect.POST("/v1/signout").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at.Value)).
+		Expect().
+		Status(http.StatusOK).
+		Body().Equal(`"logout"`)
+}
+
+func Test_ProfileHandler_RevokedAccessToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at := newValidAccessToken(t, testapp.Config)
+	require.NoError(t, testapp.Store.RemoveAccessToken(context.Background(), at.ID))
+
+	// Run
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at.Value)).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func signInTestUser(t *testing.T, expect *httpexpect.Expect) *httpexpect.Object {
+	userJSON, err := json.Marshal(testUser)
+	require.NoError(t, err)
+
+	return expect.POST("/v1/signin").
+		WithBytes(userJSON).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+}
+
+func Test_Token_RefreshTokenGrant(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	rt := signInTestUser(t, expect).Value("refresh_token").String().Raw()
+
+	// Run
+	obj := expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	assert.NotEmpty(t, obj.Value("access_token").String().Raw())
+	assert.True(t, testExpiry(t, obj))
+	assert.Equal(t, rt, obj.Value("refresh_token").String().Raw())
+}
+
+func Test_Token_RefreshTokenGrant_Rotate(t *testing.T) {
+	// Setup
+	testapp.Config.RefreshTokenRotate = true
+	defer func() {
+		testapp.Config.RefreshTokenRotate = false
+	}()
+	expect := newHTTPExpect(t)
+	rt := signInTestUser(t, expect).Value("refresh_token").String().Raw()
+
+	// Run
+	obj := expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate that a new refresh token is issued.
+	newRT := obj.Value("refresh_token").String().Raw()
+	assert.NotEmpty(t, newRT)
+	assert.NotEqual(t, rt, newRT)
+
+	// Replaying the rotated-out refresh token revokes the whole family.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", newRT).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_Token_RefreshTokenGrant_OtherClient(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	rt := signInTestUser(t, expect).Value("refresh_token").String().Raw()
+
+	// Run and validate that the refresh token of a sign-in can't be redeemed by a client.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		WithFormField("client_id", "spa").
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/token").
+		WithBasicAuth("spa", "wrong-secret").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_Token_InvalidRequest(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", "unknown-token").
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "password").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_JWKS(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run
+	obj := expect.GET("/.well-known/jwks.json").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate that only the public part of the signing key is published.
+	key := obj.Value("keys").Array().Element(0).Object()
+	key.ValueEqual("alg", testapp.Config.SigningAlg)
+	key.NotContainsKey("d")
+}
+
+func Test_SeedClientData_Debug(t *testing.T) {
+	// Setup
+	ctx := context.Background()
+	ds := memory.New()
+	cfg := *testapp.Config
+	cfg.Debug = true
+	require.NoError(t, SeedClientData(&cfg, ds))
+
+	// Run
+	cfg.Debug = false
+	require.NoError(t, SeedClientData(&cfg, ds))
+
+	// Validate that the clients with a public secret are only kept in debug mode.
+	for _, id := range []string{"service", "gateway"} {
+		_, err := ds.GetClient(ctx, id)
+		assert.Equal(t, store.ErrorNotFound, err)
+	}
+	_, err := ds.GetClient(ctx, testClientID)
+	assert.NoError(t, err)
+
+	// A client registered with the same id keeps its own secret.
+	client := models.Client{ID: "gateway", Scopes: []string{auth.ScopeIntrospect}}
+	secret, err := auth.RegisterClient(ctx, ds, &client, true, NewPasswordParams(&cfg))
+	require.NoError(t, err)
+	require.NoError(t, SeedClientData(&cfg, ds))
+	_, err = auth.AuthenticateClient(ctx, ds, "gateway", secret)
+	assert.NoError(t, err)
+}
+
+func Test_Introspect(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	otoken := signInTestUser(t, expect)
+	at := otoken.Value("access_token").String().Raw()
+	rt := otoken.Value("refresh_token").String().Raw()
+
+	// Run and validate access token
+	obj := expect.POST("/v1/introspect").
+		WithBasicAuth("gateway", "gateway-secret").
+		WithFormField("token", at).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	obj.ValueEqual("active", true)
+	obj.ValueEqual("token_type", "access_token")
+	obj.ValueEqual("sub", testUser.ID)
+	obj.Value("jti").String().NotEmpty()
+	obj.Value("exp").Number().Gt(0)
+	obj.Value("iat").Number().Gt(0)
+
+	// Run and validate refresh token
+	obj = expect.POST("/v1/introspect").
+		WithFormField("client_id", "gateway").
+		WithFormField("client_secret", "gateway-secret").
+		WithFormField("token", rt).
+		WithFormField("token_type_hint", "refresh_token").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	obj.ValueEqual("active", true)
+	obj.ValueEqual("token_type", "refresh_token")
+	obj.ValueEqual("sub", testUser.ID)
+	obj.ValueEqual("jti", rt)
+}
+
+func Test_Introspect_InactiveToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at := signInTestUser(t, expect).Value("access_token").String().Raw()
+	expect.POST("/v1/signout").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusOK)
+
+	// Run and validate
+	for _, token := range []string{at, "unknown-token"} {
+		obj := expect.POST("/v1/introspect").
+			WithBasicAuth("gateway", "gateway-secret").
+			WithFormField("token", token).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object()
+		obj.Equal(map[string]interface{}{"active": false})
+	}
+}
+
+func Test_Introspect_InvalidClient(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/introspect").
+		WithFormField("token", "unknown-token").
+		Expect().
+		Status(http.StatusUnauthorized)
+	expect.POST("/v1/introspect").
+		WithBasicAuth("gateway", "wrong-secret").
+		WithFormField("token", "unknown-token").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// The client isn't allowed to introspect tokens.
+	expect.POST("/v1/introspect").
+		WithBasicAuth("service", "service-secret").
+		WithFormField("token", "unknown-token").
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_Revoke_RefreshToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	otoken := signInTestUser(t, expect)
+	at := otoken.Value("access_token").String().Raw()
+	rt := otoken.Value("refresh_token").String().Raw()
+
+	// Run
+	expect.POST("/v1/revoke").
+		WithFormField("token", rt).
+		WithFormField("token_type_hint", "refresh_token").
+		Expect().
+		Status(http.StatusOK)
+
+	// Validate that the access token derived from the refresh token is revoked too.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_Revoke_AccessToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	otoken := signInTestUser(t, expect)
+	at := otoken.Value("access_token").String().Raw()
+	rt := otoken.Value("refresh_token").String().Raw()
+
+	// Run without a hint.
+	expect.POST("/v1/revoke").
+		WithFormField("token", at).
+		Expect().
+		Status(http.StatusOK)
+
+	// Validate that only the access token is revoked.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusUnauthorized)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusOK)
+}
+
+func Test_Revoke_OtherClient(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	otoken := signInTestUser(t, expect)
+	at := otoken.Value("access_token").String().Raw()
+	rt := otoken.Value("refresh_token").String().Raw()
+
+	// Run and validate that a client can't revoke the tokens of a sign-in.
+	for _, token := range []string{at, rt} {
+		expect.POST("/v1/revoke").
+			WithBasicAuth("service", "service-secret").
+			WithFormField("token", token).
+			Expect().
+			Status(http.StatusOK)
+	}
+	expect.POST("/v1/revoke").
+		WithBasicAuth("service", "wrong-secret").
+		WithFormField("token", rt).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusOK)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusOK)
+}
+
+func Test_Revoke_InvalidToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/revoke").
+		WithFormField("token", "unknown-token").
+		WithFormField("token_type_hint", "refresh_token").
+		Expect().
+		Status(http.StatusOK)
+	expect.POST("/v1/revoke").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_SignOutHandler_RevokesRefreshToken(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	otoken := signInTestUser(t, expect)
+	at := otoken.Value("access_token").String().Raw()
+	rt := otoken.Value("refresh_token").String().Raw()
+
+	// Run
+	expect.POST("/v1/signout").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusOK)
+
+	// Validate
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_Token_ClientCredentialsGrant(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run
+	obj := expect.POST("/v1/token").
+		WithBasicAuth("service", "service-secret").
+		WithFormField("grant_type", "client_credentials").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	obj.NotContainsKey("refresh_token")
+	at := obj.Value("access_token").String().Raw()
+	claims := parseChunkFromJWT(t, at, 1)
+	claims.Value("client_id").String().Equal("service")
+	claims.Value("scope").String().Equal("users:read")
+	claims.NotContainsKey("uid")
+
+	expect.POST("/v1/introspect").
+		WithBasicAuth("gateway", "gateway-secret").
+		WithFormField("token", at).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("active", true).
+		ValueEqual("client_id", "service").
+		ValueEqual("sub", "service").
+		ValueEqual("scope", "users:read")
+}
+
+func Test_Token_ClientCredentialsGrant_InvalidClient(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/token").
+		WithBasicAuth("service", "wrong-secret").
+		WithFormField("grant_type", "client_credentials").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// The client isn't allowed to use the grant.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "client_credentials").
+		WithFormField("client_id", "spa").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// The scope isn't registered for the client.
+	expect.POST("/v1/token").
+		WithBasicAuth("service", "service-secret").
+		WithFormField("grant_type", "client_credentials").
+		WithFormField("scope", "users:write").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_Discovery(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	issuer := testapp.Config.Issuer
+
+	// Run
+	obj := expect.GET("/.well-known/openid-configuration").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	obj.ValueEqual("issuer", issuer)
+	obj.ValueEqual("authorization_endpoint", issuer+"/oauth2/authorize")
+	obj.ValueEqual("token_endpoint", issuer+"/v1/token")
+	obj.ValueEqual("userinfo_endpoint", issuer+"/v1/userinfo")
+	obj.ValueEqual("jwks_uri", issuer+"/.well-known/jwks.json")
+	obj.Value("scopes_supported").Array().Contains("openid")
+	obj.Value("code_challenge_methods_supported").Array().Equal([]string{"S256"})
+	obj.Value("id_token_signing_alg_values_supported").Array().Equal([]string{testapp.Config.SigningAlg})
+
+	// The id tokens can't be verified by the clients if they are signed with HS256.
+	setSigningAlg(t, "HS256")
+	obj = expect.GET("/.well-known/openid-configuration").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	obj.Value("scopes_supported").Array().NotContains("openid")
+	obj.Value("id_token_signing_alg_values_supported").Array().Empty()
+}
+
+func Test_Token_ScopeKeptOnRefresh(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	code := authorizeTestUser(t, expect, decisionAllow).Query().Get("code")
+	rt := exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("refresh_token").String().Raw()
+
+	// Run
+	at := expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		WithFormField("client_id", testClientID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().Raw()
+
+	// Validate
+	parseChunkFromJWT(t, at, 1).Value("scope").String().Equal("openid profile")
+}
+
+func Test_Token_RefreshTokenGrant_AuthCodeClient(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	code := authorizeTestUser(t, expect, decisionAllow).Query().Get("code")
+	rt := exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("refresh_token").String().Raw()
+
+	// Run and validate that only the client can redeem the refresh token.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/token").
+		WithBasicAuth("service", "service-secret").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		WithFormField("client_id", testClientID).
+		Expect().
+		Status(http.StatusOK)
+}
+
+func Test_UserInfo_ClientScope(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	at, err := auth.NewAccessToken(NewTokenConfig(testapp.Config, testapp.Store), &auth.AccessTokenParams{
+		UserID:   testUser.ID,
+		ClientID: testClientID,
+		Scope:    "profile email",
+		TTL:      time.Minute,
+	})
+	require.NoError(t, err)
+	require.NoError(t, testapp.Store.SaveAccessToken(context.Background(), at))
+
+	// Run and validate that the access token of a client needs the openid scope.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", "Bearer "+at.Value).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func Test_AccountAPI_DelegatedAccessToken(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	code := authorizeTestUser(t, expect, decisionAllow).Query().Get("code")
+	at := exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().Raw()
+	bearer := fmt.Sprintf("Bearer %s", at)
+
+	// Run and validate that the access token of a client reads the user info of its scope but can't
+	// manage the account.
+	obj := expect.GET("/v1/userinfo").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	obj.ValueEqual("preferred_username", testUser.Username)
+	obj.NotContainsKey("email")
+	expect.PUT("/v1/me/password").
+		WithHeader("Authorization", bearer).
+		WithJSON(map[string]string{"current_password": testUser.Password, "new_password": "battery staple"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.POST("/v1/me/webauthn/register/begin").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.GET("/v1/me/webauthn/credentials").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusForbidden)
+	signInTestUser(t, expect)
+}
+
+func Test_SignIn_CustomClaims(t *testing.T) {
+	// Setup
+	setMFARequiredRoles(t)
+	expect := newHTTPExpect(t)
+
+	// Run
+	at := expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": "admin", "password": "secret"}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().Raw()
+
+	// Validate
+	claims := parseChunkFromJWT(t, at, 1)
+	claims.Value("roles").Array().Equal([]string{"admin"})
+	claims.Value("iss").String().Equal(testapp.Config.Issuer)
+	claims.Value("sub").String().Equal("0")
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	consentTmplName  = "consent"
+	responseTypeCode = "code"
+	decisionAllow    = "allow"
+
+	// Error codes as defined in https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+	authorizeErrInvalidRequest          = "invalid_request"
+	authorizeErrUnauthorizedClient      = "unauthorized_client"
+	authorizeErrAccessDenied            = "access_denied"
+	authorizeErrUnsupportedResponseType = "unsupported_response_type"
+	authorizeErrInvalidScope            = "invalid_scope"
+	authorizeErrServerError             = "server_error"
+)
+
+var (
+	ErrInvalidAuthorizeRequest = errors.New("invalid client id or redirect uri")
+	ErrInvalidConsent          = errors.New("invalid consent")
+)
+
+// getSession returns the session of the signed-in user if the session is still valid, or nil if the
+// user is signed out. A session whose access token has been revoked, eg. after a password reset, is
+// signed out.
+func (hh *HTMLHandlers) getSession(ctx *gin.Context) (*SessionToken, error) {
+	ss := NewCookieStore(hh.cfg.SessionSecret)
+	us, err := ss.GetSessionToken(ctx.Request)
+	if err != nil || us == nil {
+		return nil, nil
+	}
+
+	claims, err := auth.ParseJWT(us.Token.AccessToken, hh.tc)
+	if err != nil || claims.UserID != us.UserID {
+		return nil, nil
+	}
+	revoked, err := hh.checker.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, nil
+	}
+
+	return us, nil
+}
+
+// getConsentToken returns the token that binds the consent form to the signed-in user and the
+// authorization request, so that the consent can't be forged by another site.
+func (hh *HTMLHandlers) getConsentToken(uid string, areq *AuthorizeRequest) string {
+	mac := hmac.New(sha256.New, []byte(hh.cfg.SessionSecret))
+	fields := []string{
+		uid,
+		areq.ClientID,
+		areq.RedirectURI,
+		areq.Scope,
+		areq.State,
+		areq.CodeChallenge,
+		areq.CodeChallengeMethod,
+		areq.Nonce,
+	}
+	_, _ = mac.Write([]byte(strings.Join(fields, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redirectToClient redirects the user agent back to the client with the params and the state.
+func redirectToClient(ctx *gin.Context, areq *AuthorizeRequest, params url.Values) {
+	redirectURL, err := url.Parse(areq.RedirectURI)
+	if err != nil {
+		setErrorStatus(ctx, err, http.StatusBadRequest)
+		return
+	}
+
+	query := redirectURL.Query()
+	for key, vals := range params {
+		query[key] = vals
+	}
+	if areq.State != "" {
+		query.Set("state", areq.State)
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	ctx.Redirect(http.StatusFound, redirectURL.String())
+}
+
+func redirectErrorToClient(ctx *gin.Context, areq *AuthorizeRequest, code string) {
+	redirectToClient(ctx, areq, url.Values{"error": []string{code}})
+}
+
+// Authorize is the OAuth2 authorization endpoint for the authorization code grant with PKCE.
+func (hh *HTMLHandlers) Authorize() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// GET  = validates the authorization request and displays the consent page.
+		// POST = handles the consent form submission.
+		var areq AuthorizeRequest
+		if err := ctx.ShouldBind(&areq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// Never redirect to an unregistered redirect uri, show the error to the user instead.
+		client, err := hh.ds.GetClient(ctx, areq.ClientID)
+		if err == store.ErrorNotFound || (err == nil && !client.HasRedirectURI(areq.RedirectURI)) {
+			setErrorStatus(ctx, ErrInvalidAuthorizeRequest, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		if areq.ResponseType != responseTypeCode {
+			redirectErrorToClient(ctx, &areq, authorizeErrUnsupportedResponseType)
+			return
+		}
+		if !client.HasGrantType(grantTypeAuthorizationCode) {
+			redirectErrorToClient(ctx, &areq, authorizeErrUnauthorizedClient)
+			return
+		}
+		scope, err := auth.GrantScope(client, areq.Scope)
+		if err != nil {
+			redirectErrorToClient(ctx, &areq, authorizeErrInvalidScope)
+			return
+		}
+		// The id tokens are only issued if the clients can verify them with the public keys.
+		if auth.HasScope(scope, auth.ScopeOpenID) && !auth.IsAsymmetric(hh.cfg.SigningAlg) {
+			redirectErrorToClient(ctx, &areq, authorizeErrInvalidScope)
+			return
+		}
+		if err := auth.VerifyCodeChallengeParams(areq.CodeChallenge, areq.CodeChallengeMethod); err != nil {
+			redirectErrorToClient(ctx, &areq, authorizeErrInvalidRequest)
+			return
+		}
+
+		// Sign in first and come back.
+		us, err := hh.getSession(ctx)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		if us == nil {
+			signinURL := url.URL{
+				Path:     rootURI,
+				RawQuery: url.Values{"next": []string{ctx.Request.URL.RequestURI()}}.Encode(),
+			}
+			ctx.Redirect(http.StatusFound, signinURL.String())
+			return
+		}
+
+		if ctx.Request.Method == http.MethodGet {
+			content := gin.H{
+				"Request":      areq,
+				"ClientName":   client.Name,
+				"Scopes":       strings.Fields(scope),
+				"ConsentToken": hh.getConsentToken(us.UserID, &areq),
+			}
+
+			ctx.HTML(http.StatusOK, consentTmplName, content)
+			return
+		} else if ctx.Request.Method != http.MethodPost {
+			setErrorStatus(ctx, ErrMethodNotSupported, http.StatusMethodNotAllowed)
+			return
+		}
+
+		consentToken := hh.getConsentToken(us.UserID, &areq)
+		if !hmac.Equal([]byte(consentToken), []byte(ctx.PostForm("consent_token"))) {
+			setErrorStatus(ctx, ErrInvalidConsent, http.StatusForbidden)
+			return
+		}
+		if ctx.PostForm("decision") != decisionAllow {
+			redirectErrorToClient(ctx, &areq, authorizeErrAccessDenied)
+			return
+		}
+
+		params := auth.AuthCodeParams{
+			ClientID:            areq.ClientID,
+			UserID:              us.UserID,
+			RedirectURI:         areq.RedirectURI,
+			Scope:               scope,
+			CodeChallenge:       areq.CodeChallenge,
+			CodeChallengeMethod: areq.CodeChallengeMethod,
+			Nonce:               areq.Nonce,
+			AuthTime:            us.AuthTime,
+		}
+		code, err := auth.CreateAuthCode(ctx, hh.ds, &params)
+		if err != nil {
+			redirectErrorToClient(ctx, &areq, authorizeErrServerError)
+			return
+		}
+
+		redirectToClient(ctx, &areq, url.Values{"code": []string{code}})
+	}
+}
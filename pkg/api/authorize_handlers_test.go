@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testClientID     = "spa"
+	testRedirectURI  = "http://localhost:3000/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newBrowserHTTPExpect returns a client that keeps the cookies and doesn't follow redirects.
+func newBrowserHTTPExpect(t *testing.T) *httpexpect.Expect {
+	srv := httptest.NewServer(testapp.Router)
+
+	cfg := httpexpect.Config{
+		BaseURL:  srv.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+		Client: &http.Client{
+			Jar: httpexpect.NewJar(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	return httpexpect.WithConfig(cfg)
+}
+
+func authorizeQuery() map[string]string {
+	return map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURI,
+		"scope":                 "openid profile",
+		"state":                 "xyz",
+		"nonce":                 "n-0S6_WzA2Mj",
+		"code_challenge":        auth.CodeChallengeS256(testCodeVerifier),
+		"code_challenge_method": auth.CodeChallengeMethodS256,
+	}
+}
+
+func newAuthorizeRequest(expect *httpexpect.Expect, method string) *httpexpect.Request {
+	req := expect.Request(method, "/oauth2/authorize")
+	for key, val := range authorizeQuery() {
+		if method == http.MethodGet {
+			req = req.WithQuery(key, val)
+		} else {
+			req = req.WithFormField(key, val)
+		}
+	}
+
+	return req
+}
+
+func signInBrowser(expect *httpexpect.Expect) {
+	expect.POST("/").
+		WithFormField("username", testUser.Username).
+		WithFormField("password", testUser.Password).
+		Expect().
+		Status(http.StatusMovedPermanently)
+}
+
+// authorizeTestUser runs the authorization request and returns the redirect to the client.
+func authorizeTestUser(t *testing.T, expect *httpexpect.Expect, decision string) *url.URL {
+	signInBrowser(expect)
+
+	body := newAuthorizeRequest(expect, http.MethodGet).
+		Expect().
+		Status(http.StatusOK).
+		Body()
+	body.Contains(testClientID)
+	match := body.Match(`name="consent_token" value="([0-9a-f]+)"`)
+	consentToken := match.Index(1).Raw()
+
+	loc := newAuthorizeRequest(expect, http.MethodPost).
+		WithFormField("consent_token", consentToken).
+		WithFormField("decision", decision).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	redirectURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, testRedirectURI, redirectURL.Scheme+"://"+redirectURL.Host+redirectURL.Path)
+	assert.Equal(t, "xyz", redirectURL.Query().Get("state"))
+
+	return redirectURL
+}
+
+func exchangeAuthCode(expect *httpexpect.Expect, code, verifier string) *httpexpect.Response {
+	return expect.POST("/v1/token").
+		WithFormField("grant_type", "authorization_code").
+		WithFormField("code", code).
+		WithFormField("client_id", testClientID).
+		WithFormField("redirect_uri", testRedirectURI).
+		WithFormField("code_verifier", verifier).
+		Expect()
+}
+
+func Test_Authorize_AuthorizationCodeGrant(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run
+	code := authorizeTestUser(t, expect, decisionAllow).Query().Get("code")
+	require.NotEmpty(t, code)
+	obj := exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	at := obj.Value("access_token").String().Raw()
+	assert.NotEmpty(t, at)
+	assert.NotEmpty(t, obj.Value("refresh_token").String().Raw())
+	claims := parseChunkFromJWT(t, at, 1)
+	claims.Value("uid").String().Equal(testUser.ID)
+	claims.Value("scope").String().Equal("openid profile")
+
+	// The id token is issued for the openid scope.
+	idToken := parseChunkFromJWT(t, obj.Value("id_token").String().Raw(), 1)
+	idToken.Value("iss").String().Equal(testapp.Config.Issuer)
+	idToken.Value("sub").String().Equal(testUser.ID)
+	idToken.Value("aud").Equal(testClientID)
+	idToken.Value("nonce").String().Equal("n-0S6_WzA2Mj")
+	idToken.Value("preferred_username").String().Equal(testUser.Username)
+	idToken.ContainsKey("auth_time")
+	idToken.ContainsKey("at_hash")
+	idToken.NotContainsKey("email")
+
+	// An authorization code can only be redeemed once.
+	exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusBadRequest)
+}
+
+func Test_Authorize_InvalidCodeVerifier(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	code := authorizeTestUser(t, expect, decisionAllow).Query().Get("code")
+
+	// Run and validate
+	exchangeAuthCode(expect, code, "wM2Rk5W7fs9oQXDU8jkLPBnhG3vcZ4Ttq1NEIaSYbyd").
+		Status(http.StatusBadRequest)
+
+	// The code is consumed by the failed attempt.
+	exchangeAuthCode(expect, code, testCodeVerifier).
+		Status(http.StatusBadRequest)
+}
+
+func Test_Authorize_Deny(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run
+	redirectURL := authorizeTestUser(t, expect, "deny")
+
+	// Validate
+	assert.Equal(t, "access_denied", redirectURL.Query().Get("error"))
+	assert.Empty(t, redirectURL.Query().Get("code"))
+}
+
+func Test_Authorize_RequiresSignIn(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run
+	loc := newAuthorizeRequest(expect, http.MethodGet).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	// Validate
+	signinURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, "/", signinURL.Path)
+	assert.Contains(t, signinURL.Query().Get("next"), "/oauth2/authorize?")
+}
+
+func Test_Authorize_RevokedSession(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	signInBrowser(expect)
+	_, err := testapp.Store.RemoveAccessTokensByUser(context.Background(), testUser.ID, "")
+	require.NoError(t, err)
+
+	// Run
+	loc := newAuthorizeRequest(expect, http.MethodGet).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	// Validate that the user is asked to sign in again.
+	signinURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, "/", signinURL.Path)
+}
+
+func Test_Authorize_RequiresPKCE(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run
+	loc := expect.GET("/oauth2/authorize").
+		WithQuery("response_type", "code").
+		WithQuery("client_id", testClientID).
+		WithQuery("redirect_uri", testRedirectURI).
+		WithQuery("code_challenge", auth.CodeChallengeS256(testCodeVerifier)).
+		WithQuery("code_challenge_method", "plain").
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	// Validate
+	redirectURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_request", redirectURL.Query().Get("error"))
+}
+
+func Test_Authorize_UnregisteredRedirectURI(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run and validate
+	expect.GET("/oauth2/authorize").
+		WithQuery("response_type", "code").
+		WithQuery("client_id", testClientID).
+		WithQuery("redirect_uri", "http://evil.example.com/callback").
+		WithQuery("code_challenge", auth.CodeChallengeS256(testCodeVerifier)).
+		WithQuery("code_challenge_method", auth.CodeChallengeMethodS256).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_Authorize_UnknownClient(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run and validate
+	expect.GET("/oauth2/authorize").
+		WithQuery("response_type", "code").
+		WithQuery("client_id", "unknown").
+		WithQuery("redirect_uri", testRedirectURI).
+		WithQuery("code_challenge", auth.CodeChallengeS256(testCodeVerifier)).
+		WithQuery("code_challenge_method", auth.CodeChallengeMethodS256).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_Authorize_InvalidScope(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+
+	// Run
+	loc := expect.GET("/oauth2/authorize").
+		WithQuery("response_type", "code").
+		WithQuery("client_id", testClientID).
+		WithQuery("redirect_uri", testRedirectURI).
+		WithQuery("scope", "admin").
+		WithQuery("code_challenge", auth.CodeChallengeS256(testCodeVerifier)).
+		WithQuery("code_challenge_method", auth.CodeChallengeMethodS256).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	// Validate
+	redirectURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_scope", redirectURL.Query().Get("error"))
+}
+
+func Test_Authorize_OpenIDSymmetricKey(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	setSigningAlg(t, "HS256")
+
+	// Run
+	loc := newAuthorizeRequest(expect, http.MethodGet).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+
+	// Validate that the id tokens aren't issued if the clients can't verify them.
+	redirectURL, err := url.Parse(loc)
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_scope", redirectURL.Query().Get("error"))
+}
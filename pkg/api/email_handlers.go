@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/mail"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	verifyEmailTmplName = "verify_email"
+
+	mailerSMTP = "smtp"
+	mailerFile = "file"
+	mailerLog  = "log"
+)
+
+var (
+	ErrUnsupportedMailer = errors.New("unsupported mailer")
+	ErrMissingEmail      = errors.New("user has no email address")
+)
+
+// NewMailer returns the mailer for sending the emails to the users.
+func NewMailer(cfg *config.Config) (mail.Mailer, error) {
+	switch cfg.Mailer {
+	case mailerSMTP:
+		return mail.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword)
+	case mailerFile:
+		return mail.NewFileMailer(cfg.MailFile), nil
+	case mailerLog:
+		return mail.NewLogMailer(), nil
+	}
+
+	return nil, ErrUnsupportedMailer
+}
+
+// newEmailLink returns the link to the page at the path with the token.
+func newEmailLink(cfg *config.Config, path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(cfg.Issuer, "/"), path, url.QueryEscape(token))
+}
+
+// sendLinkEmail sends an email with a link that expires to the user.
+func sendLinkEmail(parent context.Context, cfg *config.Config, user *models.User, subject, action, link string,
+	expireAt time.Time) error {
+	if user.Email == "" {
+		return ErrMissingEmail
+	}
+
+	mailer, err := NewMailer(cfg)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(parent, &mail.Message{
+		From:    cfg.MailFrom,
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nOpen the link below to %s. The link expires at %s.\r\n\r\n%s\r\n",
+			user.Username, action, expireAt.UTC().Format(time.RFC1123), link),
+	})
+}
+
+// sendVerificationEmail sends the link for verifying the email address to the user.
+func sendVerificationEmail(parent context.Context, cfg *config.Config, user *models.User) error {
+	expireAt := time.Now().Add(time.Duration(cfg.VerificationTTL) * time.Second)
+	token := auth.NewVerificationToken(cfg.SessionSecret, user, expireAt)
+
+	return sendLinkEmail(parent, cfg, user, "Verify your email address", "verify your email address",
+		newEmailLink(cfg, verifyEmailPath, token), expireAt)
+}
+
+// VerifyEmail marks the email address in the verification token as verified.
+func (ah *AuthHandlers) VerifyEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var vreq VerifyEmailRequest
+		if err := ctx.ShouldBind(&vreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		user, err := auth.VerifyEmail(ctx, ah.ds, ah.cfg.SessionSecret, vreq.Token)
+		if err == auth.ErrInvalidVerificationToken {
+			setErrorStatus(ctx, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, user2UserInfoResponse(user, firstPartyScope))
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the user of the access token.
+func (ah *AuthHandlers) ResendVerificationEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		if user.Email == "" || user.EmailVerified {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+		if err := sendVerificationEmail(ctx, ah.cfg, user); err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
+
+// VerifyEmail verifies the email address with the link sent to the user.
+func (hh *HTMLHandlers) VerifyEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		content := gin.H{
+			"Verified": false,
+		}
+
+		user, err := auth.VerifyEmail(ctx, hh.ds, hh.cfg.SessionSecret, ctx.Query("token"))
+		if err == nil {
+			content["Verified"] = true
+			content["Email"] = user.Email
+		} else if err != auth.ErrInvalidVerificationToken {
+			log.Printf("failed to verify email: %v\n", err)
+		}
+
+		ctx.HTML(http.StatusOK, verifyEmailTmplName, content)
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the signed-in user and goes back to the
+// profile page.
+func (hh *HTMLHandlers) ResendVerificationEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := hh.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		if user.Email != "" && !user.EmailVerified {
+			if err := sendVerificationEmail(ctx, hh.cfg, user); err != nil {
+				setErrorStatus(ctx, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ctx.Redirect(http.StatusFound, successURI+"?verification=sent")
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/auth"
+)
+
+var (
+	verificationLinkRegexp = regexp.MustCompile(`/verify-email\?token=(\S+)`)
+)
+
+// setFileMailer makes the emails of the test go to a temporary file, and returns the path.
+func setFileMailer(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "mail")
+	require.NoError(t, err)
+
+	prevMailer, prevFile := testapp.Config.Mailer, testapp.Config.MailFile
+	testapp.Config.Mailer = mailerFile
+	testapp.Config.MailFile = filepath.Join(dir, "mail.txt")
+	t.Cleanup(func() {
+		testapp.Config.Mailer, testapp.Config.MailFile = prevMailer, prevFile
+		_ = os.RemoveAll(dir)
+	})
+
+	return testapp.Config.MailFile
+}
+
+// readVerificationToken returns the token in the last verification link sent.
+func readVerificationToken(t *testing.T, path string) string {
+	return readLinkToken(t, path, verificationLinkRegexp)
+}
+
+// readLinkToken returns the token in the last link, matched by the regexp, sent. It waits for the
+// links sent in the background.
+func readLinkToken(t *testing.T, path string, linkRegexp *regexp.Regexp) string {
+	var matches [][]string
+	require.Eventually(t, func() bool {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false
+		}
+		matches = linkRegexp.FindAllStringSubmatch(string(data), -1)
+		return len(matches) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	token, err := url.QueryUnescape(matches[len(matches)-1][1])
+	require.NoError(t, err)
+
+	return token
+}
+
+func signUpWithEmail(expect *httpexpect.Expect, username string) {
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{
+			"username": username,
+			"password": "correct horse",
+			"email":    username + "@example.com",
+		}).
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().
+		ValueEqual("email_verified", false)
+}
+
+func Test_VerifyEmail(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	path := setFileMailer(t)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+
+	// Run
+	token := readVerificationToken(t, path)
+	obj := expect.POST("/v1/verify-email").
+		WithJSON(map[string]string{"token": token}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	obj.ValueEqual("username", username)
+	obj.ValueEqual("email_verified", true)
+
+	expect.POST("/v1/verify-email").
+		WithJSON(map[string]string{"token": token + "x"}).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_VerifyEmailPage(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	path := setFileMailer(t)
+	expect := newBrowserHTTPExpect(t)
+	username := newTestUsername()
+
+	expect.POST("/signup").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		WithFormField("email", username+"@example.com").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Open the link sent to " + username + "@example.com")
+
+	// Run and validate
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusMovedPermanently)
+	expect.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Not verified")
+	expect.POST("/verify-email/resend").
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Equal("/userinfo?verification=sent")
+
+	expect.GET("/verify-email").
+		WithQuery("token", readVerificationToken(t, path)).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Email verified")
+	expect.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Verified").NotContains("Not verified")
+
+	expect.GET("/verify-email").
+		WithQuery("token", "invalid").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Invalid link")
+}
+
+func Test_SignIn_RequireVerifiedEmail(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	path := setFileMailer(t)
+	testapp.Config.RequireVerified = true
+	t.Cleanup(func() {
+		testapp.Config.RequireVerified = false
+	})
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	credentials := map[string]string{"username": username, "password": "correct horse"}
+	setSignInThrottle(t, 0, 2, 0)
+
+	// Run and validate
+	signIn(expect, username, "wrong").Status(http.StatusUnauthorized)
+	expect.POST("/v1/signin").
+		WithJSON(credentials).
+		Expect().
+		Status(http.StatusForbidden)
+
+	// The correct password forgets the failures even though the email address isn't verified.
+	signIn(expect, username, "wrong").Status(http.StatusUnauthorized)
+
+	expect.POST("/v1/verify-email").
+		WithJSON(map[string]string{"token": readVerificationToken(t, path)}).
+		Expect().
+		Status(http.StatusOK)
+	expect.POST("/v1/signin").
+		WithJSON(credentials).
+		Expect().
+		Status(http.StatusOK)
+}
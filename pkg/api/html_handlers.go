@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/models"
 	"github.com/cybersamx/authx/pkg/store"
 
 	"github.com/gin-gonic/gin"
@@ -18,9 +21,17 @@ import (
 
 const (
 	signinTmplName  = "signin"
+	signupTmplName  = "signup"
 	profileTmplName = "userinfo"
 	successURI      = "/userinfo"
 	rootURI         = "/"
+
+	// invalidCredentialsMessage is the same for an unknown username and a wrong password, so that
+	// the sign-in page doesn't reveal which usernames exist.
+	invalidCredentialsMessage = "Invalid username or password"
+
+	// signInAttempts are the attempts that the sign-in throttle counts.
+	signInAttempts = "failed sign-in attempts"
 )
 
 type HTMLHandlers struct {
@@ -28,6 +39,9 @@ type HTMLHandlers struct {
 	validate *validator.Validate
 	cfg      *config.Config
 	ds       store.DataStore
+	tc       *auth.TokenConfig
+	checker  auth.TokenChecker
+	policy   *auth.PasswordPolicy
 }
 
 var (
@@ -36,14 +50,20 @@ var (
 	ErrUserIDCast         = errors.New("can't cast user id to string type")
 )
 
-func NewHTMLHandlers(cfg *config.Config, ds store.DataStore,
-	trans ut.Translator, validate *validator.Validate) *HTMLHandlers {
+func NewHTMLHandlers(cfg *config.Config, ds store.DataStore, tc *auth.TokenConfig, checker auth.TokenChecker,
+	trans ut.Translator, validate *validator.Validate, policy *auth.PasswordPolicy) *HTMLHandlers {
 	handlers := new(HTMLHandlers)
 
 	handlers.cfg = cfg
 	handlers.ds = ds
+	handlers.tc = tc
+	handlers.checker = checker
 	handlers.trans = trans
 	handlers.validate = validate
+	handlers.policy = policy
+
+	// Unknown users are checked against a dummy hash, which is ready before the first sign-in.
+	auth.PrepareDummyPassword(NewPasswordParams(cfg))
 
 	return handlers
 }
@@ -64,63 +84,118 @@ func handleValidationError(err error, trans ut.Translator) []string {
 	return msgs
 }
 
-func handleErrorMessages(ctx *gin.Context, tmplName string, msgs []string) {
+// handleErrorMessages renders the template with the error messages and content, if there are any
+// error messages. Otherwise, it redirects to the redirect uri.
+func handleErrorMessages(ctx *gin.Context, tmplName string, msgs []string, content gin.H, redirectURI string) {
 	if len(msgs) > 0 {
-		content := gin.H{
-			"ErrorMessages": msgs,
+		if content == nil {
+			content = gin.H{}
 		}
+		content["ErrorMessages"] = msgs
 
 		ctx.HTML(http.StatusOK, tmplName, content)
 		return
 	}
 
 	// Redirect if successful
-	ctx.Redirect(http.StatusMovedPermanently, successURI)
+	ctx.Redirect(http.StatusMovedPermanently, redirectURI)
+}
+
+// throttleMessage tells the user how long to wait before trying the throttled attempts, eg. "failed
+// sign-in attempts", again.
+func throttleMessage(attempts string, terr *auth.ThrottleError) string {
+	var wait string
+	switch secs := retryAfterSeconds(terr.RetryAfter); {
+	case secs <= 1:
+		wait = "a second"
+	case secs <= 60:
+		wait = fmt.Sprintf("%d seconds", secs)
+	default:
+		wait = fmt.Sprintf("%d minutes", int(math.Ceil(terr.RetryAfter.Minutes())))
+	}
+
+	return fmt.Sprintf("Too many %s. Please try again in %s.", attempts, wait)
 }
 
-func (hh *HTMLHandlers) postSignIn(ctx *gin.Context) []string {
+// postSignIn signs in the user and returns the error messages, if any, and the content of the page of
+// the second factor if the sign-in must be completed with it.
+func (hh *HTMLHandlers) postSignIn(ctx *gin.Context) ([]string, gin.H) {
 	var msgs []string
 
 	var signin SignInRequest
 	if err := ctx.ShouldBind(&signin); err != nil {
-		return handleValidationError(err, hh.trans)
+		return handleValidationError(err, hh.trans), nil
+	}
+
+	// Hold off the sign-ins of the user, or from the client, after too many failures.
+	throttle := NewSignInThrottle(hh.cfg, hh.ds)
+	if err := throttle.Attempt(ctx, signin.Username, ctx.ClientIP()); err != nil {
+		if terr, ok := err.(*auth.ThrottleError); ok {
+			return append(msgs, throttleMessage(signInAttempts, terr)), nil
+		}
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
 	}
 
-	// Check if user exists
-	user, err := hh.ds.GetUserByUsername(ctx, signin.Username)
-	if user == nil || err == store.ErrorNotFound {
-		return append(msgs, "User not found")
+	// Authenticate, without revealing whether the user exists.
+	user, err := auth.SignInUser(ctx, hh.ds, signin.Username, signin.Password, NewPasswordParams(hh.cfg))
+	if err == auth.ErrInvalidCredentials {
+		return append(msgs, invalidCredentialsMessage), nil
 	} else if err != nil {
-		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+		throttle.Release(ctx, signin.Username, ctx.ClientIP())
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
 	}
+	if hh.cfg.RequireVerified && !user.EmailVerified {
+		// The password is correct all the same.
+		throttle.Succeed(ctx, signin.Username, ctx.ClientIP())
+		return append(msgs, "Verify your email address before signing in"), nil
+	}
+
+	// Complete the sign-in with the second factor, in which case the failures are only forgotten
+	// once it's done.
+	if auth.MFARequired(user, hh.cfg.MFARequiredRoles) {
+		throttle.Release(ctx, signin.Username, ctx.ClientIP())
+		content, err := hh.startMFA(ctx, user, ctx.PostForm("next"))
+		if err != nil {
+			return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
+		}
 
-	// Authenticate
-	if ok := auth.Authenticate(user.Password, signin.Password, user.Salt); !ok {
-		return append(msgs, "Invalid credentials")
+		return msgs, content
 	}
+	throttle.Succeed(ctx, signin.Username, ctx.ClientIP())
 
-	// Strip sensitive data like password.
-	user.RemoveSensitiveData()
+	if err := hh.startSession(ctx, user); err != nil {
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
+	}
 
-	// Generate oauth2 object and save.
-	aTTL := time.Duration(hh.cfg.AccessTTL) * time.Second
-	rTTL := time.Duration(hh.cfg.RefreshTTL) * time.Second
-	otoken, err := auth.CreateOAuthToken(ctx, hh.ds, user.ID, hh.cfg.AccessSecret, aTTL, rTTL)
+	return msgs, nil
+}
+
+// startSession issues the tokens of the signed-in user and saves them to the session cookie.
+func (hh *HTMLHandlers) startSession(ctx *gin.Context, user *models.User) error {
+	otoken, err := createSignInToken(ctx, hh.cfg, hh.ds, hh.tc, user)
 	if err != nil {
-		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+		return err
 	}
 
 	// Save token to the cookie.
 	token := SessionToken{
-		Token:  *otoken,
-		UserID: user.ID,
+		Token:    *otoken,
+		UserID:   user.ID,
+		AuthTime: time.Now(),
 	}
 	ss := NewCookieStore(hh.cfg.SessionSecret)
-	if err := ss.SetSessionToken(ctx.Writer, ctx.Request, &token); err != nil {
-		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+
+	return ss.SetSessionToken(ctx.Writer, ctx.Request, &token)
+}
+
+// getRedirectPath returns the path to redirect to after a sign-in. Only local paths are allowed to
+// prevent open redirects.
+func getRedirectPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return successURI
 	}
 
-	return msgs
+	return next
 }
 
 func (hh *HTMLHandlers) SignIn() gin.HandlerFunc {
@@ -128,12 +203,106 @@ func (hh *HTMLHandlers) SignIn() gin.HandlerFunc {
 		// GET  = displays the page.
 		// POST = handles the form submission.
 		if ctx.Request.Method == http.MethodGet {
-			ctx.HTML(http.StatusOK, signinTmplName, nil)
+			content := gin.H{
+				"Next":   ctx.Query("next"),
+				"SignUp": hh.cfg.SignUp != auth.SignUpDisabled,
+			}
+
+			ctx.HTML(http.StatusOK, signinTmplName, content)
+			return
+		} else if ctx.Request.Method == http.MethodPost {
+			msgs, mfaContent := hh.postSignIn(ctx)
+			if mfaContent != nil {
+				ctx.HTML(http.StatusOK, mfaTmplName, mfaContent)
+				return
+			}
+
+			// Go to the page, eg. an authorization request, that required the sign-in.
+			next := ctx.PostForm("next")
+			content := gin.H{
+				"Next":   next,
+				"SignUp": hh.cfg.SignUp != auth.SignUpDisabled,
+			}
+
+			handleErrorMessages(ctx, signinTmplName, msgs, content, getRedirectPath(next))
+			return
+		}
+
+		setErrorStatus(ctx, ErrMethodNotSupported, http.StatusMethodNotAllowed)
+	}
+}
+
+// postSignUp creates the user and returns the error messages, if any, and the user.
+func (hh *HTMLHandlers) postSignUp(ctx *gin.Context) ([]string, *models.User) {
+	var msgs []string
+
+	var signup SignUpRequest
+	if err := ctx.ShouldBind(&signup); err != nil {
+		return handleValidationError(err, hh.trans), nil
+	}
+
+	user, err := auth.SignUp(ctx, hh.ds, hh.cfg.SignUp, signUpRequest2Params(&signup), NewPasswordParams(hh.cfg),
+		hh.policy)
+	if perr, ok := err.(*auth.PolicyError); ok {
+		return translatePolicyError(perr, hh.trans), nil
+	}
+	switch err {
+	case nil:
+	case auth.ErrUsernameTaken:
+		return append(msgs, "Username is already taken"), nil
+	case auth.ErrInvalidInvite:
+		return append(msgs, "Invalid invite code"), nil
+	default:
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
+	}
+
+	if user.Email != "" {
+		if err := sendVerificationEmail(ctx, hh.cfg, user); err != nil {
+			log.Printf("failed to send verification email to user %s: %v\n", user.ID, err)
+		}
+	}
+
+	return msgs, user
+}
+
+func (hh *HTMLHandlers) SignUp() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if hh.cfg.SignUp != auth.SignUpOpen && hh.cfg.SignUp != auth.SignUpInviteOnly {
+			setErrorStatus(ctx, auth.ErrSignUpDisabled, http.StatusNotFound)
+			return
+		}
+
+		// GET  = displays the page.
+		// POST = handles the form submission.
+		if ctx.Request.Method == http.MethodGet {
+			content := gin.H{
+				"InviteOnly": hh.cfg.SignUp == auth.SignUpInviteOnly,
+				"InviteCode": ctx.Query("invite_code"),
+			}
+
+			ctx.HTML(http.StatusOK, signupTmplName, content)
 			return
 		} else if ctx.Request.Method == http.MethodPost {
-			msgs := hh.postSignIn(ctx)
+			msgs, user := hh.postSignUp(ctx)
+
+			// Ask the user to verify the email address before signing in.
+			if user != nil && user.Email != "" {
+				ctx.HTML(http.StatusOK, signupTmplName, gin.H{
+					"VerificationSentTo": user.Email,
+				})
+				return
+			}
+
+			// Keep the entries, except the password, if the sign-up failed.
+			content := gin.H{
+				"InviteOnly": hh.cfg.SignUp == auth.SignUpInviteOnly,
+				"InviteCode": ctx.PostForm("invite_code"),
+				"Username":   ctx.PostForm("username"),
+				"Name":       ctx.PostForm("name"),
+				"Email":      ctx.PostForm("email"),
+			}
 
-			handleErrorMessages(ctx, signinTmplName, msgs)
+			handleErrorMessages(ctx, signupTmplName, msgs, content, rootURI)
 			return
 		}
 
@@ -141,6 +310,23 @@ func (hh *HTMLHandlers) SignIn() gin.HandlerFunc {
 	}
 }
 
+// newUserInfoContent returns the content of the profile page of the user.
+func (hh *HTMLHandlers) newUserInfoContent(ctx *gin.Context, user *models.User) (gin.H, error) {
+	creds, err := NewWebAuthn(hh.cfg, hh.ds).Credentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"Username":         user.Username,
+		"Email":            user.Email,
+		"EmailVerified":    user.EmailVerified,
+		"VerificationSent": ctx.Query("verification") == "sent",
+		"PasswordChanged":  ctx.Query("password") == "changed",
+		"Passkeys":         creds,
+	}, nil
+}
+
 func (hh *HTMLHandlers) UserInfo() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ss := NewCookieStore(hh.cfg.SessionSecret)
@@ -169,8 +355,10 @@ func (hh *HTMLHandlers) UserInfo() gin.HandlerFunc {
 				return
 			}
 
-			content := gin.H{
-				"Username": user.Username,
+			content, err := hh.newUserInfoContent(ctx, user)
+			if err != nil {
+				setErrorStatus(ctx, err, http.StatusInternalServerError)
+				return
 			}
 
 			ctx.HTML(http.StatusOK, profileTmplName, content)
@@ -1,19 +1,47 @@
 package api
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
 	"github.com/cybersamx/authx/pkg/app"
 	"github.com/cybersamx/authx/pkg/config"
 	"github.com/cybersamx/authx/pkg/server"
-	"github.com/cybersamx/authx/pkg/store/mongo"
 	"github.com/spf13/viper"
 )
 
 var testapp *app.App
 
+// writeTestSigningKey writes a new ES256 private key to a temporary PEM file, so that the id tokens can
+// be issued, and returns the path of the file.
+func writeTestSigningKey() string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	file, err := ioutil.TempFile("", "authx-signing-key-*.pem")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		panic(err)
+	}
+
+	return file.Name()
+}
+
 func TestMain(m *testing.M) {
 	fmt.Println("Main app staring up...")
 	var code int
@@ -21,19 +49,33 @@ func TestMain(m *testing.M) {
 	// Config
 	v := viper.GetViper()
 	cfg := config.New()
+	// The tests run against the memory store, unless another store is set, eg. AX_STORE=mongo.
+	v.SetDefault("store", "memory")
 	cfg.BindConfig(v)
 	cfg.LoadConfig(v)
 	cfg.TemplatesDir = "web/templates"
+	cfg.Debug = true
+	cfg.SigningAlg = "ES256"
+	cfg.SigningKeyFile = writeTestSigningKey()
+	// The emails aren't sent, unless a test sets the file mailer to read them.
+	cfg.Mailer = mailerLog
 
-	// Mongo
-	ds := mongo.New(cfg)
+	// Data store
+	ds, err := app.NewDataStore(cfg)
+	if err != nil {
+		panic(err)
+	}
 	defer func() {
 		fmt.Println("Main app tearing down...")
 		ds.Close()
+		_ = os.Remove(cfg.SigningKeyFile)
 		os.Exit(code)
 	}()
 
-	if err := SeedUserData(ds); err != nil {
+	if err := SeedUserData(cfg, ds); err != nil {
+		panic(err)
+	}
+	if err := SeedClientData(cfg, ds); err != nil {
 		panic(err)
 	}
 
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	mfaTmplName = "mfa"
+
+	// Errors of a sign-in with the password that must be completed with the second factor.
+	mfaRequiredError           = "mfa_required"
+	mfaEnrollmentRequiredError = "mfa_enrollment_required"
+)
+
+// createMFAChallenge returns the token that completes the sign-in of the user with the second factor.
+func createMFAChallenge(parent context.Context, cfg *config.Config, ds store.DataStore, user *models.User) (string, error) {
+	ttl := time.Duration(cfg.MFAChallengeTTL) * time.Second
+	return auth.CreateMFAChallenge(parent, ds, user.ID, ttl)
+}
+
+func newMFARequiredResponse(parent context.Context, cfg *config.Config, ds store.DataStore,
+	user *models.User) (*MFARequiredResponse, error) {
+	token, err := createMFAChallenge(parent, cfg, ds, user)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := MFARequiredResponse{
+		Error:     mfaRequiredError,
+		MFAToken:  token,
+		ExpiresIn: int64(cfg.MFAChallengeTTL),
+	}
+	if !user.MFA.Enabled {
+		resp.Error = mfaEnrollmentRequiredError
+	}
+
+	return &resp, nil
+}
+
+func newTOTPEnrollmentResponse(cfg *config.Config, user *models.User) *TOTPEnrollmentResponse {
+	return &TOTPEnrollmentResponse{
+		Secret: user.MFA.TOTPSecret,
+		URI:    auth.TOTPProvisioningURI(cfg.TOTPIssuer, user.Username, user.MFA.TOTPSecret),
+	}
+}
+
+// completeMFASignIn checks the code of the second factor of the user of the MFA token. The failed
+// codes are throttled like the failed passwords.
+func completeMFASignIn(ctx *gin.Context, cfg *config.Config, ds store.DataStore, token,
+	code string) (*models.User, []string, error) {
+	user, err := auth.GetMFAChallengeUser(ctx, ds, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	throttle := NewSignInThrottle(cfg, ds)
+	if err := throttle.Attempt(ctx, user.Username, ctx.ClientIP()); err != nil {
+		return nil, nil, err
+	}
+
+	codes, err := auth.CompleteMFAChallenge(ctx, ds, token, user, code)
+	if err == auth.ErrInvalidMFACode {
+		return nil, nil, err
+	} else if err != nil {
+		throttle.Release(ctx, user.Username, ctx.ClientIP())
+		return nil, nil, err
+	}
+	throttle.Succeed(ctx, user.Username, ctx.ClientIP())
+
+	return user, codes, nil
+}
+
+// SignInMFA completes a sign-in with the second factor.
+func (ah *AuthHandlers) SignInMFA() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var mreq MFASignInRequest
+		if err := ctx.ShouldBindJSON(&mreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, codes, err := completeMFASignIn(ctx, ah.cfg, ah.ds, mreq.MFAToken, mreq.Code)
+		if terr, ok := err.(*auth.ThrottleError); ok {
+			setThrottleStatus(ctx, terr)
+			return
+		}
+		switch err {
+		case nil:
+		case auth.ErrInvalidMFAToken, auth.ErrInvalidMFACode:
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		case auth.ErrMFANotEnrolled:
+			setErrorStatus(ctx, err, http.StatusConflict)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		otoken, err := createSignInToken(ctx, ah.cfg, ah.ds, ah.tc, user)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, &MFASignInResponse{Token: otoken, RecoveryCodes: codes})
+	}
+}
+
+// EnrollSignInTOTP generates the TOTP secret of a user who must enroll to complete the sign-in.
+func (ah *AuthHandlers) EnrollSignInTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var mreq MFATokenRequest
+		if err := ctx.ShouldBindJSON(&mreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := auth.GetMFAChallengeUser(ctx, ah.ds, mreq.MFAToken)
+		if err == auth.ErrInvalidMFAToken {
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ah.enrollTOTP(ctx, user)
+	}
+}
+
+// EnrollTOTP generates a TOTP secret for the user of the access token, provided that the password is
+// valid, which is pending until it's confirmed with a code.
+func (ah *AuthHandlers) EnrollTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var ereq EnrollTOTPRequest
+		if err := ctx.ShouldBindJSON(&ereq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		// A stolen access token alone can't replace the second factor, nor guess the password.
+		err = throttlePasswordCheck(ctx, ah.cfg, ah.ds, user.Username, func() error {
+			if !auth.Authenticate(user.Password, ereq.Password, user.Salt) {
+				return auth.ErrInvalidCredentials
+			}
+
+			return nil
+		})
+		if terr, ok := err.(*auth.ThrottleError); ok {
+			setThrottleStatus(ctx, terr)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		}
+
+		ah.enrollTOTP(ctx, user)
+	}
+}
+
+func (ah *AuthHandlers) enrollTOTP(ctx *gin.Context, user *models.User) {
+	if _, err := auth.EnrollTOTP(ctx, ah.ds, user); err == auth.ErrMFAEnabled {
+		setErrorStatus(ctx, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newTOTPEnrollmentResponse(ah.cfg, user))
+}
+
+// ConfirmTOTP enables the MFA of the user of the access token with a code of the pending TOTP secret,
+// and returns the recovery codes.
+func (ah *AuthHandlers) ConfirmTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var creq ConfirmTOTPRequest
+		if err := ctx.ShouldBindJSON(&creq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		codes, err := auth.ConfirmTOTP(ctx, ah.ds, user, creq.Code)
+		switch err {
+		case nil:
+		case auth.ErrInvalidMFACode:
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		case auth.ErrMFAEnabled, auth.ErrMFANotEnrolled:
+			setErrorStatus(ctx, err, http.StatusConflict)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableMFA turns off the MFA of the user of the access token, provided that the password is valid
+// and that the MFA isn't required for the user.
+func (ah *AuthHandlers) DisableMFA() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var dreq DisableMFARequest
+		if err := ctx.ShouldBindJSON(&dreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = throttlePasswordCheck(ctx, ah.cfg, ah.ds, user.Username, func() error {
+			return auth.DisableMFA(ctx, ah.ds, user.ID, dreq.Password, ah.cfg.MFARequiredRoles)
+		})
+		if terr, ok := err.(*auth.ThrottleError); ok {
+			setThrottleStatus(ctx, terr)
+			return
+		}
+		switch err {
+		case nil:
+		case auth.ErrInvalidCredentials, auth.ErrMFARequired:
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		case auth.ErrUserNotFound:
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// newMFAContent returns the content of the page that completes the sign-in with the second factor. A
+// user who hasn't enabled the MFA sets up the authenticator with the pending TOTP secret first.
+func newMFAContent(cfg *config.Config, user *models.User, token, next string) gin.H {
+	content := gin.H{
+		"MFAToken": token,
+		"Next":     next,
+		"Enroll":   !user.MFA.Enabled,
+	}
+	if !user.MFA.Enabled {
+		enrollment := newTOTPEnrollmentResponse(cfg, user)
+		content["Secret"] = enrollment.Secret
+		content["URI"] = enrollment.URI
+	}
+
+	return content
+}
+
+// startMFA starts the sign-in of the user with the second factor, enrolling the TOTP if the user
+// hasn't enabled the MFA.
+func (hh *HTMLHandlers) startMFA(ctx *gin.Context, user *models.User, next string) (gin.H, error) {
+	if !user.MFA.Enabled {
+		if _, err := auth.EnrollTOTP(ctx, hh.ds, user); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := createMFAChallenge(ctx, hh.cfg, hh.ds, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMFAContent(hh.cfg, user, token, next), nil
+}
+
+func (hh *HTMLHandlers) postSignInMFA(ctx *gin.Context, mreq *MFASignInRequest) ([]string, []string) {
+	var msgs []string
+
+	user, codes, err := completeMFASignIn(ctx, hh.cfg, hh.ds, mreq.MFAToken, mreq.Code)
+	if terr, ok := err.(*auth.ThrottleError); ok {
+		return append(msgs, throttleMessage(signInAttempts, terr)), nil
+	}
+	switch err {
+	case nil:
+	case auth.ErrInvalidMFACode:
+		return append(msgs, "Invalid code"), nil
+	case auth.ErrInvalidMFAToken:
+		return append(msgs, "The sign-in has expired, please sign in again"), nil
+	default:
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
+	}
+
+	if err := hh.startSession(ctx, user); err != nil {
+		return append(msgs, fmt.Sprintf("Internal error: %s", err)), nil
+	}
+
+	return msgs, codes
+}
+
+// SignInMFA handles the form that completes the sign-in with the second factor. The recovery codes
+// are shown once if the TOTP has just been enrolled.
+func (hh *HTMLHandlers) SignInMFA() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		next := ctx.PostForm("next")
+
+		var mreq MFASignInRequest
+		if err := ctx.ShouldBind(&mreq); err != nil {
+			mreq.MFAToken = ctx.PostForm("mfa_token")
+			hh.renderMFAErrors(ctx, mreq.MFAToken, next, handleValidationError(err, hh.trans))
+			return
+		}
+
+		msgs, codes := hh.postSignInMFA(ctx, &mreq)
+		if len(msgs) > 0 {
+			hh.renderMFAErrors(ctx, mreq.MFAToken, next, msgs)
+			return
+		}
+
+		if len(codes) > 0 {
+			content := gin.H{
+				"RecoveryCodes": codes,
+				"Next":          getRedirectPath(next),
+			}
+
+			ctx.HTML(http.StatusOK, mfaTmplName, content)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, getRedirectPath(next))
+	}
+}
+
+// renderMFAErrors shows the errors on the page of the second factor, or on the sign-in page if the
+// MFA token is no longer valid.
+func (hh *HTMLHandlers) renderMFAErrors(ctx *gin.Context, token, next string, msgs []string) {
+	user, err := auth.GetMFAChallengeUser(ctx, hh.ds, token)
+	if err != nil {
+		content := gin.H{
+			"Next":          next,
+			"SignUp":        hh.cfg.SignUp != auth.SignUpDisabled,
+			"ErrorMessages": msgs,
+		}
+
+		ctx.HTML(http.StatusOK, signinTmplName, content)
+		return
+	}
+
+	content := newMFAContent(hh.cfg, user, token, next)
+	content["ErrorMessages"] = msgs
+
+	ctx.HTML(http.StatusOK, mfaTmplName, content)
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/auth"
+)
+
+var (
+	mfaTokenRegexp   = regexp.MustCompile(`name="mfa_token" value="([^"]+)"`)
+	totpSecretRegexp = regexp.MustCompile(`id="totp-secret"[^>]*>([A-Z2-7]+)<`)
+)
+
+// newMFAUser signs up a user, with the roles if any, and returns the username.
+func newMFAUser(t *testing.T, expect *httpexpect.Expect, roles ...string) string {
+	setSignUpMode(t, auth.SignUpOpen)
+	setSignInThrottle(t, 0, 0, 0)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+
+	if len(roles) > 0 {
+		ctx := context.Background()
+		user, err := testapp.Store.GetUserByUsername(ctx, username)
+		require.NoError(t, err)
+		user.Claims = map[string]interface{}{"roles": roles}
+		require.NoError(t, testapp.Store.UpdateUser(ctx, user))
+	}
+
+	return username
+}
+
+// totpCode returns a code of the secret that hasn't been used yet, ie. the code of the next time step,
+// which is accepted to allow for clock drift.
+func totpCode(t *testing.T, secret string) string {
+	code, err := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+
+	return code
+}
+
+func Test_MFA_EnrollAndSignIn(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().Raw()
+
+	// Run
+	expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		Expect().
+		Status(http.StatusUnprocessableEntity)
+	expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "wrong password"}).
+		Expect().
+		Status(http.StatusForbidden)
+	enrollment := expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	secret := enrollment.Value("secret").String().NotEmpty().Raw()
+	enrollment.Value("uri").String().Contains("otpauth://totp/authx:" + username)
+	expect.POST("/v1/me/mfa/totp/confirm").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"code": "000000"}).
+		Expect().
+		Status(http.StatusForbidden)
+	codes := expect.POST("/v1/me/mfa/totp/confirm").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"code": totpCode(t, secret)}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("recovery_codes").Array()
+	codes.Length().Equal(10)
+
+	// Validate
+	mfa := signIn(expect, username, "correct horse").
+		Status(http.StatusUnauthorized).
+		JSON().Object()
+	mfa.ValueEqual("error", "mfa_required")
+	mfaToken := mfa.Value("mfa_token").String().NotEmpty().Raw()
+
+	expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": "000000"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+	obj := expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": codes.Element(0).String().Raw()}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	obj.Value("access_token").String().NotEmpty()
+	obj.NotContainsKey("recovery_codes")
+
+	// The MFA token and the recovery code can only be used once.
+	expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": codes.Element(1).String().Raw()}).
+		Expect().
+		Status(http.StatusUnauthorized)
+	mfaToken = signIn(expect, username, "correct horse").
+		Status(http.StatusUnauthorized).
+		JSON().Object().
+		Value("mfa_token").String().Raw()
+	expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": codes.Element(0).String().Raw()}).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_MFA_RequiredRole(t *testing.T) {
+	// Setup
+	setMFARequiredRoles(t, "admin")
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect, "admin")
+
+	// Run
+	mfa := signIn(expect, username, "correct horse").
+		Status(http.StatusUnauthorized).
+		JSON().Object()
+	mfa.ValueEqual("error", "mfa_enrollment_required")
+	mfaToken := mfa.Value("mfa_token").String().Raw()
+
+	// The sign-in can't be completed before the enrollment.
+	expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": "123456"}).
+		Expect().
+		Status(http.StatusConflict)
+	secret := expect.POST("/v1/signin/mfa/totp").
+		WithJSON(map[string]string{"mfa_token": mfaToken}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("secret").String().Raw()
+	obj := expect.POST("/v1/signin/mfa").
+		WithJSON(map[string]string{"mfa_token": mfaToken, "code": totpCode(t, secret)}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	// Validate
+	obj.Value("recovery_codes").Array().Length().Equal(10)
+	at := obj.Value("access_token").String().Raw()
+
+	// The MFA can't be turned off while the role requires it.
+	expect.DELETE("/v1/me/mfa").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func Test_MFA_Disable(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	secret := expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		JSON().Object().
+		Value("secret").String().Raw()
+	expect.POST("/v1/me/mfa/totp/confirm").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"code": totpCode(t, secret)}).
+		Expect().
+		Status(http.StatusOK)
+
+	// Run
+	expect.DELETE("/v1/me/mfa").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "wrong password"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.DELETE("/v1/me/mfa").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		Status(http.StatusNoContent)
+
+	// Validate
+	signIn(expect, username, "correct horse").
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().NotEmpty()
+}
+
+func Test_MFA_PasswordThrottle(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	setSignInThrottle(t, 0, 2, 0)
+	t.Cleanup(func() {
+		require.NoError(t, auth.UnlockAccount(context.Background(), testapp.Store, username))
+	})
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+
+	// Run and validate that the password can't be guessed with the access token.
+	expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "wrong password"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.DELETE("/v1/me/mfa").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "wrong password"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.POST("/v1/me/mfa/totp").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		Status(http.StatusTooManyRequests)
+	expect.DELETE("/v1/me/mfa").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(map[string]string{"password": "correct horse"}).
+		Expect().
+		Status(http.StatusTooManyRequests)
+}
+
+func Test_MFAPage(t *testing.T) {
+	// Setup
+	setMFARequiredRoles(t, "admin")
+	expect := newBrowserHTTPExpect(t)
+	username := newMFAUser(t, expect, "admin")
+	signInPage := func() string {
+		return expect.POST("/").
+			WithFormField("username", username).
+			WithFormField("password", "correct horse").
+			Expect().
+			Status(http.StatusOK).
+			Body().Raw()
+	}
+
+	// Run
+	page := signInPage()
+	assert.Contains(t, page, "Two-factor authentication is required")
+	require.Regexp(t, mfaTokenRegexp, page)
+	require.Regexp(t, totpSecretRegexp, page)
+	mfaToken := mfaTokenRegexp.FindStringSubmatch(page)[1]
+	secret := totpSecretRegexp.FindStringSubmatch(page)[1]
+
+	expect.POST("/signin/mfa").
+		WithFormField("mfa_token", mfaToken).
+		WithFormField("code", totpCode(t, secret)).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("recovery codes").Contains(`id="recovery-codes"`)
+
+	// Validate
+	expect.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(username)
+
+	// The enrolled user is challenged for a code.
+	page = signInPage()
+	assert.Contains(t, page, "Enter the code shown by your authenticator app")
+	assert.NotContains(t, page, "totp-secret")
+	mfaToken = mfaTokenRegexp.FindStringSubmatch(page)[1]
+	expect.POST("/signin/mfa").
+		WithFormField("mfa_token", mfaToken).
+		WithFormField("code", "000000").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Invalid code")
+	expect.POST("/signin/mfa").
+		WithFormField("mfa_token", "expired").
+		WithFormField("code", "000000").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("The sign-in has expired")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/gin-gonic/gin"
@@ -15,12 +16,18 @@ import (
 const (
 	keyUserID        = "UserID"
 	keyAccessTokenID = "AccessTokenID"
+	keyClientID      = "ClientID"
+	keyScope         = "Scope"
+	keyAuthTime      = "AuthTime"
 )
 
 var (
 	ErrMissingBearer        = errors.New("missing bearer")
 	ErrInvalidBearer        = errors.New("bearer has invalid content")
 	ErrMissingSessionCookie = errors.New("missing session cookie")
+	ErrRevokedAccessToken   = errors.New("access token has been revoked")
+	ErrDelegatedAccessToken = errors.New("access token has been issued to a client")
+	ErrInsufficientScope    = errors.New("access token lacks the required scope")
 )
 
 // parseBearerFromHeader gets the bearer token from the header string.
@@ -47,26 +54,64 @@ func getUserIDFromContext(ctx *gin.Context) string {
 	return userID.(string)
 }
 
-// getAccessTokenIDFromContext gets the access token from the context.
+// getAccessTokenIDFromContext gets the id of the access token the user is signed in with from the
+// context.
 func getAccessTokenIDFromContext(ctx *gin.Context) string {
-	at, ok := ctx.Get(keyAccessTokenID)
+	atid, ok := ctx.Get(keyAccessTokenID)
 	if !ok {
 		return ""
 	}
 
-	return at.(string)
+	return atid.(string)
+}
+
+// getClientIDFromContext gets the id of the client the access token has been issued to from the
+// context, which is empty for the user's own sign-in.
+func getClientIDFromContext(ctx *gin.Context) string {
+	clientID, ok := ctx.Get(keyClientID)
+	if !ok {
+		return ""
+	}
+
+	return clientID.(string)
+}
+
+// getScopeFromContext gets the scope granted to the client the access token has been issued to from
+// the context.
+func getScopeFromContext(ctx *gin.Context) string {
+	scope, ok := ctx.Get(keyScope)
+	if !ok {
+		return ""
+	}
+
+	return scope.(string)
+}
+
+// getAuthTimeFromContext gets the time the user signed in to the session from the context, which is
+// zero for the access tokens.
+func getAuthTimeFromContext(ctx *gin.Context) time.Time {
+	authTime, ok := ctx.Get(keyAuthTime)
+	if !ok {
+		return time.Time{}
+	}
+
+	return authTime.(time.Time)
 }
 
 type Middleware struct {
-	cfg *config.Config
-	ds  store.DataStore
+	cfg     *config.Config
+	ds      store.DataStore
+	tc      *auth.TokenConfig
+	checker auth.TokenChecker
 }
 
-func NewMiddleware(cfg *config.Config, ds store.DataStore) *Middleware {
+func NewMiddleware(cfg *config.Config, ds store.DataStore, tc *auth.TokenConfig, checker auth.TokenChecker) *Middleware {
 	mw := new(Middleware)
 
 	mw.cfg = cfg
 	mw.ds = ds
+	mw.tc = tc
+	mw.checker = checker
 
 	return mw
 }
@@ -90,6 +135,17 @@ func (m *Middleware) setContextUsing(ctx *gin.Context, extractor extractFunc) {
 		return
 	}
 
+	// Check if the access token has been revoked eg. the user has signed out.
+	revoked, err := m.checker.IsRevoked(ctx, atid)
+	if err != nil {
+		setErrorStatus(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		setErrorStatus(ctx, ErrRevokedAccessToken, http.StatusUnauthorized)
+		return
+	}
+
 	// Check if user is in the data store.
 	_, err = m.ds.GetUser(ctx, uid)
 	if err == store.ErrorNotFound {
@@ -116,12 +172,14 @@ func (m *Middleware) SetContextFromBearerAuth() gin.HandlerFunc {
 			if err != nil {
 				return "", "", http.StatusUnauthorized, err
 			}
-			at, err := auth.ParseJWT(bearerToken, m.cfg.AccessSecret)
+			at, err := auth.ParseJWT(bearerToken, m.tc)
 			if err == auth.ErrExpiredJWT {
 				return "", "", http.StatusUnauthorized, err
 			} else if err != nil {
 				return "", "", http.StatusInternalServerError, err
 			}
+			ctx.Set(keyClientID, at.ClientID)
+			ctx.Set(keyScope, at.Scope)
 
 			return at.UserID, at.ID, http.StatusOK, nil
 		}
@@ -130,6 +188,21 @@ func (m *Middleware) SetContextFromBearerAuth() gin.HandlerFunc {
 	}
 }
 
+// RequireFirstParty rejects the access tokens issued to a client, eg. by the authorization code flow,
+// so that only the user's own sign-in can manage the account. It runs after SetContextFromBearerAuth.
+func (m *Middleware) RequireFirstParty() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if getClientIDFromContext(ctx) != "" {
+			// Stop the chain, the user id in the context is valid.
+			ctx.Abort()
+			setErrorStatus(ctx, ErrDelegatedAccessToken, http.StatusForbidden)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
 // SetContextFromCookie extracts the user identity from the session cookie and if it's valid, saves
 // it to the context for other handlers to use.
 func (m *Middleware) SetContextFromCookie() gin.HandlerFunc {
@@ -147,7 +220,14 @@ func (m *Middleware) SetContextFromCookie() gin.HandlerFunc {
 				return "", "", http.StatusUnauthorized, ErrMissingSessionCookie
 			}
 
-			return us.UserID, us.Token.AccessToken, http.StatusOK, nil
+			// The session cookie is signed, so the access token in it can be trusted.
+			at, err := auth.UnsafeParseJWT(us.Token.AccessToken)
+			if err != nil {
+				return "", "", http.StatusUnauthorized, err
+			}
+			ctx.Set(keyAuthTime, us.AuthTime)
+
+			return us.UserID, at.ID, http.StatusOK, nil
 		}
 
 		m.setContextUsing(ctx, fun)
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ut "github.com/go-playground/universal-translator"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	forgotPasswordTmplName = "forgot_password"
+	resetPasswordTmplName  = "reset_password"
+
+	// resetAttempts are the attempts that the password reset throttle counts.
+	resetAttempts = "password reset requests"
+
+	resetEmailTimeout = 30 * time.Second
+)
+
+// translatePolicyError returns the messages of the rules of the password policy that a password
+// breaks.
+func translatePolicyError(perr *auth.PolicyError, trans ut.Translator) []string {
+	msgs := make([]string, 0, len(perr.Violations))
+	for _, v := range perr.Violations {
+		msg, err := trans.T(v.Rule, v.Param)
+		if err != nil {
+			msg = v.Rule
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// setPolicyError responds with the messages of the rules of the password policy that a password
+// breaks.
+func setPolicyError(ctx *gin.Context, perr *auth.PolicyError, trans ut.Translator) {
+	ctx.JSON(http.StatusUnprocessableEntity, &PolicyErrorResponse{Errors: translatePolicyError(perr, trans)})
+	_ = ctx.Error(perr)
+}
+
+// requestPasswordReset sends a password reset link to the email address of the user, unless the
+// requests for the username or from the client IP address are throttled. The link is sent in the
+// background, so that the request takes as long whether the user exists or not.
+func requestPasswordReset(ctx *gin.Context, cfg *config.Config, ds store.DataStore, username string) error {
+	// Every request counts, so that neither the users nor the mail server are flooded with emails.
+	throttle := NewResetThrottle(cfg, ds)
+	if err := throttle.Attempt(ctx, username, ctx.ClientIP()); err != nil {
+		return err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resetEmailTimeout)
+		defer cancel()
+
+		if err := sendPasswordReset(ctx, cfg, ds, username); err != nil {
+			log.Printf("failed to send password reset link to %s: %v\n", username, err)
+		}
+	}()
+
+	return nil
+}
+
+// sendPasswordReset sends a password reset link to the email address of the user. Nothing is sent
+// if there's no such user or the user has no email address.
+func sendPasswordReset(parent context.Context, cfg *config.Config, ds store.DataStore, username string) error {
+	user, err := ds.GetUserByUsername(parent, username)
+	if err == store.ErrorNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		log.Printf("can't send password reset link to user %s without an email address\n", user.ID)
+		return nil
+	}
+
+	ttl := time.Duration(cfg.PasswordResetTTL) * time.Second
+	token, err := auth.CreatePasswordReset(parent, ds, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+
+	return sendLinkEmail(parent, cfg, user, "Reset your password", "set a new password",
+		newEmailLink(cfg, resetPasswordPath, token), time.Now().Add(ttl))
+}
+
+// ForgotPassword sends a password reset link to the user. The response is the same whether the
+// user exists or not, unless the requests are throttled.
+func (ah *AuthHandlers) ForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var freq ForgotPasswordRequest
+		if err := ctx.ShouldBind(&freq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		if err := requestPasswordReset(ctx, ah.cfg, ah.ds, freq.Username); err != nil {
+			if terr, ok := err.(*auth.ThrottleError); ok {
+				setThrottleStatus(ctx, terr)
+				return
+			}
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
+
+// ResetPassword sets a new password with the password reset token.
+func (ah *AuthHandlers) ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var rreq ResetPasswordRequest
+		if err := ctx.ShouldBind(&rreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		_, err := auth.ResetPassword(ctx, ah.ds, ah.checker, rreq.Token, rreq.Password, NewPasswordParams(ah.cfg), ah.policy)
+		if perr, ok := err.(*auth.PolicyError); ok {
+			setPolicyError(ctx, perr, ah.trans)
+			return
+		} else if err == auth.ErrInvalidResetToken {
+			setErrorStatus(ctx, err, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+func (hh *HTMLHandlers) ForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// GET  = displays the page.
+		// POST = handles the form submission.
+		if ctx.Request.Method == http.MethodGet {
+			ctx.HTML(http.StatusOK, forgotPasswordTmplName, gin.H{})
+			return
+		} else if ctx.Request.Method == http.MethodPost {
+			var msgs []string
+			var freq ForgotPasswordRequest
+			if err := ctx.ShouldBind(&freq); err != nil {
+				msgs = handleValidationError(err, hh.trans)
+			} else if err := requestPasswordReset(ctx, hh.cfg, hh.ds, freq.Username); err != nil {
+				if terr, ok := err.(*auth.ThrottleError); ok {
+					msgs = append(msgs, throttleMessage(resetAttempts, terr))
+				} else {
+					msgs = append(msgs, fmt.Sprintf("Internal error: %s", err))
+				}
+			}
+
+			content := gin.H{
+				"ErrorMessages": msgs,
+				"Sent":          len(msgs) == 0,
+			}
+
+			ctx.HTML(http.StatusOK, forgotPasswordTmplName, content)
+			return
+		}
+
+		setErrorStatus(ctx, ErrMethodNotSupported, http.StatusMethodNotAllowed)
+	}
+}
+
+func (hh *HTMLHandlers) postResetPassword(ctx *gin.Context) []string {
+	var msgs []string
+
+	var rreq ResetPasswordRequest
+	if err := ctx.ShouldBind(&rreq); err != nil {
+		return handleValidationError(err, hh.trans)
+	}
+
+	_, err := auth.ResetPassword(ctx, hh.ds, hh.checker, rreq.Token, rreq.Password, NewPasswordParams(hh.cfg), hh.policy)
+	if perr, ok := err.(*auth.PolicyError); ok {
+		return translatePolicyError(perr, hh.trans)
+	} else if err == auth.ErrInvalidResetToken {
+		return append(msgs, "The password reset link is invalid or has expired")
+	} else if err != nil {
+		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+	}
+
+	return msgs
+}
+
+func (hh *HTMLHandlers) ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// GET  = displays the page.
+		// POST = handles the form submission.
+		if ctx.Request.Method == http.MethodGet {
+			content := gin.H{
+				"Token": ctx.Query("token"),
+			}
+
+			ctx.HTML(http.StatusOK, resetPasswordTmplName, content)
+			return
+		} else if ctx.Request.Method == http.MethodPost {
+			msgs := hh.postResetPassword(ctx)
+			content := gin.H{
+				"ErrorMessages": msgs,
+				"Token":         ctx.PostForm("token"),
+				"Reset":         len(msgs) == 0,
+			}
+
+			ctx.HTML(http.StatusOK, resetPasswordTmplName, content)
+			return
+		}
+
+		setErrorStatus(ctx, ErrMethodNotSupported, http.StatusMethodNotAllowed)
+	}
+}
+
+// changePassword changes the password of the signed-in user and signs out the other sessions of the
+// user if requested.
+func changePassword(ctx *gin.Context, cfg *config.Config, ds store.DataStore, checker auth.TokenChecker,
+	policy *auth.PasswordPolicy, creq *ChangePasswordRequest) error {
+	uid := getUserIDFromContext(ctx)
+	user, err := ds.GetUser(ctx, uid)
+	if err == store.ErrorNotFound {
+		return auth.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	err = throttlePasswordCheck(ctx, cfg, ds, user.Username, func() error {
+		_, err := auth.ChangePassword(ctx, ds, uid, creq.CurrentPassword, creq.NewPassword, NewPasswordParams(cfg), policy)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if creq.RevokeOtherSessions {
+		atids, err := auth.RevokeOtherUserTokens(ctx, ds, uid, getAccessTokenIDFromContext(ctx))
+		if err != nil {
+			return err
+		}
+		for _, atid := range atids {
+			checker.Invalidate(atid)
+		}
+	}
+
+	return nil
+}
+
+// ChangePassword sets a new password for the user of the access token, provided that the current
+// password is valid.
+func (ah *AuthHandlers) ChangePassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var creq ChangePasswordRequest
+		if err := ctx.ShouldBindJSON(&creq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		err := changePassword(ctx, ah.cfg, ah.ds, ah.checker, ah.policy, &creq)
+		if perr, ok := err.(*auth.PolicyError); ok {
+			setPolicyError(ctx, perr, ah.trans)
+			return
+		}
+		if terr, ok := err.(*auth.ThrottleError); ok {
+			setThrottleStatus(ctx, terr)
+			return
+		}
+		switch err {
+		case nil:
+		case auth.ErrInvalidCredentials:
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		case auth.ErrUserNotFound:
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+func (hh *HTMLHandlers) postChangePassword(ctx *gin.Context) []string {
+	var msgs []string
+
+	var creq ChangePasswordRequest
+	if err := ctx.ShouldBind(&creq); err != nil {
+		return handleValidationError(err, hh.trans)
+	}
+
+	err := changePassword(ctx, hh.cfg, hh.ds, hh.checker, hh.policy, &creq)
+	if perr, ok := err.(*auth.PolicyError); ok {
+		return translatePolicyError(perr, hh.trans)
+	} else if terr, ok := err.(*auth.ThrottleError); ok {
+		return append(msgs, throttleMessage(signInAttempts, terr))
+	} else if err == auth.ErrInvalidCredentials {
+		return append(msgs, "The current password is incorrect")
+	} else if err != nil {
+		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+	}
+
+	return msgs
+}
+
+// ChangePassword handles the password change form on the profile page.
+func (hh *HTMLHandlers) ChangePassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		msgs := hh.postChangePassword(ctx)
+		if len(msgs) == 0 {
+			ctx.Redirect(http.StatusFound, successURI+"?password=changed")
+			return
+		}
+
+		user, err := hh.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		content, err := hh.newUserInfoContent(ctx, user)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		content["ErrorMessages"] = msgs
+
+		ctx.HTML(http.StatusOK, profileTmplName, content)
+	}
+}
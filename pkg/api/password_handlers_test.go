@@ -0,0 +1,317 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/auth"
+)
+
+var (
+	resetLinkRegexp = regexp.MustCompile(`/password/reset\?token=(\S+)`)
+)
+
+func Test_ResetPassword(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	path := setFileMailer(t)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	obj := expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": "correct horse"}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	at := obj.Value("access_token").String().Raw()
+	rt := obj.Value("refresh_token").String().Raw()
+	// The revocation status of the access token is cached.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusOK)
+
+	// Run
+	expect.POST("/v1/password/forgot").
+		WithJSON(map[string]string{"username": username}).
+		Expect().
+		Status(http.StatusAccepted)
+	token := readLinkToken(t, path, resetLinkRegexp)
+	expect.POST("/v1/password/reset").
+		WithJSON(map[string]string{"token": token, "password": "battery staple"}).
+		Expect().
+		Status(http.StatusNoContent)
+
+	// Validate
+	expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": "correct horse"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+	expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": "battery staple"}).
+		Expect().
+		Status(http.StatusOK)
+
+	// The tokens issued before the reset are revoked.
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", rt).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.POST("/v1/introspect").
+		WithBasicAuth("gateway", "gateway-secret").
+		WithFormField("token", at).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("active", false)
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", fmt.Sprintf("Bearer %s", at)).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// The reset token can only be used once.
+	expect.POST("/v1/password/reset").
+		WithJSON(map[string]string{"token": token, "password": "another password"}).
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func Test_ForgotPassword_UnknownUser(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/password/forgot").
+		WithJSON(map[string]string{"username": newTestUsername()}).
+		Expect().
+		Status(http.StatusAccepted)
+}
+
+func Test_ForgotPassword_Throttle(t *testing.T) {
+	// Setup
+	setSignInThrottle(t, 1, 0, 0)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+
+	// Run and validate that the requests for the username back off.
+	for i := 0; i < 2; i++ {
+		expect.POST("/v1/password/forgot").
+			WithJSON(map[string]string{"username": username}).
+			Expect().
+			Status(http.StatusAccepted)
+	}
+	expect.POST("/v1/password/forgot").
+		WithJSON(map[string]string{"username": username}).
+		Expect().
+		Status(http.StatusTooManyRequests).
+		Header("Retry-After").Equal("1")
+	expect.POST("/password/forgot").
+		WithFormField("username", username).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Too many password reset requests. Please try again in a second.")
+
+	// The requests don't throttle the sign-ins.
+	signIn(expect, username, "wrong").
+		Status(http.StatusUnauthorized)
+}
+
+func Test_ResetPasswordPage(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	path := setFileMailer(t)
+	expect := newBrowserHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+
+	// Run and validate
+	expect.GET("/password/forgot").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(`name="username"`)
+	expect.POST("/password/forgot").
+		WithFormField("username", username).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("a link to reset the password has been sent")
+
+	token := readLinkToken(t, path, resetLinkRegexp)
+	expect.GET("/password/reset").
+		WithQuery("token", token).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(`name="password"`)
+	expect.POST("/password/reset").
+		WithFormField("token", "invalid").
+		WithFormField("password", "battery staple").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("The password reset link is invalid or has expired")
+	// The token stays valid if the password breaks the policy.
+	expect.POST("/password/reset").
+		WithFormField("token", token).
+		WithFormField("password", username).
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Password must not contain the username or email address")
+	expect.POST("/password/reset").
+		WithFormField("token", token).
+		WithFormField("password", "battery staple").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Your password has been reset")
+
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "battery staple").
+		Expect().
+		Status(http.StatusMovedPermanently).
+		Header("Location").Equal(successURI)
+}
+
+func Test_ChangePassword(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	signIn := func(password string) *httpexpect.Object {
+		return expect.POST("/v1/signin").
+			WithJSON(map[string]string{"username": username, "password": password}).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object()
+	}
+	current := signIn("correct horse")
+	other := signIn("correct horse")
+	bearer := fmt.Sprintf("Bearer %s", current.Value("access_token").String().Raw())
+	otherBearer := fmt.Sprintf("Bearer %s", other.Value("access_token").String().Raw())
+	// The revocation status of the other access token is cached.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", otherBearer).
+		Expect().
+		Status(http.StatusOK)
+
+	// Run and validate
+	expect.PUT("/v1/me/password").
+		WithJSON(map[string]string{"current_password": "correct horse", "new_password": "battery staple"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+	expect.PUT("/v1/me/password").
+		WithHeader("Authorization", bearer).
+		WithJSON(map[string]string{"current_password": "wrong password", "new_password": "battery staple"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.PUT("/v1/me/password").
+		WithHeader("Authorization", bearer).
+		WithJSON(map[string]string{"current_password": "correct horse", "new_password": "short"}).
+		Expect().
+		Status(http.StatusUnprocessableEntity)
+	expect.PUT("/v1/me/password").
+		WithHeader("Authorization", bearer).
+		WithJSON(map[string]interface{}{
+			"current_password":      "correct horse",
+			"new_password":          "battery staple",
+			"revoke_other_sessions": true,
+		}).
+		Expect().
+		Status(http.StatusNoContent)
+
+	expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": "correct horse"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+	signIn("battery staple")
+
+	// The current session stays signed in while the other sessions are signed out.
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", bearer).
+		Expect().
+		Status(http.StatusOK)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", current.Value("refresh_token").String().Raw()).
+		Expect().
+		Status(http.StatusOK)
+	expect.POST("/v1/token").
+		WithFormField("grant_type", "refresh_token").
+		WithFormField("refresh_token", other.Value("refresh_token").String().Raw()).
+		Expect().
+		Status(http.StatusBadRequest)
+	expect.GET("/v1/userinfo").
+		WithHeader("Authorization", otherBearer).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_ChangePassword_Throttle(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	setSignInThrottle(t, 0, 2, 0)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	t.Cleanup(func() {
+		require.NoError(t, auth.UnlockAccount(context.Background(), testapp.Store, username))
+	})
+	bearer := "Bearer " + signIn(expect, username, "correct horse").
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().Raw()
+	changePassword := func(current string) *httpexpect.Response {
+		return expect.PUT("/v1/me/password").
+			WithHeader("Authorization", bearer).
+			WithJSON(map[string]string{"current_password": current, "new_password": "battery staple"}).
+			Expect()
+	}
+
+	// Run and validate that the current password can't be guessed with the access token.
+	changePassword("wrong password").Status(http.StatusForbidden)
+	changePassword("wrong again").Status(http.StatusForbidden)
+	changePassword("correct horse").Status(http.StatusTooManyRequests).Header("Retry-After").NotEmpty()
+	signIn(expect, username, "correct horse").Status(http.StatusTooManyRequests)
+}
+
+func Test_ChangePasswordPage(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newBrowserHTTPExpect(t)
+	username := newTestUsername()
+	signUpWithEmail(expect, username)
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusMovedPermanently)
+
+	// Run and validate
+	expect.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(`name="current_password"`)
+	expect.POST("/password/change").
+		WithFormField("current_password", "wrong password").
+		WithFormField("new_password", "battery staple").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("The current password is incorrect")
+	expect.POST("/password/change").
+		WithFormField("current_password", "correct horse").
+		WithFormField("new_password", "battery staple").
+		WithFormField("revoke_other_sessions", "true").
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Equal("/userinfo?password=changed")
+	expect.GET("/userinfo").
+		WithQuery("password", "changed").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Your password has been changed")
+}
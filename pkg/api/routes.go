@@ -1,25 +1,203 @@
 package api
 
 import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 
+	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/crypto"
 	"github.com/cybersamx/authx/pkg/server"
 	"github.com/cybersamx/authx/pkg/store"
 )
 
+const (
+	keyringInterval = time.Minute
+
+	// Paths of the endpoints advertised in the OpenID Connect discovery document.
+	apiPath        = "/v1"
+	authorizePath  = "/oauth2/authorize"
+	tokenPath      = "/token"
+	userInfoPath   = "/userinfo"
+	introspectPath = "/introspect"
+	revokePath     = "/revoke"
+	jwksPath       = "/.well-known/jwks.json"
+	discoveryPath  = "/.well-known/openid-configuration"
+
+	signUpPath             = "/signup"
+	verifyEmailPath        = "/verify-email"
+	resendVerificationPath = "/verify-email/resend"
+	forgotPasswordPath     = "/password/forgot"
+	resetPasswordPath      = "/password/reset"
+	changePasswordPath     = "/password/change"
+	mePasswordPath         = "/me/password"
+	signInMFAPath          = "/signin/mfa"
+	signInTOTPPath         = "/signin/mfa/totp"
+	meMFAPath              = "/me/mfa"
+	meTOTPPath             = "/me/mfa/totp"
+	meTOTPConfirmPath      = "/me/mfa/totp/confirm"
+	signInWebAuthnPath     = "/signin/webauthn"
+	signInWebAuthnBegin    = "/signin/webauthn/begin"
+	signInWebAuthnFinish   = "/signin/webauthn/finish"
+	meWebAuthnBegin        = "/me/webauthn/register/begin"
+	meWebAuthnFinish       = "/me/webauthn/register/finish"
+	meWebAuthnCredsPath    = "/me/webauthn/credentials"
+)
+
+// newTokenChecker returns the checker for revoked access tokens, caching the lookups if configured.
+func newTokenChecker(cfg *config.Config, ds store.DataStore) auth.TokenChecker {
+	var checker auth.TokenChecker = auth.NewStoreTokenChecker(ds)
+	if cfg.RevocationCacheTTL > 0 {
+		ttl := time.Duration(cfg.RevocationCacheTTL) * time.Second
+		checker = auth.NewCachedTokenChecker(checker, ttl)
+	}
+
+	return checker
+}
+
+// NewKeyring returns the keyring of signing keys kept in the data store.
+func NewKeyring(cfg *config.Config, ds store.DataStore) *auth.Keyring {
+	return auth.NewKeyring(ds, auth.KeyringOptions{
+		Algorithm:      cfg.SigningAlg,
+		RotationPeriod: time.Duration(cfg.KeyRotationPeriod) * time.Second,
+		RetireAfter:    time.Duration(cfg.AccessTTL) * time.Second,
+		PublishDelay:   time.Duration(cfg.JWKSCacheTTL) * time.Second,
+	})
+}
+
+// NewKeySet returns the key set for signing and verifying JWTs as configured. The keyring, if
+// enabled, is maintained in the background.
+func NewKeySet(cfg *config.Config, ds store.DataStore) auth.KeySet {
+	if cfg.Keyring {
+		keyring := NewKeyring(cfg, ds)
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := keyring.Maintain(ctx); err != nil {
+			log.Panicf("failed to set up the keyring: %v", err)
+		}
+		go keyring.Run(context.Background(), keyringInterval)
+
+		return keyring
+	}
+
+	keys, err := auth.LoadKeySet(cfg.SigningAlg, cfg.SigningKeyFile, cfg.SigningKeyID, cfg.AccessSecret)
+	if err != nil {
+		log.Panicf("failed to load the signing keys: %v", err)
+	}
+
+	return keys
+}
+
+// NewTokenConfig returns the settings of the JWTs issued and accepted by the service.
+func NewTokenConfig(cfg *config.Config, ds store.DataStore) *auth.TokenConfig {
+	return &auth.TokenConfig{
+		Keys:       NewKeySet(cfg, ds),
+		Issuer:     strings.TrimSuffix(cfg.Issuer, "/"),
+		Audience:   cfg.Audience,
+		ClaimsFunc: auth.StoredClaims,
+	}
+}
+
+// NewPasswordParams returns the algorithm and cost for hashing passwords and client secrets.
+func NewPasswordParams(cfg *config.Config) *crypto.PasswordParams {
+	return &crypto.PasswordParams{
+		Algorithm:     cfg.PasswordHash,
+		Argon2Time:    uint32(cfg.Argon2Time),
+		Argon2Memory:  uint32(cfg.Argon2Memory),
+		Argon2Threads: uint8(cfg.Argon2Threads),
+		BcryptCost:    cfg.BcryptCost,
+	}
+}
+
+// NewSignInThrottle returns the throttle of the failed sign-ins.
+func NewSignInThrottle(cfg *config.Config, ds store.DataStore) *auth.SignInThrottle {
+	return auth.NewSignInThrottle(ds, auth.ThrottleOptions{
+		FreeFailures:  cfg.SignInFreeFailures,
+		MaxFailures:   cfg.SignInMaxFailures,
+		MaxIPFailures: cfg.SignInIPFailures,
+		Lockout:       time.Duration(cfg.SignInLockout) * time.Second,
+	})
+}
+
+// NewResetThrottle returns the throttle of the password reset requests, which counts every request
+// with the limits of the failed sign-ins, apart from them.
+func NewResetThrottle(cfg *config.Config, ds store.DataStore) *auth.SignInThrottle {
+	return auth.NewSignInThrottle(ds, auth.ThrottleOptions{
+		FreeFailures:  cfg.SignInFreeFailures,
+		MaxFailures:   cfg.SignInMaxFailures,
+		MaxIPFailures: cfg.SignInIPFailures,
+		Lockout:       time.Duration(cfg.SignInLockout) * time.Second,
+		Prefix:        auth.ResetThrottlePrefix,
+	})
+}
+
+// NewWebAuthn returns the ceremonies of the passkeys. The relying party id and the origin default to the
+// host and the origin of the issuer.
+func NewWebAuthn(cfg *config.Config, ds store.DataStore) *auth.WebAuthn {
+	opts := auth.WebAuthnOptions{
+		RPID:    cfg.WebAuthnRPID,
+		RPName:  cfg.WebAuthnRPName,
+		Origin:  cfg.WebAuthnOrigin,
+		Timeout: time.Duration(cfg.WebAuthnTimeout) * time.Second,
+	}
+
+	if opts.RPID == "" || opts.Origin == "" {
+		issuer, err := url.Parse(cfg.Issuer)
+		if err != nil {
+			log.Panicf("failed to parse the issuer: %v", err)
+		}
+		if opts.RPID == "" {
+			opts.RPID = issuer.Hostname()
+		}
+		if opts.Origin == "" {
+			opts.Origin = issuer.Scheme + "://" + issuer.Host
+		}
+	}
+
+	return auth.NewWebAuthn(ds, opts)
+}
+
+// NewPasswordPolicy returns the rules new passwords must follow, including the breached passwords if
+// configured.
+func NewPasswordPolicy(cfg *config.Config) *auth.PasswordPolicy {
+	policy := auth.PasswordPolicy{
+		MinLength:      cfg.PasswordMinLength,
+		MinCharClasses: cfg.PasswordMinClasses,
+		MaxRepeated:    cfg.PasswordMaxRepeat,
+		BanUserInfo:    cfg.PasswordNoUserInfo,
+	}
+
+	if cfg.BreachedPasswords != "" {
+		breached, err := auth.LoadBreachedPasswords(cfg.BreachedPasswords)
+		if err != nil {
+			log.Panicf("failed to load the breached passwords: %v", err)
+		}
+		policy.Breached = breached
+	}
+
+	return &policy
+}
+
 func GetRoutesFunc() server.RegisterRoutesFunc {
 	return func(router *gin.Engine, cfg *config.Config, ds store.DataStore) {
 		// Initialization.
 		tmpl := NewTemplate(cfg.TemplatesDir)
 		trans := NewEnglishTranslator()
 		validate := NewValidate(trans)
+		checker := newTokenChecker(cfg, ds)
+		tc := NewTokenConfig(cfg, ds)
+		policy := NewPasswordPolicy(cfg)
 
-		htmlHandlers := NewHTMLHandlers(cfg, ds, trans, validate)
-		authHandlers := NewAuthHandlers(cfg, ds)
+		htmlHandlers := NewHTMLHandlers(cfg, ds, tc, checker, trans, validate, policy)
+		authHandlers := NewAuthHandlers(cfg, ds, tc, checker, trans, policy)
 		errHandlers := NewErrorHandlers(cfg)
-		middleware := NewMiddleware(cfg, ds)
+		middleware := NewMiddleware(cfg, ds, tc, checker)
 
 		router.SetHTMLTemplate(tmpl)
 		router.Use(errHandlers.ErrorResponse())
@@ -28,24 +206,69 @@ func GetRoutesFunc() server.RegisterRoutesFunc {
 		webGrp := router.Group("/")
 		webGrp.GET("/", htmlHandlers.SignIn())
 		webGrp.POST("/", htmlHandlers.SignIn())
+		webGrp.POST(signInMFAPath, htmlHandlers.SignInMFA())
+		webGrp.POST(signInWebAuthnPath, htmlHandlers.SignInWebAuthn())
+		webGrp.GET(signUpPath, htmlHandlers.SignUp())
+		webGrp.POST(signUpPath, htmlHandlers.SignUp())
+		webGrp.GET(verifyEmailPath, htmlHandlers.VerifyEmail())
+		webGrp.GET(forgotPasswordPath, htmlHandlers.ForgotPassword())
+		webGrp.POST(forgotPasswordPath, htmlHandlers.ForgotPassword())
+		webGrp.GET(resetPasswordPath, htmlHandlers.ResetPassword())
+		webGrp.POST(resetPasswordPath, htmlHandlers.ResetPassword())
 		webGrp.GET(errorsPath, errHandlers.ErrorHTML())
+		webGrp.GET(authorizePath, htmlHandlers.Authorize())
+		webGrp.POST(authorizePath, htmlHandlers.Authorize())
+
+		// Provider metadata and public keys for verifying the issued JWTs.
+		router.GET(discoveryPath, authHandlers.Discovery())
+		router.GET(jwksPath, authHandlers.JWKS())
 
 		// Protected web pages.
 		proWebGrp := router.Group("/")
 		proWebGrp.Use(middleware.SetContextFromCookie())
 		proWebGrp.GET("/userinfo", htmlHandlers.UserInfo())
 		proWebGrp.POST("/userinfo", htmlHandlers.UserInfo())
+		proWebGrp.POST(resendVerificationPath, htmlHandlers.ResendVerificationEmail())
+		proWebGrp.POST(changePasswordPath, htmlHandlers.ChangePassword())
+		proWebGrp.POST(meWebAuthnBegin, authHandlers.BeginWebAuthnRegistration())
+		proWebGrp.POST(meWebAuthnFinish, authHandlers.FinishWebAuthnRegistration())
+		proWebGrp.POST(meWebAuthnCredsPath+"/:id/delete", htmlHandlers.RemoveWebAuthnCredential())
 
 		// Public auth api.
-		apiGrp := router.Group("/v1")
+		apiGrp := router.Group(apiPath)
 		apiGrp.POST("/signin", authHandlers.SignIn())
+		apiGrp.POST(signInMFAPath, authHandlers.SignInMFA())
+		apiGrp.POST(signInTOTPPath, authHandlers.EnrollSignInTOTP())
+		apiGrp.POST(signInWebAuthnBegin, authHandlers.BeginWebAuthnSignIn())
+		apiGrp.POST(signInWebAuthnFinish, authHandlers.FinishWebAuthnSignIn())
+		apiGrp.POST(signUpPath, authHandlers.SignUp())
+		apiGrp.POST(verifyEmailPath, authHandlers.VerifyEmail())
+		apiGrp.POST(forgotPasswordPath, authHandlers.ForgotPassword())
+		apiGrp.POST(resetPasswordPath, authHandlers.ResetPassword())
 		apiGrp.POST("/signout", authHandlers.SignOut())
+		apiGrp.POST(tokenPath, authHandlers.Token())
+		apiGrp.POST(introspectPath, authHandlers.Introspect())
+		apiGrp.POST(revokePath, authHandlers.Revoke())
 		apiGrp.GET("/avatar/:identity", authHandlers.Avatar())
 
 		// Protected auth api.
-		proAPIGrp := router.Group("/v1")
+		proAPIGrp := router.Group(apiPath)
 		proAPIGrp.Use(middleware.SetContextFromBearerAuth())
-		proAPIGrp.GET("/userinfo", authHandlers.UserInfo())
+		proAPIGrp.GET(userInfoPath, authHandlers.UserInfo())
+		proAPIGrp.POST(userInfoPath, authHandlers.UserInfo())
+		proAPIGrp.POST(resendVerificationPath, authHandlers.ResendVerificationEmail())
+
+		// Account management api, only for the access tokens of the user's own sign-in.
+		meAPIGrp := router.Group(apiPath)
+		meAPIGrp.Use(middleware.SetContextFromBearerAuth(), middleware.RequireFirstParty())
+		meAPIGrp.PUT(mePasswordPath, authHandlers.ChangePassword())
+		meAPIGrp.POST(meTOTPPath, authHandlers.EnrollTOTP())
+		meAPIGrp.POST(meTOTPConfirmPath, authHandlers.ConfirmTOTP())
+		meAPIGrp.DELETE(meMFAPath, authHandlers.DisableMFA())
+		meAPIGrp.POST(meWebAuthnBegin, authHandlers.BeginWebAuthnRegistration())
+		meAPIGrp.POST(meWebAuthnFinish, authHandlers.FinishWebAuthnRegistration())
+		meAPIGrp.GET(meWebAuthnCredsPath, authHandlers.WebAuthnCredentials())
+		meAPIGrp.DELETE(meWebAuthnCredsPath+"/:id", authHandlers.RemoveWebAuthnCredential())
 
 		// Fallback to static content.
 		router.Use(static.Serve("/", static.LocalFile(cfg.StaticWebDir, false)))
@@ -3,17 +3,18 @@ package api
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
 	"github.com/cybersamx/authx/pkg/crypto"
 	"github.com/cybersamx/authx/pkg/models"
 	"github.com/cybersamx/authx/pkg/store"
-	"github.com/cybersamx/authx/pkg/utils"
 )
 
 const (
 	storeTimeout = 15 * time.Second
-	pwdSaltLen   = 24
 )
 
 var (
@@ -21,15 +22,18 @@ var (
 		id       string
 		username string
 		clearPwd string
+		name     string
+		email    string
+		claims   map[string]interface{}
 	}{
-		{"0", "admin", "secret"},
-		{"1", "chan", "mypassword"},
-		{"2", "john", "12345678"},
-		{"3", "patel", "patel_rules"},
+		{"0", "admin", "secret", "Administrator", "admin@example.com", map[string]interface{}{"roles": []string{"admin"}}},
+		{"1", "chan", "mypassword", "Chan", "chan@example.com", nil},
+		{"2", "john", "12345678", "John", "john@example.com", nil},
+		{"3", "patel", "patel_rules", "Patel", "patel@example.com", nil},
 	}
 )
 
-func SeedUserData(ds store.DataStore) error {
+func SeedUserData(cfg *config.Config, ds store.DataStore) error {
 	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
 	defer cancel()
 
@@ -37,16 +41,18 @@ func SeedUserData(ds store.DataStore) error {
 		_, err := ds.GetUser(ctx, seedUser.id)
 		if err == store.ErrorNotFound {
 			// Generate a user.
-			salt, errr := utils.GetRandSecret(pwdSaltLen)
+			password, errr := crypto.HashPassword(seedUser.clearPwd, NewPasswordParams(cfg))
 			if errr != nil {
-				panic(err)
+				return fmt.Errorf("failed to hash seed user password: %v", errr)
 			}
-			password := crypto.HashString(seedUser.clearPwd, salt)
 			user := models.User{
-				ID:       seedUser.id,
-				Username: seedUser.username,
-				Password: password,
-				Salt:     salt,
+				ID:            seedUser.id,
+				Username:      seedUser.username,
+				Name:          seedUser.name,
+				Email:         seedUser.email,
+				EmailVerified: true,
+				Password:      password,
+				Claims:        seedUser.claims,
 			}
 
 			if errr := ds.SaveUser(ctx, &user); errr != nil {
@@ -59,3 +65,92 @@ func SeedUserData(ds store.DataStore) error {
 
 	return nil
 }
+
+var (
+	seedClients = []struct {
+		client      models.Client
+		clearSecret string
+		// debugOnly is set on the clients whose secret is public, which are only seeded in debug mode.
+		debugOnly bool
+	}{
+		{
+			client: models.Client{
+				ID:           "spa",
+				Name:         "Sample App",
+				RedirectURIs: []string{"http://localhost:3000/callback"},
+				GrantTypes:   []string{grantTypeAuthorizationCode, grantTypeRefreshToken},
+				Scopes:       []string{auth.ScopeOpenID, auth.ScopeProfile, auth.ScopeEmail},
+			},
+		},
+		{
+			client: models.Client{
+				ID:         "service",
+				Name:       "Sample Service",
+				GrantTypes: []string{grantTypeClientCredentials},
+				Scopes:     []string{"users:read"},
+			},
+			clearSecret: "service-secret",
+			debugOnly:   true,
+		},
+		{
+			client: models.Client{
+				ID:     "gateway",
+				Name:   "Sample Gateway",
+				Scopes: []string{auth.ScopeIntrospect},
+			},
+			clearSecret: "gateway-secret",
+			debugOnly:   true,
+		},
+	}
+)
+
+func SeedClientData(cfg *config.Config, ds store.DataStore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	for _, seedClient := range seedClients {
+		if seedClient.debugOnly && !cfg.Debug {
+			if err := removeSeedClient(ctx, ds, seedClient.client.ID, seedClient.clearSecret); err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := ds.GetClient(ctx, seedClient.client.ID)
+		if err == store.ErrorNotFound {
+			client := seedClient.client
+			if seedClient.clearSecret != "" {
+				if errr := auth.SetClientSecret(&client, seedClient.clearSecret, NewPasswordParams(cfg)); errr != nil {
+					return fmt.Errorf("failed to hash seed client secret: %v", errr)
+				}
+			}
+			client.CreatedAt = time.Now()
+
+			if errr := ds.SaveClient(ctx, &client); errr != nil {
+				return fmt.Errorf("failed to save seed client: %v", errr)
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get client: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// removeSeedClient removes the client seeded before with the public secret, eg. in debug mode, so that
+// the secret can't be used. A client registered with the same id and another secret is kept.
+func removeSeedClient(ctx context.Context, ds store.DataStore, id, clearSecret string) error {
+	_, err := auth.AuthenticateClient(ctx, ds, id, clearSecret)
+	if err == auth.ErrInvalidClient {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get client: %v", err)
+	}
+
+	if err := ds.RemoveClient(ctx, id); err != nil {
+		return fmt.Errorf("failed to remove seed client: %v", err)
+	}
+	log.Printf("removed the sample client %s, which is only seeded in debug mode\n", id)
+
+	return nil
+}
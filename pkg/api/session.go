@@ -17,6 +17,8 @@ type CookieStore struct {
 type SessionToken struct {
 	oauth2.Token
 	UserID string
+	// AuthTime is when the user signed in.
+	AuthTime time.Time
 }
 
 // TODO: For some reason setting cookieSecure true is causing headless e2e tests in Docker container to fail. Add TLS?
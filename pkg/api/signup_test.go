@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/auth"
+)
+
+// setSignUpMode sets the sign-up mode for the test.
+func setSignUpMode(t *testing.T, mode string) {
+	prev := testapp.Config.SignUp
+	testapp.Config.SignUp = mode
+	t.Cleanup(func() {
+		testapp.Config.SignUp = prev
+	})
+}
+
+// newTestUsername returns a username that isn't taken by the users of the previous test runs.
+func newTestUsername() string {
+	return fmt.Sprintf("user%d", time.Now().UnixNano())
+}
+
+func Test_SignUp(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+
+	// Run
+	obj := expect.POST("/v1/signup").
+		WithJSON(map[string]string{
+			"username": username,
+			"password": "correct horse",
+			"name":     "New User",
+			"email":    "new@example.com",
+		}).
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object()
+
+	// Validate
+	obj.Value("username").String().Equal(username)
+	obj.Value("email").String().Equal("new@example.com")
+	obj.NotContainsKey("password")
+
+	// The user can sign in right away.
+	expect.POST("/v1/signin").
+		WithJSON(map[string]string{"username": username, "password": "correct horse"}).
+		Expect().
+		Status(http.StatusOK)
+
+	// The username is taken.
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": username, "password": "another password"}).
+		Expect().
+		Status(http.StatusConflict)
+}
+
+func Test_SignUp_PasswordPolicy(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newHTTPExpect(t)
+	username := newTestUsername()
+
+	// Run and validate
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": username, "password": "my name is " + username}).
+		Expect().
+		Status(http.StatusUnprocessableEntity).
+		JSON().Object().
+		Value("errors").Array().
+		ContainsOnly("Password must not contain the username or email address")
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": username, "password": "aaaa"}).
+		Expect().
+		Status(http.StatusUnprocessableEntity).
+		JSON().Object().
+		Value("errors").Array().
+		ContainsOnly("Password must be at least 8 characters in length",
+			"Password must not repeat a character more than 3 times in a row")
+}
+
+func Test_SignUp_InvalidRequest(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "short"}).
+		Expect().
+		Status(http.StatusUnprocessableEntity)
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse", "email": "invalid"}).
+		Expect().
+		Status(http.StatusUnprocessableEntity)
+}
+
+func Test_SignUp_Disabled(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpDisabled)
+	expect := newHTTPExpect(t)
+
+	// Run and validate
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.GET("/signup").
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+func Test_SignUp_InviteOnly(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpInviteOnly)
+	expect := newHTTPExpect(t)
+	code, err := auth.CreateInvite(context.Background(), testapp.Store, time.Hour)
+	require.NoError(t, err)
+
+	// Run and validate
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse"}).
+		Expect().
+		Status(http.StatusForbidden)
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse", "invite_code": code}).
+		Expect().
+		Status(http.StatusCreated)
+
+	// An invite code can only be used once.
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse", "invite_code": code}).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func Test_SignUp_InviteOnly_TakenUsername(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpInviteOnly)
+	expect := newHTTPExpect(t)
+	code, err := auth.CreateInvite(context.Background(), testapp.Store, time.Hour)
+	require.NoError(t, err)
+
+	// Run and validate that the username isn't checked without an invite.
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": testUser.Username, "password": "correct horse"}).
+		Expect().
+		Status(http.StatusForbidden)
+
+	// The invite is kept if the user isn't created.
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": testUser.Username, "password": "correct horse", "invite_code": code}).
+		Expect().
+		Status(http.StatusConflict)
+	expect.POST("/v1/signup").
+		WithJSON(map[string]string{"username": newTestUsername(), "password": "correct horse", "invite_code": code}).
+		Expect().
+		Status(http.StatusCreated)
+}
+
+func Test_SignUpPage(t *testing.T) {
+	// Setup
+	setSignUpMode(t, auth.SignUpOpen)
+	expect := newBrowserHTTPExpect(t)
+	username := newTestUsername()
+
+	// Run and validate
+	expect.GET("/signup").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(`name="username"`).NotContains(`name="invite_code"`)
+
+	// The validation errors are translated.
+	expect.POST("/signup").
+		WithFormField("username", username).
+		WithFormField("password", "short").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Password must be at least 8 characters in length")
+
+	expect.POST("/signup").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusMovedPermanently).
+		Header("Location").Equal(rootURI)
+
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusMovedPermanently).
+		Header("Location").Equal(successURI)
+}
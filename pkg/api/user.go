@@ -1,7 +1,14 @@
 package api
 
 import (
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/cybersamx/authx/pkg/auth"
 	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/webauthn"
 )
 
 type SignInRequest struct {
@@ -9,14 +16,267 @@ type SignInRequest struct {
 	Password string `json:"password" form:"password" binding:"required"`
 }
 
+type SignUpRequest struct {
+	Username   string `json:"username" form:"username" binding:"required,min=3,max=64"`
+	Password   string `json:"password" form:"password" binding:"required,max=128"`
+	Name       string `json:"name" form:"name" binding:"max=128"`
+	Email      string `json:"email" form:"email" binding:"omitempty,email"`
+	InviteCode string `json:"invite_code" form:"invite_code"`
+}
+
+type ForgotPasswordRequest struct {
+	Username string `json:"username" form:"username" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required,max=128"`
+}
+
+// ChangePasswordRequest changes the password of the signed-in user. The other sessions of the user
+// are signed out if RevokeOtherSessions is true.
+type ChangePasswordRequest struct {
+	CurrentPassword     string `json:"current_password" form:"current_password" binding:"required"`
+	NewPassword         string `json:"new_password" form:"new_password" binding:"required,max=128"`
+	RevokeOtherSessions bool   `json:"revoke_other_sessions" form:"revoke_other_sessions"`
+}
+
+// MFASignInRequest completes a sign-in with the code of the second factor, either a TOTP code or a
+// recovery code.
+type MFASignInRequest struct {
+	MFAToken string `json:"mfa_token" form:"mfa_token" binding:"required"`
+	Code     string `json:"code" form:"code" binding:"required"`
+}
+
+// MFATokenRequest enrolls the TOTP of a user who must do so to complete the sign-in.
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token" form:"mfa_token" binding:"required"`
+}
+
+type EnrollTOTPRequest struct {
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" form:"code" binding:"required"`
+}
+
+type DisableMFARequest struct {
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// WebAuthnBeginRegistrationRequest starts the registration of a passkey. The password may be left out
+// right after signing in on the web.
+type WebAuthnBeginRegistrationRequest struct {
+	Password string `json:"password" form:"password"`
+}
+
+// WebAuthnRegistrationRequest completes the registration of a passkey with the credential created by
+// the authenticator, and names it.
+type WebAuthnRegistrationRequest struct {
+	Name       string                        `json:"name" binding:"max=64"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// WebAuthnSignInRequest completes a sign-in with the assertion signed by the authenticator.
+type WebAuthnSignInRequest struct {
+	Credential webauthn.AuthenticationResponse `json:"credential"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" form:"grant_type" binding:"required"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	Code         string `json:"code" form:"code"`
+	RedirectURI  string `json:"redirect_uri" form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+	Scope        string `json:"scope" form:"scope"`
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+}
+
+// TokenResponse is the response of the token endpoint. The id token is only issued to an OpenID
+// Connect authentication request.
+type TokenResponse struct {
+	*oauth2.Token
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+	IDToken   string `json:"id_token,omitempty"`
+}
+
+// DiscoveryResponse is the OpenID Connect provider metadata.
+type DiscoveryResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type RevocationRequest struct {
+	Token         string `json:"token" form:"token" binding:"required"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"`
+}
+
+type IntrospectionRequest struct {
+	Token         string `json:"token" form:"token" binding:"required"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"`
+}
+
+// IntrospectionResponse is the response of the token introspection endpoint as defined in RFC 7662.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+}
+
+// PolicyErrorResponse lists the translated rules of the password policy that a password breaks.
+type PolicyErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+// MFARequiredResponse is the response of a sign-in with the password that must be completed with the
+// second factor, or after enrolling it if Error is "mfa_enrollment_required".
+type MFARequiredResponse struct {
+	Error     string `json:"error"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// MFASignInResponse carries the tokens of a sign-in completed with the second factor, and the
+// recovery codes if the TOTP has just been enrolled.
+type MFASignInResponse struct {
+	*oauth2.Token
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// TOTPEnrollmentResponse carries the secret of a pending TOTP, and the URI that sets up an
+// authenticator app with it.
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// WebAuthnCreationResponse carries the options for navigator.credentials.create().
+type WebAuthnCreationResponse struct {
+	PublicKey *webauthn.CreationOptions `json:"publicKey"`
+}
+
+// WebAuthnRequestResponse carries the options for navigator.credentials.get().
+type WebAuthnRequestResponse struct {
+	PublicKey *webauthn.RequestOptions `json:"publicKey"`
+}
+
+type WebAuthnCredentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// UserInfoResponse carries the standard OpenID Connect claims of the user. The id and username
+// fields predate the standard claims and are kept for existing clients.
 type UserInfoResponse struct {
+	Subject string `json:"sub"`
+	auth.UserClaims
 	ID       string `json:"id"`
 	Username string `json:"username"`
 }
 
-func user2UserInfoResponse(user *models.User) *UserInfoResponse {
+func tokenInfo2IntrospectionResponse(info *auth.TokenInfo) *IntrospectionResponse {
+	if info == nil {
+		return &IntrospectionResponse{Active: false}
+	}
+
+	// A token issued by the client credentials grant has the client as the subject.
+	sub := info.UserID
+	if sub == "" {
+		sub = info.ClientID
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     info.Scope,
+		ClientID:  info.ClientID,
+		TokenType: info.TokenType,
+		Exp:       info.ExpireAt.Unix(),
+		Iat:       info.IssuedAt.Unix(),
+		Sub:       sub,
+		JTI:       info.ID,
+	}
+}
+
+func signUpRequest2Params(req *SignUpRequest) *auth.SignUpParams {
+	return &auth.SignUpParams{
+		Username:   req.Username,
+		Password:   req.Password,
+		Name:       req.Name,
+		Email:      req.Email,
+		InviteCode: req.InviteCode,
+	}
+}
+
+// firstPartyScope is the scope of the user info that the user's own sign-in reads.
+var firstPartyScope = strings.Join([]string{auth.ScopeProfile, auth.ScopeEmail}, " ")
+
+// user2UserInfoResponse returns the user info with the claims released for the scope.
+func user2UserInfoResponse(user *models.User, scope string) *UserInfoResponse {
 	return &UserInfoResponse{
-		ID:       user.ID,
-		Username: user.Username,
+		Subject:    user.ID,
+		UserClaims: *auth.NewUserClaims(user, scope),
+		ID:         user.ID,
+		Username:   user.Username,
+	}
+}
+
+func webAuthnCredential2Response(cred *models.WebAuthnCredential) *WebAuthnCredentialResponse {
+	resp := WebAuthnCredentialResponse{
+		ID:        cred.ID,
+		Name:      cred.Name,
+		CreatedAt: cred.CreatedAt,
+	}
+	if !cred.LastUsedAt.IsZero() {
+		resp.LastUsedAt = &cred.LastUsedAt
 	}
+
+	return &resp
+}
+
+func newTokenResponse(otoken *oauth2.Token) *TokenResponse {
+	resp := TokenResponse{Token: otoken}
+	if !otoken.Expiry.IsZero() {
+		resp.ExpiresIn = int64(time.Until(otoken.Expiry).Seconds())
+	}
+
+	return &resp
 }
@@ -14,6 +14,8 @@ import (
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	ent "github.com/go-playground/validator/v10/translations/en"
+
+	"github.com/cybersamx/authx/pkg/auth"
 )
 
 func NewValidate(trans ut.Translator) *validator.Validate {
@@ -36,10 +38,28 @@ func NewEnglishTranslator() ut.Translator {
 	if !ok {
 		log.Panicf("failed to get %s translator for translating validation errors", locale)
 	}
+	registerPolicyTranslations(trans)
 
 	return trans
 }
 
+// registerPolicyTranslations adds the English messages of the password policy violations.
+func registerPolicyTranslations(trans ut.Translator) {
+	msgs := map[string]string{
+		auth.PolicyMinLength:      "Password must be at least {0} characters in length",
+		auth.PolicyMinCharClasses: "Password must contain at least {0} of lowercase letters, uppercase letters, digits and symbols",
+		auth.PolicyMaxRepeated:    "Password must not repeat a character more than {0} times in a row",
+		auth.PolicyUserInfo:       "Password must not contain the username or email address",
+		auth.PolicyBreached:       "Password has appeared in a data breach, choose a different one",
+	}
+
+	for key, msg := range msgs {
+		if err := trans.Add(key, msg, false); err != nil {
+			log.Panicf("failed to register the translation of %s: %v", key, err)
+		}
+	}
+}
+
 func NewTemplate(tmplDir string) *template.Template {
 	files, err := filepath.Glob(fmt.Sprintf("%s/*.gohtml", tmplDir))
 	if err != nil {
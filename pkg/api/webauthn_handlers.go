@@ -0,0 +1,295 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/webauthn"
+)
+
+// reauthWindow is how long after signing in on the web a passkey can be registered without the
+// password.
+const reauthWindow = 5 * time.Minute
+
+var ErrReauthRequired = errors.New("password or recent sign-in required")
+
+// reauthenticate checks that the user has just proved who they are, with the password or by signing
+// in to the session within reauthWindow, so that a stolen access token or session alone can't add a
+// passkey to the account. A wrong password counts as a failed sign-in, so that the password can't be
+// guessed with the access token either.
+func reauthenticate(ctx *gin.Context, cfg *config.Config, ds store.DataStore, user *models.User, password string) error {
+	if password != "" {
+		return throttlePasswordCheck(ctx, cfg, ds, user.Username, func() error {
+			if !auth.Authenticate(user.Password, password, user.Salt) {
+				return auth.ErrInvalidCredentials
+			}
+
+			return nil
+		})
+	}
+
+	authTime := getAuthTimeFromContext(ctx)
+	if authTime.IsZero() || time.Since(authTime) > reauthWindow {
+		return ErrReauthRequired
+	}
+
+	return nil
+}
+
+// finishWebAuthnSignIn verifies the assertion of a passkey sign-in and returns the user, and whether
+// the sign-in must still be completed with the second factor. A passkey that the authenticator has
+// verified the user of, eg. with a biometric or a PIN, counts as both factors.
+func finishWebAuthnSignIn(ctx *gin.Context, cfg *config.Config, ds store.DataStore,
+	cred *webauthn.AuthenticationResponse) (*models.User, bool, error) {
+	user, verified, err := NewWebAuthn(cfg, ds).FinishSignIn(ctx, cred)
+	if err != nil {
+		return nil, false, err
+	}
+	if cfg.RequireVerified && !user.EmailVerified {
+		return nil, false, auth.ErrEmailNotVerified
+	}
+
+	return user, !verified && auth.MFARequired(user, cfg.MFARequiredRoles), nil
+}
+
+// BeginWebAuthnRegistration starts the registration of a passkey of the signed-in user, who must have
+// re-authenticated.
+func (ah *AuthHandlers) BeginWebAuthnRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var breq WebAuthnBeginRegistrationRequest
+		if err := ctx.ShouldBindJSON(&breq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := reauthenticate(ctx, ah.cfg, ah.ds, user, breq.Password); err != nil {
+			if terr, ok := err.(*auth.ThrottleError); ok {
+				setThrottleStatus(ctx, terr)
+				return
+			}
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		}
+
+		options, err := NewWebAuthn(ah.cfg, ah.ds).BeginRegistration(ctx, user)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, &WebAuthnCreationResponse{PublicKey: options})
+	}
+}
+
+// FinishWebAuthnRegistration saves the passkey created by the authenticator of the signed-in user. The
+// challenge of the registration is only issued after re-authenticating, and expires with the timeout
+// of the ceremony, so the passkey is saved shortly after it.
+func (ah *AuthHandlers) FinishWebAuthnRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var wreq WebAuthnRegistrationRequest
+		if err := ctx.ShouldBindJSON(&wreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, err := ah.ds.GetUser(ctx, getUserIDFromContext(ctx))
+		if err == store.ErrorNotFound {
+			setErrorStatus(ctx, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		cred, err := NewWebAuthn(ah.cfg, ah.ds).FinishRegistration(ctx, user, wreq.Name, &wreq.Credential)
+		switch err {
+		case nil:
+		case auth.ErrInvalidWebAuthnChallenge, auth.ErrInvalidWebAuthnResponse:
+			setErrorStatus(ctx, err, http.StatusBadRequest)
+			return
+		case auth.ErrWebAuthnCredExists:
+			setErrorStatus(ctx, err, http.StatusConflict)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, webAuthnCredential2Response(cred))
+	}
+}
+
+// WebAuthnCredentials lists the passkeys of the user of the access token.
+func (ah *AuthHandlers) WebAuthnCredentials() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		creds, err := NewWebAuthn(ah.cfg, ah.ds).Credentials(ctx, getUserIDFromContext(ctx))
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]*WebAuthnCredentialResponse, 0, len(creds))
+		for _, cred := range creds {
+			resp = append(resp, webAuthnCredential2Response(cred))
+		}
+
+		ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+// RemoveWebAuthnCredential removes a passkey of the user of the access token.
+func (ah *AuthHandlers) RemoveWebAuthnCredential() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := NewWebAuthn(ah.cfg, ah.ds).RemoveCredential(ctx, getUserIDFromContext(ctx), ctx.Param("id"))
+		if err == auth.ErrUnknownWebAuthnCred {
+			setErrorStatus(ctx, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// BeginWebAuthnSignIn starts a sign-in with a passkey.
+func (ah *AuthHandlers) BeginWebAuthnSignIn() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		options, err := NewWebAuthn(ah.cfg, ah.ds).BeginSignIn(ctx)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, &WebAuthnRequestResponse{PublicKey: options})
+	}
+}
+
+// FinishWebAuthnSignIn signs in the user of the passkey that signed the assertion. A user who must sign
+// in with a second factor completes the sign-in with it, unless the authenticator verified the user.
+func (ah *AuthHandlers) FinishWebAuthnSignIn() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Bind inputs
+		var wreq WebAuthnSignInRequest
+		if err := ctx.ShouldBindJSON(&wreq); err != nil {
+			setErrorStatus(ctx, ErrInvalidRequest, http.StatusUnprocessableEntity)
+			return
+		}
+
+		user, mfaRequired, err := finishWebAuthnSignIn(ctx, ah.cfg, ah.ds, &wreq.Credential)
+		switch err {
+		case nil:
+		case auth.ErrInvalidWebAuthnChallenge, auth.ErrInvalidWebAuthnResponse, auth.ErrUnknownWebAuthnCred,
+			auth.ErrWebAuthnSignCount:
+			setErrorStatus(ctx, err, http.StatusUnauthorized)
+			return
+		case auth.ErrEmailNotVerified:
+			setErrorStatus(ctx, err, http.StatusForbidden)
+			return
+		default:
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		if mfaRequired {
+			resp, err := newMFARequiredResponse(ctx, ah.cfg, ah.ds, user)
+			if err != nil {
+				setErrorStatus(ctx, err, http.StatusInternalServerError)
+				return
+			}
+
+			ctx.JSON(http.StatusUnauthorized, resp)
+			_ = ctx.Error(auth.ErrMFARequired)
+			return
+		}
+
+		otoken, err := createSignInToken(ctx, ah.cfg, ah.ds, ah.tc, user)
+		if err != nil {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, otoken)
+	}
+}
+
+// postSignInWebAuthn signs in the user of the passkey and returns the error messages, if any.
+func (hh *HTMLHandlers) postSignInWebAuthn(ctx *gin.Context, wreq *WebAuthnSignInRequest) []string {
+	var msgs []string
+
+	user, mfaRequired, err := finishWebAuthnSignIn(ctx, hh.cfg, hh.ds, &wreq.Credential)
+	switch err {
+	case nil:
+	case auth.ErrInvalidWebAuthnChallenge:
+		return append(msgs, "The sign-in has expired, please try again")
+	case auth.ErrInvalidWebAuthnResponse, auth.ErrUnknownWebAuthnCred, auth.ErrWebAuthnSignCount:
+		return append(msgs, "The passkey isn't valid")
+	case auth.ErrEmailNotVerified:
+		return append(msgs, "Verify your email address before signing in")
+	default:
+		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+	}
+
+	// The page of the second factor can't follow a script, so the user signs in with the password.
+	if mfaRequired {
+		return append(msgs, "Two-factor authentication is required, please sign in with your password")
+	}
+
+	if err := hh.startSession(ctx, user); err != nil {
+		return append(msgs, fmt.Sprintf("Internal error: %s", err))
+	}
+
+	return msgs
+}
+
+// SignInWebAuthn handles the assertion posted by the script of the sign-in page. It responds with the
+// page to go to, or the error messages to show.
+func (hh *HTMLHandlers) SignInWebAuthn() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var wreq WebAuthnSignInRequest
+		if err := ctx.ShouldBindJSON(&wreq); err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"errors": []string{"Invalid request"}})
+			return
+		}
+
+		if msgs := hh.postSignInWebAuthn(ctx, &wreq); len(msgs) > 0 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"errors": msgs})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"redirect": getRedirectPath(ctx.Query("next"))})
+	}
+}
+
+// RemoveWebAuthnCredential handles the form that removes a passkey on the profile page.
+func (hh *HTMLHandlers) RemoveWebAuthnCredential() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := NewWebAuthn(hh.cfg, hh.ds).RemoveCredential(ctx, getUserIDFromContext(ctx), ctx.Param("id"))
+		if err != nil && err != auth.ErrUnknownWebAuthnCred {
+			setErrorStatus(ctx, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, successURI)
+	}
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/auth"
+	"github.com/cybersamx/authx/pkg/webauthn"
+	"github.com/cybersamx/authx/pkg/webauthn/webauthntest"
+)
+
+// newTestAuthenticator returns a software authenticator of the relying party of the test app, ie. of
+// the issuer.
+func newTestAuthenticator(t *testing.T) *webauthntest.Authenticator {
+	issuer, err := url.Parse(testapp.Config.Issuer)
+	require.NoError(t, err)
+
+	return webauthntest.New(issuer.Hostname(), issuer.Scheme+"://"+issuer.Host)
+}
+
+// decodeJSONValue converts the JSON value of a response to the type of v.
+func decodeJSONValue(t *testing.T, value *httpexpect.Value, v interface{}) {
+	data, err := json.Marshal(value.Raw())
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
+
+// registerPasskey registers a passkey of the authenticator for the user of the access token.
+func registerPasskey(t *testing.T, expect *httpexpect.Expect, authn *webauthntest.Authenticator,
+	at, name string) *httpexpect.Object {
+	var options webauthn.CreationOptions
+	decodeJSONValue(t, expect.POST("/v1/me/webauthn/register/begin").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(&WebAuthnBeginRegistrationRequest{Password: "correct horse"}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("publicKey"), &options)
+	cred, err := authn.Register(&options)
+	require.NoError(t, err)
+
+	return expect.POST("/v1/me/webauthn/register/finish").
+		WithHeader("Authorization", "Bearer "+at).
+		WithJSON(&WebAuthnRegistrationRequest{Name: name, Credential: *cred}).
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object()
+}
+
+// assertPasskey signs an assertion of a passkey sign-in with the authenticator.
+func assertPasskey(t *testing.T, expect *httpexpect.Expect,
+	authn *webauthntest.Authenticator) *WebAuthnSignInRequest {
+	var options webauthn.RequestOptions
+	decodeJSONValue(t, expect.POST("/v1/signin/webauthn/begin").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("publicKey"), &options)
+	cred, err := authn.Assert(&options)
+	require.NoError(t, err)
+
+	return &WebAuthnSignInRequest{Credential: *cred}
+}
+
+func Test_WebAuthn_RegisterAndSignIn(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	authn := newTestAuthenticator(t)
+
+	// Run
+	for _, breq := range []WebAuthnBeginRegistrationRequest{{}, {Password: "wrong password"}} {
+		expect.POST("/v1/me/webauthn/register/begin").
+			WithHeader("Authorization", "Bearer "+at).
+			WithJSON(&breq).
+			Expect().
+			Status(http.StatusForbidden)
+	}
+	cred := registerPasskey(t, expect, authn, at, "Laptop")
+	cred.ValueEqual("name", "Laptop")
+	id := cred.Value("id").String().NotEmpty().Raw()
+
+	// Validate
+	signin := assertPasskey(t, expect, authn)
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(signin).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().NotEmpty()
+
+	// The assertion can't be replayed.
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(signin).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	creds := expect.GET("/v1/me/webauthn/credentials").
+		WithHeader("Authorization", "Bearer "+at).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Array()
+	creds.Length().Equal(1)
+	creds.Element(0).Object().ValueEqual("id", id)
+	creds.Element(0).Object().Value("last_used_at").String().NotEmpty()
+}
+
+func Test_WebAuthn_PasswordThrottle(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	setSignInThrottle(t, 0, 1, 0)
+	t.Cleanup(func() {
+		require.NoError(t, auth.UnlockAccount(context.Background(), testapp.Store, username))
+	})
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	begin := func(password string) *httpexpect.Response {
+		return expect.POST("/v1/me/webauthn/register/begin").
+			WithHeader("Authorization", "Bearer "+at).
+			WithJSON(&WebAuthnBeginRegistrationRequest{Password: password}).
+			Expect()
+	}
+
+	// Run and validate that the password can't be guessed with the access token.
+	begin("wrong password").Status(http.StatusForbidden)
+	begin("correct horse").Status(http.StatusTooManyRequests).Header("Retry-After").NotEmpty()
+}
+
+func Test_WebAuthn_ClonedAuthenticator(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	authn := newTestAuthenticator(t)
+	registerPasskey(t, expect, authn, at, "")
+	clone := authn.Clone()
+
+	// Run
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(assertPasskey(t, expect, authn)).
+		Expect().
+		Status(http.StatusOK)
+
+	// Validate
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(assertPasskey(t, expect, clone)).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_WebAuthn_MFARequired(t *testing.T) {
+	// Setup
+	setMFARequiredRoles(t, "admin")
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	authn := newTestAuthenticator(t)
+	registerPasskey(t, expect, authn, at, "")
+
+	ctx := context.Background()
+	user, err := testapp.Store.GetUserByUsername(ctx, username)
+	require.NoError(t, err)
+	user.Claims = map[string]interface{}{"roles": []string{"admin"}}
+	require.NoError(t, testapp.Store.UpdateUser(ctx, user))
+
+	// Run
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(assertPasskey(t, expect, authn)).
+		Expect().
+		Status(http.StatusUnauthorized).
+		JSON().Object().
+		ValueEqual("error", "mfa_enrollment_required").
+		Value("mfa_token").String().NotEmpty()
+
+	// Validate
+	// A passkey that verifies the user counts as both factors.
+	authn.UserVerified = true
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(assertPasskey(t, expect, authn)).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("access_token").String().NotEmpty()
+}
+
+func Test_WebAuthn_RemoveCredential(t *testing.T) {
+	// Setup
+	expect := newHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	at := signIn(expect, username, "correct horse").
+		JSON().Object().
+		Value("access_token").String().Raw()
+	authn := newTestAuthenticator(t)
+	id := registerPasskey(t, expect, authn, at, "").
+		Value("id").String().Raw()
+
+	// Run
+	expect.DELETE("/v1/me/webauthn/credentials/"+id).
+		WithHeader("Authorization", "Bearer "+at).
+		Expect().
+		Status(http.StatusNoContent)
+
+	// Validate
+	expect.DELETE("/v1/me/webauthn/credentials/"+id).
+		WithHeader("Authorization", "Bearer "+at).
+		Expect().
+		Status(http.StatusNotFound)
+	expect.GET("/v1/me/webauthn/credentials").
+		WithHeader("Authorization", "Bearer "+at).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Array().Empty()
+	expect.POST("/v1/signin/webauthn/finish").
+		WithJSON(assertPasskey(t, expect, authn)).
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func Test_WebAuthnPage(t *testing.T) {
+	// Setup
+	expect := newBrowserHTTPExpect(t)
+	username := newMFAUser(t, expect)
+	expect.POST("/").
+		WithFormField("username", username).
+		WithFormField("password", "correct horse").
+		Expect().
+		Status(http.StatusMovedPermanently)
+	authn := newTestAuthenticator(t)
+
+	// Run
+	expect.POST("/me/webauthn/register/begin").
+		WithJSON(&WebAuthnBeginRegistrationRequest{Password: "wrong password"}).
+		Expect().
+		Status(http.StatusForbidden)
+
+	// The session has just signed in.
+	var options webauthn.CreationOptions
+	decodeJSONValue(t, expect.POST("/me/webauthn/register/begin").
+		WithJSON(&WebAuthnBeginRegistrationRequest{}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("publicKey"), &options)
+	cred, err := authn.Register(&options)
+	require.NoError(t, err)
+	expect.POST("/me/webauthn/register/finish").
+		WithJSON(&WebAuthnRegistrationRequest{Name: "Phone", Credential: *cred}).
+		Expect().
+		Status(http.StatusCreated)
+	expect.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("Phone").Contains("btn-add-passkey")
+
+	// Validate
+	browser := newBrowserHTTPExpect(t)
+	browser.GET("/").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains("btn-passkey")
+	browser.POST("/signin/webauthn").
+		WithQuery("next", "/userinfo").
+		WithJSON(assertPasskey(t, browser, authn)).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("redirect", "/userinfo")
+	browser.GET("/userinfo").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(username)
+
+	// A failed sign-in responds with the messages to show.
+	browser.POST("/signin/webauthn").
+		WithJSON(&WebAuthnSignInRequest{}).
+		Expect().
+		Status(http.StatusUnauthorized).
+		JSON().Object().
+		Value("errors").Array().Length().Equal(1)
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/cybersamx/authx/pkg/config"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/memory"
+	"github.com/cybersamx/authx/pkg/store/mongo"
+	"github.com/cybersamx/authx/pkg/store/sql"
+)
+
+const (
+	storeMongo    = "mongo"
+	storePostgres = "postgres"
+	storeSQLite   = "sqlite"
+	storeMemory   = "memory"
+)
+
+var (
+	ErrUnsupportedStore = errors.New("unsupported store")
+)
+
+// NewDataStore returns the data store selected in the config. The memory store doesn't need a
+// database, but its data is lost when the app exits, so it's meant for the tests and development.
+func NewDataStore(cfg *config.Config) (store.DataStore, error) {
+	switch cfg.Store {
+	case storeMongo:
+		return newMongoStore(cfg)
+	case storePostgres:
+		return newSQLStore(sql.Postgres, cfg.PostgresAddr)
+	case storeSQLite:
+		return newSQLStore(sql.SQLite, cfg.SQLiteFile)
+	case storeMemory:
+		return memory.New(), nil
+	}
+
+	return nil, ErrUnsupportedStore
+}
+
+func newMongoStore(cfg *config.Config) (store.DataStore, error) {
+	s, err := mongo.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func newSQLStore(dialect, dsn string) (store.DataStore, error) {
+	s, err := sql.New(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	CodeChallengeMethodS256 = "S256"
+
+	authCodeLen = 32
+	authCodeTTL = time.Minute
+)
+
+var (
+	ErrInvalidAuthCode          = errors.New("invalid authorization code")
+	ErrInvalidCodeVerifier      = errors.New("invalid pkce code verifier")
+	ErrUnsupportedPKCEMethod    = errors.New("unsupported pkce code challenge method")
+	ErrMissingPKCECodeChallenge = errors.New("missing pkce code challenge")
+
+	// As defined in https://tools.ietf.org/html/rfc7636#section-4.1
+	codeVerifierRegexp = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+)
+
+// AuthCodeParams are the parameters of an authorization request bound to the authorization code.
+type AuthCodeParams struct {
+	ClientID            string
+	UserID              string
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	// AuthTime is when the user signed in.
+	AuthTime time.Time
+}
+
+// hashCode returns the id under which a code, eg. an authorization code or an invite code, is saved,
+// so that the codes can't be redeemed by anyone who can read the data store.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// CodeChallengeS256 derives the S256 code challenge from the code verifier.
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeChallengeParams checks that the authorization request carries a PKCE code challenge
+// using a supported method. Only S256 is supported.
+func VerifyCodeChallengeParams(challenge, method string) error {
+	if challenge == "" {
+		return ErrMissingPKCECodeChallenge
+	}
+	if method != CodeChallengeMethodS256 {
+		return ErrUnsupportedPKCEMethod
+	}
+
+	return nil
+}
+
+// CreateAuthCode generates an authorization code and saves it to the data store.
+func CreateAuthCode(parent context.Context, ds store.DataStore, params *AuthCodeParams) (string, error) {
+	if err := VerifyCodeChallengeParams(params.CodeChallenge, params.CodeChallengeMethod); err != nil {
+		return "", err
+	}
+
+	code, err := utils.GetRandSecret(authCodeLen)
+	if err != nil {
+		return "", err
+	}
+
+	ac := models.AuthCode{
+		ID:                  hashCode(code),
+		ClientID:            params.ClientID,
+		UserID:              params.UserID,
+		RedirectURI:         params.RedirectURI,
+		Scope:               params.Scope,
+		CodeChallenge:       params.CodeChallenge,
+		CodeChallengeMethod: params.CodeChallengeMethod,
+		Nonce:               params.Nonce,
+		AuthTime:            params.AuthTime,
+		ExpireAt:            time.Now().Add(authCodeTTL),
+	}
+	if err := ds.SaveAuthCode(parent, &ac); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeAuthCode redeems the authorization code. The code can only be redeemed once, by the client
+// it was issued to, using the same redirect uri and the code verifier of the code challenge.
+func ExchangeAuthCode(parent context.Context, ds store.DataStore,
+	code, clientID, redirectURI, verifier string) (*models.AuthCode, error) {
+	ac, err := ds.ConsumeAuthCode(parent, hashCode(code))
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidAuthCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(ac.ExpireAt) || ac.ClientID != clientID || ac.RedirectURI != redirectURI {
+		return nil, ErrInvalidAuthCode
+	}
+
+	if !codeVerifierRegexp.MatchString(verifier) {
+		return nil, ErrInvalidCodeVerifier
+	}
+	challenge := CodeChallengeS256(verifier)
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(ac.CodeChallenge)) != 1 {
+		return nil, ErrInvalidCodeVerifier
+	}
+
+	return ac, nil
+}
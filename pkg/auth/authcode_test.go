@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CodeChallengeS256(t *testing.T) {
+	// Example from https://tools.ietf.org/html/rfc7636#appendix-B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallengeS256(verifier))
+}
+
+func Test_VerifyCodeChallengeParams(t *testing.T) {
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.NoError(t, VerifyCodeChallengeParams(challenge, CodeChallengeMethodS256))
+	assert.Equal(t, ErrMissingPKCECodeChallenge, VerifyCodeChallengeParams("", CodeChallengeMethodS256))
+	assert.Equal(t, ErrUnsupportedPKCEMethod, VerifyCodeChallengeParams(challenge, "plain"))
+	assert.Equal(t, ErrUnsupportedPKCEMethod, VerifyCodeChallengeParams(challenge, ""))
+}
@@ -1,35 +1,185 @@
 package auth
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
-	"encoding/hex"
+	"context"
 	"errors"
+	"log"
+	"sync"
 
-	"golang.org/x/crypto/pbkdf2"
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid authentication credentials")
 )
 
 const (
-	pwdHashLen       = 64
-	pwdHashIteration = 8
+	// dummyPassword is hashed in place of the password of an unknown user.
+	dummyPassword = "authx dummy password"
+
+	// maxUpdateAttempts is how many times a user is updated before giving up on the concurrent
+	// updates.
+	maxUpdateAttempts = 3
 )
 
 var (
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidCredentials = errors.New("invalid authentication credentials")
+	// dummyHashes caches a hash of dummyPassword per PasswordParams.
+	dummyHashes sync.Map
+
+	// verifyDummyHash verifies a password against a dummy hash. The tests replace it to count the
+	// verifications.
+	verifyDummyHash = crypto.VerifyPassword
 )
 
-func hashString(str, salt string) string {
-	var hashed []byte
-	textData := []byte(str)
-	saltData := []byte(salt)
-	hashed = pbkdf2.Key(textData, saltData, 1<<pwdHashIteration, pwdHashLen, sha256.New)
+// Authenticate returns true if the clear text matches the hash. The salt is only needed by the
+// legacy hashes.
+func Authenticate(hashed, clear, salt string) bool {
+	return crypto.VerifyPassword(hashed, clear, salt)
+}
+
+// AuthenticateUser returns true if the password of the user is valid. The password is hashed again
+// with the current parameters if the hash is outdated, eg. a legacy hash, so that the existing
+// users migrate as they sign in. A failed rehash is logged and doesn't fail the sign-in.
+func AuthenticateUser(parent context.Context, ds store.DataStore, user *models.User, password string,
+	params *crypto.PasswordParams) bool {
+	if !Authenticate(user.Password, password, user.Salt) {
+		return false
+	}
+
+	if crypto.NeedsRehash(user.Password, params) {
+		if err := setPassword(parent, ds, user, password, params); err != nil {
+			log.Printf("failed to rehash the password of user %s: %v\n", user.ID, err)
+		}
+	}
 
-	return hex.EncodeToString(hashed)
+	return true
 }
 
-func Authenticate(hashed, clear, salt string) bool {
-	hashedClear := hashString(clear, salt)
+// SignInUser returns the user with the username if the password is valid, or ErrInvalidCredentials
+// otherwise. An unknown username takes as long to check as a wrong password, ie. a password is
+// verified against a dummy hash, so that neither the error nor the timing reveal whether the user
+// exists.
+func SignInUser(parent context.Context, ds store.DataStore, username, password string,
+	params *crypto.PasswordParams) (*models.User, error) {
+	user, err := ds.GetUserByUsername(parent, username)
+	if err == store.ErrorNotFound {
+		verifyDummyPassword(password, params)
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !AuthenticateUser(parent, ds, user, password, params) {
+		// An outdated hash, eg. a legacy one, is cheaper to verify than a current one, so the dummy
+		// hash is verified as well to take as long as for an unknown user.
+		if crypto.NeedsRehash(user.Password, params) {
+			verifyDummyPassword(password, params)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// PrepareDummyPassword hashes the dummy password with the parameters ahead of the sign-ins, so that
+// the first sign-in of an unknown user doesn't take longer. A failure is logged.
+func PrepareDummyPassword(params *crypto.PasswordParams) {
+	getDummyHash(params)
+}
+
+// getDummyHash returns the hash of the dummy password with the parameters, or false if it can't be
+// hashed.
+func getDummyHash(params *crypto.PasswordParams) (string, bool) {
+	hashed, ok := dummyHashes.Load(*params)
+	if !ok {
+		h, err := crypto.HashPassword(dummyPassword, params)
+		if err != nil {
+			log.Printf("failed to hash the dummy password: %v\n", err)
+			return "", false
+		}
+		hashed, _ = dummyHashes.LoadOrStore(*params, h)
+	}
+
+	return hashed.(string), true
+}
+
+// verifyDummyPassword verifies the password against a hash with the parameters, which costs as much
+// as verifying the password of a user hashed with the same parameters.
+func verifyDummyPassword(password string, params *crypto.PasswordParams) {
+	if hashed, ok := getDummyHash(params); ok {
+		verifyDummyHash(hashed, password, "")
+	}
+}
+
+// ChangePassword sets a new password for the user, provided that the current password is valid and
+// the new one follows the policy, if set.
+func ChangePassword(parent context.Context, ds store.DataStore, uid, current, password string,
+	params *crypto.PasswordParams, policy *PasswordPolicy) (*models.User, error) {
+	user, err := ds.GetUser(parent, uid)
+	if err == store.ErrorNotFound {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !Authenticate(user.Password, current, user.Salt) {
+		return nil, ErrInvalidCredentials
+	}
+	if err := checkPassword(policy, password, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+	if err := setPassword(parent, ds, user, password, params); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// setPassword hashes the password with a new salt and saves it as the password of the user.
+func setPassword(parent context.Context, ds store.DataStore, user *models.User, password string,
+	params *crypto.PasswordParams) error {
+	hashed, err := crypto.HashPassword(password, params)
+	if err != nil {
+		return err
+	}
+
+	return updateUser(parent, ds, user, func(updated *models.User) error {
+		updated.Password = hashed
+		// The salt is embedded in the hash.
+		updated.Salt = ""
+		return nil
+	})
+}
+
+// updateUser saves the changes that update makes to a copy of the user, and sets the user to the
+// saved one. If the user has been updated since it was read, it's read again and the changes are
+// made again, up to maxUpdateAttempts times, so that neither update is lost. The update returns an
+// error if the changes no longer apply to the user read again.
+func updateUser(parent context.Context, ds store.DataStore, user *models.User,
+	update func(updated *models.User) error) error {
+	for attempt := 1; ; attempt++ {
+		updated := *user
+		if err := update(&updated); err != nil {
+			return err
+		}
+
+		err := ds.UpdateUser(parent, &updated)
+		if err == nil {
+			*user = updated
+			return nil
+		} else if err != store.ErrConflict || attempt == maxUpdateAttempts {
+			return err
+		}
 
-	return subtle.ConstantTimeCompare([]byte(hashed), []byte(hashedClear)) == 1
+		latest, err := ds.GetUser(parent, user.ID)
+		if err == store.ErrorNotFound {
+			return ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+		*user = *latest
+	}
 }
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/memory"
+)
+
+// testPasswordParams are cheap parameters to keep the tests fast.
+var testPasswordParams = &crypto.PasswordParams{
+	Algorithm:     crypto.AlgorithmArgon2id,
+	Argon2Time:    1,
+	Argon2Memory:  64,
+	Argon2Threads: 1,
+	BcryptCost:    4,
+}
+
+// userStore is a data store that only keeps users.
+type userStore struct {
+	store.DataStore
+
+	users map[string]*models.User
+}
+
+func (us *userStore) GetUser(_ context.Context, id string) (*models.User, error) {
+	user, ok := us.users[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	copied := *user
+
+	return &copied, nil
+}
+
+func (us *userStore) GetUserByUsername(_ context.Context, username string) (*models.User, error) {
+	for _, user := range us.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+
+	return nil, store.ErrorNotFound
+}
+
+func (us *userStore) UpdateUser(_ context.Context, user *models.User) error {
+	if _, ok := us.users[user.ID]; !ok {
+		return store.ErrorNotFound
+	}
+	us.users[user.ID] = user
+
+	return nil
+}
+
+// racingStore is a data store whose users are changed by a concurrent request right before each of
+// the first races updates.
+type racingStore struct {
+	store.DataStore
+
+	races  int
+	change func(user *models.User)
+}
+
+func (rs *racingStore) UpdateUser(parent context.Context, user *models.User) error {
+	if rs.races > 0 {
+		rs.races--
+		saved, err := rs.DataStore.GetUser(parent, user.ID)
+		if err != nil {
+			return err
+		}
+		rs.change(saved)
+		if err := rs.DataStore.UpdateUser(parent, saved); err != nil {
+			return err
+		}
+	}
+
+	return rs.DataStore.UpdateUser(parent, user)
+}
+
+// newRacingStore returns a racing store of the users that changes the claims of a user.
+func newRacingStore(t *testing.T, races int, users ...*models.User) *racingStore {
+	ds := memory.New()
+	for _, user := range users {
+		require.NoError(t, ds.SaveUser(context.Background(), user))
+	}
+
+	return &racingStore{DataStore: ds, races: races, change: func(user *models.User) {
+		user.Claims = map[string]interface{}{"team": "blue"}
+	}}
+}
+
+func Test_AuthenticateUser_RehashLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	// PBKDF2-SHA256 hash of "mypassword" with the salt "salt".
+	user := models.User{
+		ID: "1",
+		Password: "ce176b09ef0c03b781314ba461cf986e69f39f151a58d9c2631bcbf5c223e838" +
+			"9f20028a0c89eadf6d7b0a0a0d7c602fdac1347f1a95d8160448be91227ea3b6",
+		Salt: "salt",
+	}
+	ds := &userStore{users: map[string]*models.User{"1": &user}}
+
+	assert.False(t, AuthenticateUser(ctx, ds, &user, "wrong", testPasswordParams))
+	assert.Equal(t, "salt", ds.users["1"].Salt)
+
+	assert.True(t, AuthenticateUser(ctx, ds, &user, "mypassword", testPasswordParams))
+	stored := ds.users["1"]
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+	assert.Empty(t, stored.Salt)
+	assert.False(t, crypto.NeedsRehash(stored.Password, testPasswordParams))
+
+	// The new hash is used from now on.
+	assert.True(t, AuthenticateUser(ctx, ds, stored, "mypassword", testPasswordParams))
+	assert.False(t, AuthenticateUser(ctx, ds, stored, "wrong", testPasswordParams))
+}
+
+func Test_SignInUser(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	ds := &userStore{users: map[string]*models.User{"1": {ID: "1", Username: "chan", Password: hashed}}}
+
+	user, err := SignInUser(ctx, ds, "chan", "mypassword", testPasswordParams)
+	require.NoError(t, err)
+	assert.Equal(t, "1", user.ID)
+
+	// An unknown user and a wrong password are indistinguishable.
+	_, err = SignInUser(ctx, ds, "chan", "wrong", testPasswordParams)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = SignInUser(ctx, ds, "nobody", "wrong", testPasswordParams)
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func Test_SignInUser_DummyPassword(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	ds := &userStore{users: map[string]*models.User{
+		"1": {ID: "1", Username: "chan", Password: hashed},
+		// PBKDF2-SHA256 hash of "mypassword" with the salt "salt".
+		"2": {
+			ID:       "2",
+			Username: "legacy",
+			Password: "ce176b09ef0c03b781314ba461cf986e69f39f151a58d9c2631bcbf5c223e838" +
+				"9f20028a0c89eadf6d7b0a0a0d7c602fdac1347f1a95d8160448be91227ea3b6",
+			Salt: "salt",
+		},
+	}}
+
+	// The dummy hash is computed ahead of the first unknown user.
+	PrepareDummyPassword(testPasswordParams)
+	dummy, ok := dummyHashes.Load(*testPasswordParams)
+	require.True(t, ok)
+
+	verified := 0
+	t.Cleanup(func() {
+		verifyDummyHash = crypto.VerifyPassword
+	})
+	verifyDummyHash = func(hashed, clear, salt string) bool {
+		assert.Equal(t, dummy, hashed)
+		verified++
+		return crypto.VerifyPassword(hashed, clear, salt)
+	}
+	dummyVerifications := func(username string) int {
+		verified = 0
+		_, err := SignInUser(ctx, ds, username, "wrong", testPasswordParams)
+		assert.Equal(t, ErrInvalidCredentials, err)
+		return verified
+	}
+
+	// The password of an unknown user, or of a user with a legacy hash, which is cheaper to verify, is
+	// checked against the dummy hash, so that it takes about as long to reject as a current hash.
+	assert.Equal(t, 1, dummyVerifications("nobody"))
+	assert.Equal(t, 1, dummyVerifications("legacy"))
+	assert.Equal(t, 0, dummyVerifications("chan"))
+}
+
+func Test_ChangePassword(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	ds := &userStore{users: map[string]*models.User{"1": {ID: "1", Password: hashed}}}
+
+	_, err = ChangePassword(ctx, ds, "1", "wrong", "newpassword", testPasswordParams, nil)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = ChangePassword(ctx, ds, "2", "mypassword", "newpassword", testPasswordParams, nil)
+	assert.Equal(t, ErrUserNotFound, err)
+	_, err = ChangePassword(ctx, ds, "1", "mypassword", "short", testPasswordParams, &PasswordPolicy{MinLength: 8})
+	assert.IsType(t, &PolicyError{}, err)
+
+	user, err := ChangePassword(ctx, ds, "1", "mypassword", "newpassword", testPasswordParams, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, ds.users["1"].Password)
+	assert.True(t, AuthenticateUser(ctx, ds, user, "newpassword", testPasswordParams))
+	assert.False(t, AuthenticateUser(ctx, ds, user, "mypassword", testPasswordParams))
+}
+
+func Test_ChangePassword_Conflict(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	ds := newRacingStore(t, 1, &models.User{ID: "1", Username: "chan", Password: hashed})
+
+	// The password is set on the user read again, so that the concurrent change isn't lost.
+	_, err = ChangePassword(ctx, ds, "1", "mypassword", "newpassword", testPasswordParams, nil)
+	require.NoError(t, err)
+	saved, err := ds.GetUser(ctx, "1")
+	require.NoError(t, err)
+	assert.True(t, Authenticate(saved.Password, "newpassword", saved.Salt))
+	assert.Equal(t, "blue", saved.Claims["team"])
+
+	// The update gives up on a user that keeps changing.
+	ds.races = maxUpdateAttempts
+	_, err = ChangePassword(ctx, ds, "1", "newpassword", "mypassword", testPasswordParams, nil)
+	assert.Equal(t, store.ErrConflict, err)
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	sha1HexLen   = 40
+	hashRangeLen = 5
+	hexDigits    = "0123456789ABCDEF"
+
+	// MaxBreachedPasswordsFileSize is the size of the largest file of hashes that is loaded into
+	// memory, ie. about 400,000 hashes with their counts. The full corpus is served from a directory
+	// of range files instead.
+	MaxBreachedPasswordsFileSize = 16 << 20
+)
+
+// BreachedPasswords is a local corpus of the SHA-1 hashes of passwords exposed in data breaches, eg.
+// the Pwned Passwords of Have I Been Pwned. The hashes are grouped by their 5-character prefix, the
+// same way the range API serves them.
+type BreachedPasswords struct {
+	// ranges holds the hashes of a file of hashes.
+	ranges map[string]map[string]struct{}
+	// dir is the directory of the range files, which are read when a password is checked.
+	dir string
+}
+
+// LoadBreachedPasswords loads the corpus at the path. A file lists a hash per line, optionally
+// followed by the count, ie. "<sha1>:<count>", like the downloadable corpus, and is loaded into memory
+// as long as it's no larger than MaxBreachedPasswordsFileSize. A directory holds a range file per
+// prefix, named after the uppercase prefix with an optional ".txt" extension, eg. "5BAA6.txt", that
+// lists the suffixes like the range API, ie. "<suffix>:<count>". Only the range file of the prefix of
+// a password is read when the password is checked. The hashes with a count of 0, ie. the padding of
+// the range API, are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+	if info.Size() > MaxBreachedPasswordsFileSize {
+		return nil, fmt.Errorf("breached passwords file %s is larger than %d bytes, use a directory of range files",
+			path, MaxBreachedPasswordsFileSize)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bp := BreachedPasswords{ranges: map[string]map[string]struct{}{}}
+	err = scanHashes(file, "", func(hash string) bool {
+		bp.add(hash)
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load breached passwords from %s: %w", path, err)
+	}
+
+	return &bp, nil
+}
+
+// scanHashes calls fn with the hashes, prefixed with the prefix, listed by the reader until fn returns
+// true.
+func scanHashes(r io.Reader, prefix string, fn func(hash string) bool) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, count := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			hash, count = line[:i], line[i+1:]
+		}
+		if count != "" {
+			c, err := strconv.Atoi(count)
+			if err != nil {
+				return fmt.Errorf("invalid count on line %d", n)
+			}
+			if c == 0 {
+				continue
+			}
+		}
+
+		hash = prefix + strings.ToUpper(hash)
+		if len(hash) != sha1HexLen || !isHex(hash) {
+			return fmt.Errorf("invalid hash on line %d", n)
+		}
+		if fn(hash) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (bp *BreachedPasswords) add(hash string) {
+	suffixes, ok := bp.ranges[hash[:hashRangeLen]]
+	if !ok {
+		suffixes = map[string]struct{}{}
+		bp.ranges[hash[:hashRangeLen]] = suffixes
+	}
+	suffixes[hash[hashRangeLen:]] = struct{}{}
+}
+
+// openRange opens the range file of the prefix, with or without the extension.
+func (bp *BreachedPasswords) openRange(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(bp.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return os.Open(filepath.Join(bp.dir, prefix))
+	}
+
+	return file, err
+}
+
+// Contains returns true if the password is in the corpus. A range file that is missing means that
+// none of the passwords of the prefix are breached, and one that can't be read is logged and
+// skipped.
+func (bp *BreachedPasswords) Contains(password string) bool {
+	hash := strings.ToUpper(utils.SHA1(password))
+	if bp.dir == "" {
+		_, ok := bp.ranges[hash[:hashRangeLen]][hash[hashRangeLen:]]
+		return ok
+	}
+
+	file, err := bp.openRange(hash[:hashRangeLen])
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		log.Printf("failed to open breached passwords range %s: %v\n", hash[:hashRangeLen], err)
+		return false
+	}
+	defer file.Close()
+
+	found := false
+	err = scanHashes(file, hash[:hashRangeLen], func(h string) bool {
+		found = h == hash
+		return found
+	})
+	if err != nil {
+		log.Printf("failed to read breached passwords from %s: %v\n", file.Name(), err)
+	}
+
+	return found
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(hexDigits, unicode.ToUpper(r)) {
+			return false
+		}
+	}
+
+	return true
+}
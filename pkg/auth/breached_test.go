@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// SHA-1 hashes of "password1", "correct horse" and "secret".
+	password1Hash    = "E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D"
+	correctHorseHash = "2F9E53523B62ABC141A2B4D6019D23CBA835DBD0"
+	secretHash       = "E5E9FA1BA31ECD1AE84F75CAAA474F3A663F05F4"
+)
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "breached")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+func Test_LoadBreachedPasswords_File(t *testing.T) {
+	dir := newTempDir(t)
+	// The hash of "correct horse" is padding.
+	path := writeTempFile(t, dir, "pwned.txt", password1Hash+":2413945\n\n"+
+		correctHorseHash+":0\n"+"e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4\n")
+
+	bp, err := LoadBreachedPasswords(path)
+	require.NoError(t, err)
+	assert.True(t, bp.Contains("password1"))
+	assert.True(t, bp.Contains("secret"))
+	assert.False(t, bp.Contains("correct horse"))
+}
+
+func Test_LoadBreachedPasswords_RangeFiles(t *testing.T) {
+	dir := newTempDir(t)
+	writeTempFile(t, dir, password1Hash[:5]+".txt", password1Hash[5:]+":2413945\r\n")
+	writeTempFile(t, dir, secretHash[:5], secretHash[5:]+":1\r\n"+correctHorseHash[5:]+":0\r\n")
+	writeTempFile(t, dir, "README", "not a range file")
+
+	bp, err := LoadBreachedPasswords(dir)
+	require.NoError(t, err)
+	assert.True(t, bp.Contains("password1"))
+	assert.True(t, bp.Contains("secret"))
+	assert.False(t, bp.Contains("correct horse"))
+
+	// The range files are read when a password is checked.
+	writeTempFile(t, dir, correctHorseHash[:5]+".txt", correctHorseHash[5:]+":3\r\n")
+	assert.True(t, bp.Contains("correct horse"))
+	writeTempFile(t, dir, password1Hash[:5]+".txt", "not-a-hash:1\r\n")
+	assert.False(t, bp.Contains("password1"))
+}
+
+func Test_LoadBreachedPasswords_Invalid(t *testing.T) {
+	dir := newTempDir(t)
+
+	_, err := LoadBreachedPasswords(writeTempFile(t, dir, "hash.txt", "not-a-hash:1\n"))
+	assert.Error(t, err)
+	_, err = LoadBreachedPasswords(writeTempFile(t, dir, "count.txt", password1Hash+":many\n"))
+	assert.Error(t, err)
+	_, err = LoadBreachedPasswords(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+
+	// A file too large to be loaded into memory is rejected.
+	path := writeTempFile(t, dir, "large.txt", password1Hash+":1\n")
+	require.NoError(t, os.Truncate(path, MaxBreachedPasswordsFileSize+1))
+	_, err = LoadBreachedPasswords(path)
+	assert.Error(t, err)
+}
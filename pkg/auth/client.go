@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	clientSecretLen = 40
+)
+
+var (
+	ErrInvalidClient      = errors.New("invalid client credentials")
+	ErrUnauthorizedClient = errors.New("client isn't allowed to use the grant type")
+	ErrInvalidScope       = errors.New("invalid scope")
+)
+
+// RegisterClient saves a new client. If confidential is true, a secret is generated for the client
+// and returned. Only the hash of the secret is kept, so the secret can't be recovered later.
+func RegisterClient(parent context.Context, ds store.DataStore, client *models.Client, confidential bool,
+	params *crypto.PasswordParams) (string, error) {
+	var secret string
+	if confidential {
+		var err error
+		secret, err = utils.GetRandSecret(clientSecretLen)
+		if err != nil {
+			return "", err
+		}
+		if err := SetClientSecret(client, secret, params); err != nil {
+			return "", err
+		}
+	}
+
+	client.CreatedAt = time.Now()
+	if err := ds.SaveClient(parent, client); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// SetClientSecret sets the hash of the secret to the client.
+func SetClientSecret(client *models.Client, secret string, params *crypto.PasswordParams) error {
+	hashed, err := crypto.HashPassword(secret, params)
+	if err != nil {
+		return err
+	}
+
+	client.Secret = hashed
+	client.Salt = ""
+
+	return nil
+}
+
+// AuthenticateClient returns the client if the secret is valid. A public client must not present
+// a secret.
+func AuthenticateClient(parent context.Context, ds store.DataStore, clientID, secret string) (*models.Client, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := ds.GetClient(parent, clientID)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidClient
+	} else if err != nil {
+		return nil, err
+	}
+
+	if client.IsPublic() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+
+		return client, nil
+	}
+	if !Authenticate(client.Secret, secret, client.Salt) {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// GrantScope returns the scope granted to the client for the requested scope, a space-delimited
+// list. All the scopes of the client are granted if none is requested.
+func GrantScope(client *models.Client, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return strings.Join(client.Scopes, " "), nil
+	}
+
+	for _, scope := range scopes {
+		if !client.HasScope(scope) {
+			return "", ErrInvalidScope
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// CreateClientOAuthToken issues an access token for the client credentials grant. No refresh token
+// is issued as the client can always request a new access token with its credentials.
+func CreateClientOAuthToken(parent context.Context, ds store.DataStore, tc *TokenConfig, client *models.Client,
+	scope string, ttl time.Duration) (*oauth2.Token, error) {
+	at, err := NewAccessToken(tc, &AccessTokenParams{
+		ClientID: client.ID,
+		Scope:    scope,
+		TTL:      ttl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.SaveAccessToken(parent, at); err != nil {
+		return nil, err
+	}
+
+	otoken := oauth2.Token{
+		AccessToken: at.Value,
+		TokenType:   tokenType,
+		Expiry:      at.ExpireAt,
+	}
+
+	return &otoken, nil
+}
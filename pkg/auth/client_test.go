@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+// clientStore is a data store that only keeps clients.
+type clientStore struct {
+	store.DataStore
+
+	clients map[string]*models.Client
+}
+
+func (cs *clientStore) GetClient(_ context.Context, id string) (*models.Client, error) {
+	client, ok := cs.clients[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+
+	return client, nil
+}
+
+func (cs *clientStore) SaveClient(_ context.Context, client *models.Client) error {
+	cs.clients[client.ID] = client
+
+	return nil
+}
+
+func Test_AuthenticateClient(t *testing.T) {
+	ctx := context.Background()
+	ds := &clientStore{clients: make(map[string]*models.Client)}
+
+	secret, err := RegisterClient(ctx, ds, &models.Client{ID: "service"}, true, testPasswordParams)
+	require.NoError(t, err)
+	assert.NotEmpty(t, secret)
+	assert.NotEqual(t, secret, ds.clients["service"].Secret)
+	_, err = RegisterClient(ctx, ds, &models.Client{ID: "spa"}, false, testPasswordParams)
+	require.NoError(t, err)
+
+	client, err := AuthenticateClient(ctx, ds, "service", secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "service", client.ID)
+	_, err = AuthenticateClient(ctx, ds, "service", "wrong-secret")
+	assert.Equal(t, ErrInvalidClient, err)
+	_, err = AuthenticateClient(ctx, ds, "unknown", secret)
+	assert.Equal(t, ErrInvalidClient, err)
+
+	// A public client has no secret.
+	client, err = AuthenticateClient(ctx, ds, "spa", "")
+	assert.NoError(t, err)
+	assert.True(t, client.IsPublic())
+	_, err = AuthenticateClient(ctx, ds, "spa", secret)
+	assert.Equal(t, ErrInvalidClient, err)
+}
+
+func Test_GrantScope(t *testing.T) {
+	client := models.Client{Scopes: []string{"users:read", "users:write"}}
+
+	scope, err := GrantScope(&client, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "users:read users:write", scope)
+
+	scope, err = GrantScope(&client, "users:read")
+	assert.NoError(t, err)
+	assert.Equal(t, "users:read", scope)
+
+	_, err = GrantScope(&client, "users:read admin")
+	assert.Equal(t, ErrInvalidScope, err)
+}
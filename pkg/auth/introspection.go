@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+
+	// ScopeIntrospect is the scope of the clients that are allowed to introspect tokens.
+	ScopeIntrospect = "introspect"
+)
+
+// TokenInfo describes an active token.
+type TokenInfo struct {
+	// TokenType is either TokenTypeHintAccessToken or TokenTypeHintRefreshToken.
+	TokenType string
+	ID        string
+	UserID    string
+	ClientID  string
+	Scope     string
+	IssuedAt  time.Time
+	ExpireAt  time.Time
+}
+
+type lookupFunc func(parent context.Context, ds store.DataStore, tc *TokenConfig, token string) (*TokenInfo, error)
+
+// IntrospectToken returns the information of an active access or refresh token, or nil if the token
+// isn't active ie. it's invalid, expired or revoked. The hint names the token type to try first.
+func IntrospectToken(parent context.Context, ds store.DataStore, tc *TokenConfig, token, hint string) (*TokenInfo, error) {
+	lookups := []lookupFunc{lookupAccessToken, lookupRefreshToken}
+	if hint == TokenTypeHintRefreshToken {
+		lookups = []lookupFunc{lookupRefreshToken, lookupAccessToken}
+	}
+
+	for _, lookup := range lookups {
+		info, err := lookup(parent, ds, tc, token)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func lookupAccessToken(parent context.Context, ds store.DataStore, tc *TokenConfig, token string) (*TokenInfo, error) {
+	claims, err := ParseJWT(token, tc)
+	if err != nil {
+		return nil, nil
+	}
+
+	// The access token is revoked if it's no longer in the data store.
+	_, err = ds.GetAccessToken(parent, claims.ID)
+	if err == store.ErrorNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := TokenInfo{
+		TokenType: TokenTypeHintAccessToken,
+		ID:        claims.ID,
+		UserID:    claims.UserID,
+		ClientID:  claims.ClientID,
+		Scope:     claims.Scope,
+		IssuedAt:  claims.IssuedAt.Time(),
+		ExpireAt:  claims.Expiry.Time(),
+	}
+
+	return &info, nil
+}
+
+func lookupRefreshToken(parent context.Context, ds store.DataStore, _ *TokenConfig, token string) (*TokenInfo, error) {
+	rt, err := ds.GetRefreshToken(parent, token)
+	if err == store.ErrorNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if rt.Used || time.Now().After(rt.ExpireAt) {
+		return nil, nil
+	}
+
+	info := TokenInfo{
+		TokenType: TokenTypeHintRefreshToken,
+		ID:        rt.ID,
+		UserID:    rt.UserID,
+		ClientID:  rt.ClientID,
+		Scope:     rt.Scope,
+		IssuedAt:  rt.IssuedAt,
+		ExpireAt:  rt.ExpireAt,
+	}
+
+	return &info, nil
+}
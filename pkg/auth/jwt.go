@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/square/go-jose/v3"
 	"github.com/square/go-jose/v3/jwt"
+
+	"github.com/cybersamx/authx/pkg/models"
 )
 
 // Types of claims
@@ -13,67 +16,121 @@ import (
 // JWTClaims represents the claims of a JWT.
 type JWTClaims struct {
 	jwt.Claims
-	UserID string `json:"uid,omitempty"`
+	UserID   string `json:"uid,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Custom are the claims added by the ClaimsFunc of the token config.
+	Custom map[string]interface{} `json:"-"`
+}
+
+// ClaimsFunc returns the custom claims of the user to add to the access tokens, eg. roles.
+type ClaimsFunc func(parent context.Context, user *models.User) (map[string]interface{}, error)
+
+// TokenConfig holds the settings of the JWTs issued and accepted by the service.
+type TokenConfig struct {
+	Keys   KeySet
+	Issuer string
+	// Audience is set as the aud claim of the JWTs, and ParseJWT rejects the JWTs without it.
+	Audience []string
+	// ClaimsFunc is optional.
+	ClaimsFunc ClaimsFunc
 }
 
 const (
-	issuer = "https://github.com/cybersamx/authx"
+	headerKeyID = "kid"
 )
 
 var (
 	ErrInvalidJWT = errors.New("invalid jwt")
 	ErrExpiredJWT = errors.New("expired jwt")
+
+	// The claims that can't be overridden by custom claims.
+	reservedClaims = map[string]bool{
+		"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+		"uid": true, "client_id": true, "scope": true,
+	}
 )
 
-func NewJWT(id, uid, secrets string, issueAt, expireAt time.Time) (string, error) {
-	claims := JWTClaims{
-		UserID: uid,
-		Claims: jwt.Claims{
-			Issuer:   issuer,
-			Expiry:   jwt.NewNumericDate(expireAt),
-			IssuedAt: jwt.NewNumericDate(issueAt),
-			ID:       id,
-		},
+// NewJWT returns a JWT with the claims signed by the current signing key of the key set. The issuer
+// and audience are taken from the token config. The key id is set in the kid header so that the
+// JWT can be verified using the published keys.
+func NewJWT(claims *JWTClaims, tc *TokenConfig) (string, error) {
+	claims.Issuer = tc.Issuer
+	claims.Audience = tc.Audience
+
+	key, err := tc.Keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	custom := make(map[string]interface{}, len(claims.Custom))
+	for name, val := range claims.Custom {
+		if !reservedClaims[name] {
+			custom[name] = val
+		}
 	}
 
-	// For now, use symmetrical signing.
+	return signJWT(key, claims, custom)
+}
+
+func signJWT(key *jose.JSONWebKey, claims ...interface{}) (string, error) {
 	signKey := jose.SigningKey{
-		Algorithm: jose.HS256,
-		Key:       []byte(secrets),
+		Algorithm: jose.SignatureAlgorithm(key.Algorithm),
+		Key:       key.Key,
 	}
 	opts := jose.SignerOptions{}
 	opts.WithType("JWT")
+	opts.WithHeader(headerKeyID, key.KeyID)
 	signer, err := jose.NewSigner(signKey, &opts)
 	if err != nil {
 		return "", err
 	}
 
-	signedJWT, err := jwt.
-		Signed(signer).
-		Claims(claims).
-		CompactSerialize()
+	builder := jwt.Signed(signer)
+	for _, c := range claims {
+		builder = builder.Claims(c)
+	}
 
-	return signedJWT, err
+	return builder.CompactSerialize()
 }
 
-// ParseJWT parses the token string and validates the signature.
-func ParseJWT(jwtStr, accessSecret string) (*JWTClaims, error) {
+// ParseJWT parses the token string and validates the signature against the key named in the kid
+// header, as well as the issuer and audience. The claims of an expired token are returned with
+// ErrExpiredJWT, as they have been verified.
+func ParseJWT(jwtStr string, tc *TokenConfig) (*JWTClaims, error) {
 	signedJWT, err := jwt.ParseSigned(jwtStr)
-	if err != nil {
+	if err != nil || len(signedJWT.Headers) != 1 {
 		return nil, ErrInvalidJWT
 	}
 
+	// The algorithm in the header must be the one the key is meant for.
+	header := signedJWT.Headers[0]
+	key, err := tc.Keys.VerificationKey(header.KeyID)
+	if err != nil || header.Algorithm != key.Algorithm {
+		return nil, ErrInvalidJWT
+	}
+	verificationKey := key.Key
+	if public := key.Public(); public.Valid() {
+		verificationKey = public.Key
+	}
+
 	claims := new(JWTClaims)
-	if err = signedJWT.Claims([]byte(accessSecret), claims); err != nil {
+	if err = signedJWT.Claims(verificationKey, claims, &claims.Custom); err != nil {
 		return nil, ErrInvalidJWT
 	}
+	for name := range claims.Custom {
+		if reservedClaims[name] {
+			delete(claims.Custom, name)
+		}
+	}
 
 	err = claims.Validate(jwt.Expected{
-		Issuer: issuer,
-		Time:   time.Now(),
+		Issuer:   tc.Issuer,
+		Audience: tc.Audience,
+		Time:     time.Now(),
 	})
 	if err == jwt.ErrExpired {
-		return nil, ErrExpiredJWT
+		return claims, ErrExpiredJWT
 	} else if err != nil {
 		return nil, ErrInvalidJWT
 	}
@@ -11,6 +11,19 @@ import (
 	"github.com/cybersamx/authx/pkg/utils"
 )
 
+func newTestJWT(id, uid string, keys KeySet, issueAt, expireAt time.Time) (string, error) {
+	claims := JWTClaims{
+		UserID: uid,
+		Claims: jwt.Claims{
+			Expiry:   jwt.NewNumericDate(expireAt),
+			IssuedAt: jwt.NewNumericDate(issueAt),
+			ID:       id,
+		},
+	}
+
+	return NewJWT(&claims, &TokenConfig{Keys: keys})
+}
+
 func Test_NewAndParseJWT(t *testing.T) {
 	var tcases = []struct {
 		description string
@@ -62,7 +75,8 @@ func Test_NewAndParseJWT(t *testing.T) {
 	for _, tcase := range tcases {
 		val := tcase
 		t.Run(val.description, func(t *testing.T) {
-			jwtToken, err := NewJWT(val.id, val.userID, val.secrets, val.issueAt, val.expireAt)
+			keys := NewSymmetricKeySet(val.secrets)
+			jwtToken, err := newTestJWT(val.id, val.userID, keys, val.issueAt, val.expireAt)
 			assert.NoError(t, err)
 			assert.NotEmpty(t, jwtToken)
 
@@ -71,7 +85,7 @@ func Test_NewAndParseJWT(t *testing.T) {
 				jwtToken = fmt.Sprintf("%s%s", jwtToken, tamper)
 			}
 
-			claims, err := ParseJWT(jwtToken, val.secrets)
+			claims, err := ParseJWT(jwtToken, &TokenConfig{Keys: keys})
 			if val.expError != nil {
 				assert.Equal(t, val.expError, err)
 			} else {
@@ -85,3 +99,63 @@ func Test_NewAndParseJWT(t *testing.T) {
 		})
 	}
 }
+
+func Test_ParseJWT_IssuerAndAudience(t *testing.T) {
+	keys := NewSymmetricKeySet("MySecrets")
+	tc := TokenConfig{
+		Keys:     keys,
+		Issuer:   "https://auth.example.com",
+		Audience: []string{"https://api.example.com"},
+	}
+	claims := JWTClaims{
+		Claims: jwt.Claims{
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	jwtToken, err := NewJWT(&claims, &tc)
+	assert.NoError(t, err)
+
+	parsed, err := ParseJWT(jwtToken, &tc)
+	assert.NoError(t, err)
+	assert.Equal(t, tc.Issuer, parsed.Issuer)
+	assert.Equal(t, jwt.Audience(tc.Audience), parsed.Audience)
+
+	// The JWT is rejected by the services expecting another issuer or audience.
+	otherIssuer := tc
+	otherIssuer.Issuer = "https://other.example.com"
+	_, err = ParseJWT(jwtToken, &otherIssuer)
+	assert.Equal(t, ErrInvalidJWT, err)
+	otherAudience := tc
+	otherAudience.Audience = []string{"https://other.example.com"}
+	_, err = ParseJWT(jwtToken, &otherAudience)
+	assert.Equal(t, ErrInvalidJWT, err)
+}
+
+func Test_NewJWT_CustomClaims(t *testing.T) {
+	tc := TokenConfig{Keys: NewSymmetricKeySet("MySecrets")}
+	claims := JWTClaims{
+		UserID: "MyUserID",
+		Scope:  "profile",
+		Custom: map[string]interface{}{
+			"roles":  []string{"admin"},
+			"tenant": "acme",
+			"uid":    "SomeoneElse",
+			"scope":  "admin",
+		},
+		Claims: jwt.Claims{
+			Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	jwtToken, err := NewJWT(&claims, &tc)
+	assert.NoError(t, err)
+
+	parsed, err := ParseJWT(jwtToken, &tc)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", parsed.Custom["tenant"])
+	assert.Equal(t, []interface{}{"admin"}, parsed.Custom["roles"])
+
+	// Custom claims can't override the reserved claims.
+	assert.Equal(t, "MyUserID", parsed.UserID)
+	assert.Equal(t, "profile", parsed.Scope)
+	assert.NotContains(t, parsed.Custom, "uid")
+}
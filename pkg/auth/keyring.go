@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/square/go-jose/v3"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	rsaKeyBits         = 2048
+	symmetricKeyLen    = 32
+	pemTypePrivateKey  = "PRIVATE KEY"
+	pemTypeSecretKey   = "SECRET KEY"
+	keyringTimeout     = 15 * time.Second
+	keyringReloadDelay = 10 * time.Second
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key in the keyring")
+)
+
+type KeyringOptions struct {
+	// Algorithm of the keys generated by the keyring.
+	Algorithm string
+	// RotationPeriod is the age at which the signing key is replaced, 0 disables the rotation.
+	RotationPeriod time.Duration
+	// RetireAfter is how long a key is kept for verification after it's replaced. It should be no
+	// shorter than the longest lifetime of the JWTs the key signs.
+	RetireAfter time.Duration
+	// PublishDelay is how long a new key is published before it replaces the signing key. It should
+	// be no shorter than the time the clients cache the public keys for.
+	PublishDelay time.Duration
+}
+
+// keyringKey is a key of the keyring and the time it starts signing.
+type keyringKey struct {
+	jwk        *jose.JSONWebKey
+	activateAt time.Time
+}
+
+// Keyring is a KeySet that keeps its keys in the data store so that they are shared by all
+// replicas and survive restarts. The last activated key signs new JWTs, a new key is published
+// before it's activated, and a replaced key still verifies JWTs until it's retired.
+type Keyring struct {
+	ds   store.DataStore
+	opts KeyringOptions
+
+	mu       sync.RWMutex
+	keys     []keyringKey // Sorted by activation.
+	loadedAt time.Time
+}
+
+func NewKeyring(ds store.DataStore, opts KeyringOptions) *Keyring {
+	return &Keyring{
+		ds:   ds,
+		opts: opts,
+	}
+}
+
+// GenerateSigningKey returns a new random key for the algorithm.
+func GenerateSigningKey(alg string, now time.Time) (*models.SigningKey, error) {
+	var block pem.Block
+	var kid string
+
+	if jose.SignatureAlgorithm(alg) == jose.HS256 {
+		secret := make([]byte, symmetricKeyLen)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		block = pem.Block{Type: pemTypeSecretKey, Bytes: secret}
+		kid = uuid.New().String()
+	} else {
+		privateKey, err := generatePrivateKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk, err := NewSigningKey(alg, privateKey, "")
+		if err != nil {
+			return nil, err
+		}
+		block = pem.Block{Type: pemTypePrivateKey, Bytes: der}
+		kid = jwk.KeyID
+	}
+
+	key := models.SigningKey{
+		ID:         kid,
+		Algorithm:  alg,
+		PrivateKey: string(pem.EncodeToMemory(&block)),
+		CreatedAt:  now,
+	}
+
+	return &key, nil
+}
+
+func generatePrivateKey(alg string) (crypto.PrivateKey, error) {
+	switch jose.SignatureAlgorithm(alg) {
+	case jose.RS256:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jose.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jose.EdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// decodeSigningKey converts a stored signing key to a JWK.
+func decodeSigningKey(key *models.SigningKey) (*jose.JSONWebKey, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	if block.Type == pemTypeSecretKey {
+		jwk := jose.JSONWebKey{
+			Key:       block.Bytes,
+			KeyID:     key.ID,
+			Algorithm: key.Algorithm,
+			Use:       keyUseSignature,
+		}
+
+		return &jwk, nil
+	}
+
+	privateKey, err := ParsePrivateKeyPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(key.Algorithm, privateKey, key.ID)
+}
+
+// sortKeys sorts the keys by activation.
+func sortKeys(keys []*models.SigningKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActiveFrom().Before(keys[j].ActiveFrom())
+	})
+}
+
+func (kr *Keyring) set(keys []*models.SigningKey, now time.Time) error {
+	sortKeys(keys)
+
+	kks := make([]keyringKey, 0, len(keys))
+	for _, key := range keys {
+		jwk, err := decodeSigningKey(key)
+		if err != nil {
+			return err
+		}
+		kks = append(kks, keyringKey{jwk: jwk, activateAt: key.ActiveFrom()})
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = kks
+	kr.loadedAt = now
+
+	return nil
+}
+
+// getKeys returns the keys in the data store sorted by activation.
+func (kr *Keyring) getKeys(parent context.Context) ([]*models.SigningKey, error) {
+	keys, err := kr.ds.GetSigningKeys(parent)
+	if err != nil {
+		return nil, err
+	}
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+// Load reads the keys from the data store.
+func (kr *Keyring) Load(parent context.Context) error {
+	keys, err := kr.ds.GetSigningKeys(parent)
+	if err != nil {
+		return err
+	}
+
+	return kr.set(keys, time.Now())
+}
+
+// addKey saves a new key that succeeds the last of the keys, which are sorted by activation. The new
+// key is activated after PublishDelay, unless it's the first key. It returns store.ErrDuplicate if
+// the last key already has a successor, eg. added by another replica.
+func (kr *Keyring) addKey(parent context.Context, keys []*models.SigningKey, now time.Time) (*models.SigningKey, error) {
+	key, err := GenerateSigningKey(kr.opts.Algorithm, now)
+	if err != nil {
+		return nil, err
+	}
+	key.ActivateAt = now
+	if len(keys) > 0 {
+		key.ActivateAt = now.Add(kr.opts.PublishDelay)
+		key.PredecessorID = keys[len(keys)-1].ID
+	}
+	if err := kr.ds.SaveSigningKey(parent, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Rotate adds a new key to the data store, which becomes the signing key once it has been published
+// for PublishDelay.
+func (kr *Keyring) Rotate(parent context.Context) (*models.SigningKey, error) {
+	keys, err := kr.getKeys(parent)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kr.addKey(parent, keys, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return key, kr.Load(parent)
+}
+
+// rotationDue returns true if there's no key, or if the last key has been active for so long that
+// its successor must be published to replace it at RotationPeriod.
+func (kr *Keyring) rotationDue(keys []*models.SigningKey, now time.Time) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	if kr.opts.RotationPeriod <= 0 {
+		return false
+	}
+
+	lead := kr.opts.RotationPeriod - kr.opts.PublishDelay
+	if lead < 0 {
+		lead = 0
+	}
+
+	return !now.Before(keys[len(keys)-1].ActiveFrom().Add(lead))
+}
+
+// Maintain publishes the successor of the signing key if there's none or it's due for rotation, and
+// retires the keys that were replaced more than RetireAfter ago. Only one of the replicas that
+// maintain the keyring at once adds the successor.
+func (kr *Keyring) Maintain(parent context.Context) error {
+	keys, err := kr.getKeys(parent)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if kr.rotationDue(keys, now) {
+		key, aerr := kr.addKey(parent, keys, now)
+		switch aerr {
+		case nil:
+			log.Printf("published signing key %s, which signs from %s\n", key.ID, key.ActivateAt.Format(time.RFC3339))
+			keys = append(keys, key)
+		case store.ErrDuplicate:
+			// Another replica has just added the key.
+			if keys, err = kr.getKeys(parent); err != nil {
+				return err
+			}
+		default:
+			return aerr
+		}
+	}
+
+	// A key is replaced when its successor is activated.
+	var retained []*models.SigningKey
+	for i, key := range keys {
+		if i < len(keys)-1 && now.Sub(keys[i+1].ActiveFrom()) > kr.opts.RetireAfter {
+			if err := kr.ds.RemoveSigningKey(parent, key.ID); err != nil {
+				return err
+			}
+			log.Printf("retired signing key %s\n", key.ID)
+			continue
+		}
+		retained = append(retained, key)
+	}
+
+	return kr.set(retained, now)
+}
+
+// Run maintains the keyring at every interval until the context is done.
+func (kr *Keyring) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mctx, cancel := context.WithTimeout(ctx, keyringTimeout)
+			if err := kr.Maintain(mctx); err != nil {
+				log.Printf("failed to maintain the keyring: %v\n", err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (kr *Keyring) SigningKey() (*jose.JSONWebKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	// The keys that are published but not activated yet don't sign.
+	now := time.Now()
+	for i := len(kr.keys) - 1; i >= 0; i-- {
+		if !kr.keys[i].activateAt.After(now) {
+			return kr.keys[i].jwk, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+func (kr *Keyring) findKey(kid string) (*jose.JSONWebKey, time.Time) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.jwk.KeyID == kid {
+			return key.jwk, kr.loadedAt
+		}
+	}
+
+	return nil, kr.loadedAt
+}
+
+func (kr *Keyring) VerificationKey(kid string) (*jose.JSONWebKey, error) {
+	key, loadedAt := kr.findKey(kid)
+	if key != nil {
+		return key, nil
+	}
+
+	// The key may have been created by another replica, reload the keys unless they were just loaded.
+	if time.Since(loadedAt) < keyringReloadDelay {
+		return nil, ErrUnknownKeyID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+	if err := kr.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	key, _ = kr.findKey(kid)
+	if key == nil {
+		return nil, ErrUnknownKeyID
+	}
+
+	return key, nil
+}
+
+func (kr *Keyring) PublicKeys() *jose.JSONWebKeySet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	jwks := make([]*jose.JSONWebKey, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		jwks = append(jwks, key.jwk)
+	}
+
+	return newPublicKeySet(jwks...)
+}
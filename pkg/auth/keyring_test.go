@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+// keyStore is a data store that only keeps signing keys.
+type keyStore struct {
+	store.DataStore
+
+	mu   sync.Mutex
+	keys map[string]*models.SigningKey
+}
+
+func newKeyStore() *keyStore {
+	return &keyStore{keys: make(map[string]*models.SigningKey)}
+}
+
+func (ks *keyStore) GetSigningKeys(_ context.Context) ([]*models.SigningKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var keys []*models.SigningKey
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (ks *keyStore) SaveSigningKey(_ context.Context, key *models.SigningKey) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, other := range ks.keys {
+		if other.ID == key.ID || other.PredecessorID == key.PredecessorID {
+			return store.ErrDuplicate
+		}
+	}
+	ks.keys[key.ID] = key
+
+	return nil
+}
+
+func (ks *keyStore) RemoveSigningKey(_ context.Context, id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.keys, id)
+
+	return nil
+}
+
+func Test_Keyring_Rotation(t *testing.T) {
+	for _, alg := range []string{"HS256", "RS256", "ES256", "EdDSA"} {
+		alg := alg
+		t.Run(alg, func(t *testing.T) {
+			ctx := context.Background()
+			ds := newKeyStore()
+			opts := KeyringOptions{
+				Algorithm:   alg,
+				RetireAfter: time.Hour,
+			}
+			keyring := NewKeyring(ds, opts)
+
+			// The first key is created on demand.
+			require.NoError(t, keyring.Maintain(ctx))
+			assert.Len(t, ds.keys, 1)
+			before, err := newTestJWT("MyUniqueID", "MyUserID", keyring, time.Now(), time.Now().Add(time.Hour))
+			require.NoError(t, err)
+
+			// Tokens signed before a rotation are still valid, including on other replicas.
+			_, err = keyring.Rotate(ctx)
+			require.NoError(t, err)
+			after, err := newTestJWT("MyUniqueID", "MyUserID", keyring, time.Now(), time.Now().Add(time.Hour))
+			require.NoError(t, err)
+
+			replica := NewKeyring(ds, opts)
+			require.NoError(t, replica.Load(ctx))
+			for _, jwtToken := range []string{before, after} {
+				_, err = ParseJWT(jwtToken, &TokenConfig{Keys: keyring})
+				assert.NoError(t, err)
+				_, err = ParseJWT(jwtToken, &TokenConfig{Keys: replica})
+				assert.NoError(t, err)
+			}
+
+			// The replaced key isn't retired until RetireAfter has passed.
+			require.NoError(t, keyring.Maintain(ctx))
+			assert.Len(t, ds.keys, 2)
+			if alg != "HS256" {
+				assert.Len(t, keyring.PublicKeys().Keys, 2)
+			}
+		})
+	}
+}
+
+func Test_Keyring_Retirement(t *testing.T) {
+	ctx := context.Background()
+	ds := newKeyStore()
+	keyring := NewKeyring(ds, KeyringOptions{Algorithm: "ES256"})
+
+	require.NoError(t, keyring.Maintain(ctx))
+	before, err := newTestJWT("MyUniqueID", "MyUserID", keyring, time.Now(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = keyring.Rotate(ctx)
+	require.NoError(t, err)
+
+	// With no retirement delay, the replaced key is removed right away.
+	time.Sleep(time.Millisecond)
+	require.NoError(t, keyring.Maintain(ctx))
+	assert.Len(t, ds.keys, 1)
+	assert.Len(t, keyring.PublicKeys().Keys, 1)
+	_, err = ParseJWT(before, &TokenConfig{Keys: keyring})
+	assert.Equal(t, ErrInvalidJWT, err)
+}
+
+func Test_Keyring_ScheduledRotation(t *testing.T) {
+	ctx := context.Background()
+	ds := newKeyStore()
+	keyring := NewKeyring(ds, KeyringOptions{
+		Algorithm:      "HS256",
+		RotationPeriod: time.Millisecond,
+		RetireAfter:    time.Hour,
+	})
+
+	require.NoError(t, keyring.Maintain(ctx))
+	first, err := keyring.SigningKey()
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, keyring.Maintain(ctx))
+	second, err := keyring.SigningKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, first.KeyID, second.KeyID)
+	assert.Len(t, ds.keys, 2)
+}
+
+func Test_Keyring_PublishDelay(t *testing.T) {
+	ctx := context.Background()
+	ds := newKeyStore()
+	keyring := NewKeyring(ds, KeyringOptions{
+		Algorithm:    "ES256",
+		RetireAfter:  time.Hour,
+		PublishDelay: 50 * time.Millisecond,
+	})
+
+	// The first key signs right away.
+	require.NoError(t, keyring.Maintain(ctx))
+	first, err := keyring.SigningKey()
+	require.NoError(t, err)
+
+	// The new key is published, but doesn't sign until the delay has passed.
+	key, err := keyring.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first.KeyID, key.PredecessorID)
+	assert.Len(t, keyring.PublicKeys().Keys, 2)
+	current, err := keyring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, first.KeyID, current.KeyID)
+
+	time.Sleep(60 * time.Millisecond)
+	current, err = keyring.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, current.KeyID)
+}
+
+func Test_Keyring_ConcurrentRotation(t *testing.T) {
+	ctx := context.Background()
+	ds := newKeyStore()
+	opts := KeyringOptions{
+		Algorithm:      "HS256",
+		RotationPeriod: 100 * time.Millisecond,
+		RetireAfter:    time.Hour,
+	}
+	require.NoError(t, NewKeyring(ds, opts).Maintain(ctx))
+	time.Sleep(110 * time.Millisecond)
+
+	// Only one of the replicas rotating at once adds a key, and they all sign with it.
+	replicas := make([]*Keyring, 5)
+	var wg sync.WaitGroup
+	for i := range replicas {
+		replicas[i] = NewKeyring(ds, opts)
+		wg.Add(1)
+		go func(kr *Keyring) {
+			defer wg.Done()
+			assert.NoError(t, kr.Maintain(ctx))
+		}(replicas[i])
+	}
+	wg.Wait()
+
+	assert.Len(t, ds.keys, 2)
+	want, err := replicas[0].SigningKey()
+	require.NoError(t, err)
+	for _, kr := range replicas {
+		key, err := kr.SigningKey()
+		require.NoError(t, err)
+		assert.Equal(t, want.KeyID, key.KeyID)
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/square/go-jose/v3"
+)
+
+const (
+	keyUseSignature = "sig"
+	minRSAKeyBits   = 2048
+	symmetricKIDLen = 16
+)
+
+var (
+	ErrUnknownKeyID          = errors.New("unknown signing key id")
+	ErrUnsupportedAlgorithm  = errors.New("unsupported signing algorithm")
+	ErrMismatchedKeyType     = errors.New("signing key doesn't match the signing algorithm")
+	ErrInvalidPEM            = errors.New("can't decode pem block")
+	ErrMissingSigningKeyFile = errors.New("missing signing key file for asymmetric signing algorithm")
+	ErrSymmetricIDToken      = errors.New("id tokens can't be signed with a symmetric key")
+)
+
+// KeySet provides the keys to sign and verify JWTs.
+type KeySet interface {
+	// SigningKey returns the key to sign new JWTs.
+	SigningKey() (*jose.JSONWebKey, error)
+	// VerificationKey returns the key to verify the JWT signed by the key with the id.
+	VerificationKey(kid string) (*jose.JSONWebKey, error)
+	// PublicKeys returns the public keys for verifying JWTs. Symmetric keys are never included.
+	PublicKeys() *jose.JSONWebKeySet
+}
+
+// StaticKeySet is a KeySet containing a single key that is used for both signing and verification.
+type StaticKeySet struct {
+	key *jose.JSONWebKey
+}
+
+// NewSymmetricKeySet returns a key set that signs JWTs with HS256 using the shared secret.
+func NewSymmetricKeySet(secret string) *StaticKeySet {
+	sum := sha256.Sum256([]byte(secret))
+
+	return &StaticKeySet{
+		key: &jose.JSONWebKey{
+			Key:       []byte(secret),
+			KeyID:     hex.EncodeToString(sum[:])[:symmetricKIDLen],
+			Algorithm: string(jose.HS256),
+			Use:       keyUseSignature,
+		},
+	}
+}
+
+// NewAsymmetricKeySet returns a key set that signs JWTs with the private key using the algorithm.
+// The key id defaults to the RFC 7638 thumbprint of the key if kid is empty.
+func NewAsymmetricKeySet(alg string, privateKey crypto.PrivateKey, kid string) (*StaticKeySet, error) {
+	key, err := NewSigningKey(alg, privateKey, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticKeySet{key: key}, nil
+}
+
+// IsAsymmetric returns true if the signing algorithm signs with a private key, so that anyone can
+// verify the JWTs with the public key.
+func IsAsymmetric(alg string) bool {
+	return jose.SignatureAlgorithm(alg) != jose.HS256
+}
+
+// LoadKeySet returns the key set for the signing algorithm. HS256 signs with the secret, while
+// asymmetric algorithms sign with the private key read from a PEM file.
+func LoadKeySet(alg, keyFile, kid, secret string) (*StaticKeySet, error) {
+	if jose.SignatureAlgorithm(alg) == jose.HS256 {
+		return NewSymmetricKeySet(secret), nil
+	}
+
+	if keyFile == "" {
+		return nil, ErrMissingSigningKeyFile
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read signing key file %s: %v", keyFile, err)
+	}
+	privateKey, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAsymmetricKeySet(alg, privateKey, kid)
+}
+
+func (sks *StaticKeySet) SigningKey() (*jose.JSONWebKey, error) {
+	return sks.key, nil
+}
+
+func (sks *StaticKeySet) VerificationKey(kid string) (*jose.JSONWebKey, error) {
+	// Tokens issued before key ids were introduced don't have a kid.
+	if kid != "" && kid != sks.key.KeyID {
+		return nil, ErrUnknownKeyID
+	}
+
+	return sks.key, nil
+}
+
+func (sks *StaticKeySet) PublicKeys() *jose.JSONWebKeySet {
+	return newPublicKeySet(sks.key)
+}
+
+// NewSigningKey returns a JWK wrapping the private key after checking that it can be used with
+// the signing algorithm.
+func NewSigningKey(alg string, privateKey crypto.PrivateKey, kid string) (*jose.JSONWebKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if jose.SignatureAlgorithm(alg) != jose.RS256 {
+			return nil, ErrMismatchedKeyType
+		}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("rsa key must be at least %d bits", minRSAKeyBits)
+		}
+	case *ecdsa.PrivateKey:
+		if jose.SignatureAlgorithm(alg) != jose.ES256 || key.Curve != elliptic.P256() {
+			return nil, ErrMismatchedKeyType
+		}
+	case ed25519.PrivateKey:
+		if jose.SignatureAlgorithm(alg) != jose.EdDSA {
+			return nil, ErrMismatchedKeyType
+		}
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	jwk := jose.JSONWebKey{
+		Key:       privateKey,
+		KeyID:     kid,
+		Algorithm: alg,
+		Use:       keyUseSignature,
+	}
+	if jwk.KeyID == "" {
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	return &jwk, nil
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key in PKCS #8, PKCS #1 or
+// SEC 1 form.
+func ParsePrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("can't parse private key of pem type %s", block.Type)
+}
+
+// newPublicKeySet returns the public parts of the asymmetric keys.
+func newPublicKeySet(keys ...*jose.JSONWebKey) *jose.JSONWebKeySet {
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{},
+	}
+	for _, key := range keys {
+		public := key.Public()
+		if !public.Valid() {
+			// Symmetric key.
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, public)
+	}
+
+	return &jwks
+}
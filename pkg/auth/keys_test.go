@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPrivateKey(t *testing.T, alg string) crypto.PrivateKey {
+	switch alg {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		return key
+	case "ES256":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		return key
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		return key
+	}
+
+	t.Fatalf("unknown algorithm %s", alg)
+	return nil
+}
+
+func Test_AsymmetricKeySet(t *testing.T) {
+	issueAt := time.Now()
+	expireAt := issueAt.Add(time.Hour)
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		alg := alg
+		t.Run(alg, func(t *testing.T) {
+			keys, err := NewAsymmetricKeySet(alg, newTestPrivateKey(t, alg), "")
+			require.NoError(t, err)
+
+			jwtToken, err := newTestJWT("MyUniqueID", "MyUserID", keys, issueAt, expireAt)
+			require.NoError(t, err)
+
+			// The token names the signing key in the header.
+			signedJWT, err := jwt.ParseSigned(jwtToken)
+			require.NoError(t, err)
+			signingKey, err := keys.SigningKey()
+			require.NoError(t, err)
+			assert.NotEmpty(t, signedJWT.Headers[0].KeyID)
+			assert.Equal(t, signingKey.KeyID, signedJWT.Headers[0].KeyID)
+			assert.Equal(t, alg, signedJWT.Headers[0].Algorithm)
+
+			claims, err := ParseJWT(jwtToken, &TokenConfig{Keys: keys})
+			assert.NoError(t, err)
+			assert.Equal(t, "MyUserID", claims.UserID)
+
+			// Only the public key is published.
+			jwks := keys.PublicKeys()
+			require.Len(t, jwks.Keys, 1)
+			assert.True(t, jwks.Keys[0].IsPublic())
+			assert.Equal(t, signingKey.KeyID, jwks.Keys[0].KeyID)
+
+			// A token can't be verified with another key.
+			otherKeys, err := NewAsymmetricKeySet(alg, newTestPrivateKey(t, alg), signingKey.KeyID)
+			require.NoError(t, err)
+			_, err = ParseJWT(jwtToken, &TokenConfig{Keys: otherKeys})
+			assert.Equal(t, ErrInvalidJWT, err)
+		})
+	}
+}
+
+func Test_SymmetricKeySet(t *testing.T) {
+	keys := NewSymmetricKeySet("MySecrets")
+
+	jwtToken, err := newTestJWT("MyUniqueID", "MyUserID", keys, time.Now(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	signedJWT, err := jwt.ParseSigned(jwtToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, signedJWT.Headers[0].KeyID)
+
+	// The shared secret is never published.
+	assert.Empty(t, keys.PublicKeys().Keys)
+
+	// A token signed with a symmetric key can't pass as an asymmetrically signed one.
+	rsaKeys, err := NewAsymmetricKeySet("RS256", newTestPrivateKey(t, "RS256"), signedJWT.Headers[0].KeyID)
+	require.NoError(t, err)
+	_, err = ParseJWT(jwtToken, &TokenConfig{Keys: rsaKeys})
+	assert.Equal(t, ErrInvalidJWT, err)
+}
+
+func Test_NewSigningKey_MismatchedAlgorithm(t *testing.T) {
+	_, err := NewSigningKey("ES256", newTestPrivateKey(t, "RS256"), "")
+	assert.Equal(t, ErrMismatchedKeyType, err)
+	_, err = NewSigningKey("RS256", newTestPrivateKey(t, "EdDSA"), "")
+	assert.Equal(t, ErrMismatchedKeyType, err)
+}
+
+func Test_LoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	der, err := x509.MarshalPKCS8PrivateKey(newTestPrivateKey(t, "ES256"))
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, ioutil.WriteFile(keyFile, data, 0600))
+
+	keys, err := LoadKeySet("ES256", keyFile, "MyKeyID", "")
+	require.NoError(t, err)
+	signingKey, err := keys.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, "MyKeyID", signingKey.KeyID)
+
+	_, err = LoadKeySet("ES256", "", "", "")
+	assert.Equal(t, ErrMissingSigningKeyFile, err)
+}
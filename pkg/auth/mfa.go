@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	mfaTokenLen = 32
+
+	recoveryCodeCount = 10
+	// recoveryCodeLen is the length of a recovery code, which is shown in 2 groups, eg. "k7d2m-x9p4q".
+	recoveryCodeLen = 10
+	// recoveryCodeAlphabet leaves out the characters that are easily mistaken for one another.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	// rolesClaim is the custom claim that lists the roles of a user.
+	rolesClaim = "roles"
+)
+
+var (
+	ErrInvalidMFAToken = errors.New("invalid mfa token")
+	ErrInvalidMFACode  = errors.New("invalid mfa code")
+	ErrMFANotEnrolled  = errors.New("mfa isn't enrolled")
+	ErrMFAEnabled      = errors.New("mfa is already enabled")
+	ErrMFARequired     = errors.New("mfa is required for the user")
+)
+
+// hasRole returns true if the user has any of the roles.
+func hasRole(user *models.User, roles []string) bool {
+	// The roles are a slice of strings when set in code, and of interfaces when loaded from the data
+	// store.
+	claim := reflect.ValueOf(user.Claims[rolesClaim])
+	if claim.Kind() != reflect.Slice {
+		return false
+	}
+
+	for i := 0; i < claim.Len(); i++ {
+		role := fmt.Sprint(claim.Index(i).Interface())
+		for _, r := range roles {
+			if role == r {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MFARequired returns true if the user must sign in with a second factor, ie. the user has enabled
+// the MFA or has any of the roles that require it.
+func MFARequired(user *models.User, roles []string) bool {
+	return user.MFA.Enabled || hasRole(user, roles)
+}
+
+// CreateMFAChallenge generates a token that allows the user, who has signed in with the password, to
+// complete the sign-in with the second factor until it expires.
+func CreateMFAChallenge(parent context.Context, ds store.DataStore, uid string, ttl time.Duration) (string, error) {
+	token, err := utils.GetRandSecret(mfaTokenLen)
+	if err != nil {
+		return "", err
+	}
+
+	mc := models.MFAChallenge{
+		ID:       hashCode(token),
+		UserID:   uid,
+		ExpireAt: time.Now().Add(ttl),
+	}
+	if err := ds.SaveMFAChallenge(parent, &mc); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetMFAChallengeUser returns the user of the MFA challenge token.
+func GetMFAChallengeUser(parent context.Context, ds store.DataStore, token string) (*models.User, error) {
+	mc, err := ds.GetMFAChallenge(parent, hashCode(token))
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(mc.ExpireAt) {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := ds.GetUser(parent, mc.UserID)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CompleteMFAChallenge checks the code of the user of the challenge token and uses up the token. A
+// user who hasn't enabled the MFA, ie. who must enroll to sign in, confirms the pending TOTP secret
+// with the code, and the new recovery codes are returned.
+func CompleteMFAChallenge(parent context.Context, ds store.DataStore, token string, user *models.User,
+	code string) ([]string, error) {
+	var codes []string
+	var err error
+	if user.MFA.Enabled {
+		err = VerifyMFA(parent, ds, user, code)
+	} else {
+		codes, err = ConfirmTOTP(parent, ds, user, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ds.ConsumeMFAChallenge(parent, hashCode(token)); err == store.ErrorNotFound {
+		return nil, ErrInvalidMFAToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// EnrollTOTP generates a TOTP secret for the user, replacing any pending one. The secret is pending
+// until it's confirmed with ConfirmTOTP.
+func EnrollTOTP(parent context.Context, ds store.DataStore, user *models.User) (string, error) {
+	if user.MFA.Enabled {
+		return "", ErrMFAEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = updateUser(parent, ds, user, func(updated *models.User) error {
+		if updated.MFA.Enabled {
+			return ErrMFAEnabled
+		}
+		updated.MFA = models.MFA{TOTPSecret: secret}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ConfirmTOTP enables the MFA of the user if the code of the pending TOTP secret is valid, and
+// returns the new recovery codes. Only their hashes are saved, so they can't be shown again.
+func ConfirmTOTP(parent context.Context, ds store.DataStore, user *models.User, code string) ([]string, error) {
+	if user.MFA.Enabled {
+		return nil, ErrMFAEnabled
+	}
+	if user.MFA.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := validateTOTP(user.MFA.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := user.MFA.TOTPSecret
+	err = updateUser(parent, ds, user, func(updated *models.User) error {
+		if updated.MFA.Enabled {
+			return ErrMFAEnabled
+		}
+		// The code is of the secret that was checked, not of one enrolled since.
+		if updated.MFA.TOTPSecret != secret {
+			return ErrInvalidMFACode
+		}
+		updated.MFA = models.MFA{
+			Enabled:       true,
+			TOTPSecret:    secret,
+			LastTOTPStep:  step,
+			RecoveryCodes: hashes,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyMFA checks the code of the user, which is either a TOTP code or a recovery code. A recovery
+// code can only be used once.
+func VerifyMFA(parent context.Context, ds store.DataStore, user *models.User, code string) error {
+	if !user.MFA.Enabled {
+		return ErrMFANotEnrolled
+	}
+
+	updated := *user
+	if step, ok := validateTOTP(user.MFA.TOTPSecret, code, time.Now(), user.MFA.LastTOTPStep); ok {
+		updated.MFA.LastTOTPStep = step
+	} else {
+		hashed := hashCode(normalizeRecoveryCode(code))
+		remaining := make([]string, 0, len(user.MFA.RecoveryCodes))
+		for _, rc := range user.MFA.RecoveryCodes {
+			if rc != hashed {
+				remaining = append(remaining, rc)
+			}
+		}
+		if len(remaining) == len(user.MFA.RecoveryCodes) {
+			return ErrInvalidMFACode
+		}
+		updated.MFA.RecoveryCodes = remaining
+	}
+
+	// The user is only updated if it hasn't changed since the code was checked, so a code that is
+	// used concurrently is only accepted once.
+	if err := ds.UpdateUser(parent, &updated); err == store.ErrConflict {
+		return ErrInvalidMFACode
+	} else if err != nil {
+		return err
+	}
+	user.MFA = updated.MFA
+	user.Version = updated.Version
+
+	return nil
+}
+
+// DisableMFA turns off the MFA of the user, provided that the password is valid and that none of the
+// roles of the user requires the MFA.
+func DisableMFA(parent context.Context, ds store.DataStore, uid, password string, roles []string) error {
+	user, err := ds.GetUser(parent, uid)
+	if err == store.ErrorNotFound {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// The password and the roles are checked again if the user is read again.
+	return updateUser(parent, ds, user, func(updated *models.User) error {
+		if !Authenticate(updated.Password, password, updated.Salt) {
+			return ErrInvalidCredentials
+		}
+		if hasRole(updated, roles) {
+			return ErrMFARequired
+		}
+		updated.MFA = models.MFA{}
+		return nil
+	})
+}
+
+// newRecoveryCodes returns new recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := utils.GetRandSecret(recoveryCodeLen, []byte(recoveryCodeAlphabet)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:]
+		hashes[i] = hashCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores the case and the separators of a recovery code as typed by the user.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+// challengeStore is a data store that keeps users and MFA challenges.
+type challengeStore struct {
+	userStore
+
+	challenges map[string]*models.MFAChallenge
+}
+
+func (cs *challengeStore) GetMFAChallenge(_ context.Context, id string) (*models.MFAChallenge, error) {
+	mc, ok := cs.challenges[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+
+	return mc, nil
+}
+
+func (cs *challengeStore) SaveMFAChallenge(_ context.Context, mc *models.MFAChallenge) error {
+	cs.challenges[mc.ID] = mc
+	return nil
+}
+
+func (cs *challengeStore) ConsumeMFAChallenge(_ context.Context, id string) (*models.MFAChallenge, error) {
+	mc, ok := cs.challenges[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	delete(cs.challenges, id)
+
+	return mc, nil
+}
+
+func newChallengeStore(users ...*models.User) *challengeStore {
+	cs := challengeStore{
+		userStore:  userStore{users: map[string]*models.User{}},
+		challenges: map[string]*models.MFAChallenge{},
+	}
+	for _, user := range users {
+		cs.users[user.ID] = user
+	}
+
+	return &cs
+}
+
+func currentTOTPCode(t *testing.T, user *models.User) string {
+	code, err := TOTPCode(user.MFA.TOTPSecret, time.Now())
+	require.NoError(t, err)
+
+	return code
+}
+
+func Test_MFARequired(t *testing.T) {
+	admin := models.User{Claims: map[string]interface{}{"roles": []interface{}{"staff", "admin"}}}
+	user := models.User{Claims: map[string]interface{}{"roles": []string{"staff"}}}
+
+	assert.True(t, MFARequired(&admin, []string{"admin"}))
+	assert.False(t, MFARequired(&admin, nil))
+	assert.False(t, MFARequired(&user, []string{"admin"}))
+	assert.False(t, MFARequired(&models.User{}, []string{"admin"}))
+
+	user.MFA.Enabled = true
+	assert.True(t, MFARequired(&user, nil))
+}
+
+func Test_EnrollTOTP(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	ds := newChallengeStore(&user)
+
+	// The secret is pending until it's confirmed.
+	secret, err := EnrollTOTP(ctx, ds, &user)
+	require.NoError(t, err)
+	assert.Equal(t, secret, ds.users["1"].MFA.TOTPSecret)
+	assert.False(t, ds.users["1"].MFA.Enabled)
+	assert.Equal(t, ErrMFANotEnrolled, VerifyMFA(ctx, ds, &user, currentTOTPCode(t, &user)))
+
+	_, err = ConfirmTOTP(ctx, ds, &user, "000000")
+	assert.Equal(t, ErrInvalidMFACode, err)
+
+	codes, err := ConfirmTOTP(ctx, ds, &user, currentTOTPCode(t, &user))
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.True(t, ds.users["1"].MFA.Enabled)
+	// Only the hashes of the recovery codes are saved.
+	assert.NotContains(t, ds.users["1"].MFA.RecoveryCodes, codes[0])
+
+	_, err = EnrollTOTP(ctx, ds, &user)
+	assert.Equal(t, ErrMFAEnabled, err)
+}
+
+func Test_VerifyMFA(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	ds := newChallengeStore(&user)
+	_, err := EnrollTOTP(ctx, ds, &user)
+	require.NoError(t, err)
+	codes, err := ConfirmTOTP(ctx, ds, &user, currentTOTPCode(t, &user))
+	require.NoError(t, err)
+
+	// The code used to confirm the enrollment can't be replayed.
+	assert.Equal(t, ErrInvalidMFACode, VerifyMFA(ctx, ds, &user, currentTOTPCode(t, &user)))
+	assert.Equal(t, ErrInvalidMFACode, VerifyMFA(ctx, ds, &user, "aaaaa-aaaaa"))
+
+	// A recovery code works once, however it's typed.
+	assert.NoError(t, VerifyMFA(ctx, ds, &user, " "+strings.ToUpper(codes[0])))
+	assert.Len(t, ds.users["1"].MFA.RecoveryCodes, recoveryCodeCount-1)
+	assert.Equal(t, ErrInvalidMFACode, VerifyMFA(ctx, ds, &user, codes[0]))
+	assert.NoError(t, VerifyMFA(ctx, ds, &user, strings.ReplaceAll(codes[1], "-", "")))
+}
+
+func Test_CompleteMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	ds := newChallengeStore(&user)
+
+	token, err := CreateMFAChallenge(ctx, ds, user.ID, time.Minute)
+	require.NoError(t, err)
+	_, err = GetMFAChallengeUser(ctx, ds, "unknown")
+	assert.Equal(t, ErrInvalidMFAToken, err)
+	got, err := GetMFAChallengeUser(ctx, ds, token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, got.ID)
+
+	// A user who must enroll confirms the pending secret.
+	_, err = CompleteMFAChallenge(ctx, ds, token, got, "123456")
+	assert.Equal(t, ErrMFANotEnrolled, err)
+	_, err = EnrollTOTP(ctx, ds, got)
+	require.NoError(t, err)
+	_, err = CompleteMFAChallenge(ctx, ds, token, got, "000000")
+	assert.Equal(t, ErrInvalidMFACode, err)
+	codes, err := CompleteMFAChallenge(ctx, ds, token, got, currentTOTPCode(t, got))
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	// The token is used up.
+	_, err = GetMFAChallengeUser(ctx, ds, token)
+	assert.Equal(t, ErrInvalidMFAToken, err)
+
+	// An expired token is invalid.
+	token, err = CreateMFAChallenge(ctx, ds, user.ID, -time.Second)
+	require.NoError(t, err)
+	_, err = GetMFAChallengeUser(ctx, ds, token)
+	assert.Equal(t, ErrInvalidMFAToken, err)
+}
+
+func Test_DisableMFA(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	user := models.User{
+		ID:       "1",
+		Username: "chan",
+		Password: hashed,
+		Claims:   map[string]interface{}{"roles": []string{"admin"}},
+		MFA:      models.MFA{Enabled: true, TOTPSecret: "JBSWY3DPEHPK3PXP"},
+	}
+	ds := newChallengeStore(&user)
+
+	assert.Equal(t, ErrInvalidCredentials, DisableMFA(ctx, ds, "1", "wrong", nil))
+	assert.Equal(t, ErrMFARequired, DisableMFA(ctx, ds, "1", "mypassword", []string{"admin"}))
+	assert.Equal(t, ErrUserNotFound, DisableMFA(ctx, ds, "2", "mypassword", nil))
+
+	require.NoError(t, DisableMFA(ctx, ds, "1", "mypassword", nil))
+	assert.Equal(t, models.MFA{}, ds.users["1"].MFA)
+}
+
+func Test_MFA_Conflict(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := crypto.HashPassword("mypassword", testPasswordParams)
+	require.NoError(t, err)
+	ds := newRacingStore(t, 1, &models.User{ID: "1", Username: "chan", Password: hashed})
+	user, err := ds.GetUser(ctx, "1")
+	require.NoError(t, err)
+
+	// The user is read again and updated again.
+	secret, err := EnrollTOTP(ctx, ds, user)
+	require.NoError(t, err)
+	assert.Equal(t, secret, user.MFA.TOTPSecret)
+	assert.Equal(t, "blue", user.Claims["team"])
+
+	// The code of the secret can't confirm a secret enrolled since.
+	stale := *user
+	ds.races = 1
+	ds.change = func(user *models.User) {
+		user.MFA.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	}
+	_, err = ConfirmTOTP(ctx, ds, &stale, currentTOTPCode(t, &stale))
+	assert.Equal(t, ErrInvalidMFACode, err)
+
+	// The roles are checked again.
+	ds.races = 1
+	ds.change = func(user *models.User) {
+		user.MFA = models.MFA{Enabled: true}
+		user.Claims = map[string]interface{}{"roles": []string{"admin"}}
+	}
+	assert.Equal(t, ErrMFARequired, DisableMFA(ctx, ds, "1", "mypassword", []string{"admin"}))
+	saved, err := ds.GetUser(ctx, "1")
+	require.NoError(t, err)
+	assert.True(t, saved.MFA.Enabled)
+}
@@ -2,9 +2,11 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/square/go-jose/v3/jwt"
 	"golang.org/x/oauth2"
 
 	"github.com/cybersamx/authx/pkg/models"
@@ -15,12 +17,44 @@ const (
 	tokenType = "Bearer"
 )
 
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrReusedRefreshToken  = errors.New("refresh token has already been used")
+)
+
+// AccessTokenParams are the claims of an access token.
+type AccessTokenParams struct {
+	// UserID is empty if the token is issued to a client on its own behalf.
+	UserID   string
+	ClientID string
+	Scope    string
+	Custom   map[string]interface{}
+	TTL      time.Duration
+}
+
 // NewAccessToken returns an access token object embedding the token in JWT format.
-func NewAccessToken(uid, secrets string, ttl time.Duration) (*models.AccessToken, error) {
+func NewAccessToken(tc *TokenConfig, params *AccessTokenParams) (*models.AccessToken, error) {
 	now := time.Now()
-	expireAt := time.Now().Add(ttl)
+	expireAt := now.Add(params.TTL)
 	atID := uuid.New().String()
-	jwtToken, err := NewJWT(atID, uid, secrets, now, expireAt)
+
+	subject := params.UserID
+	if subject == "" {
+		subject = params.ClientID
+	}
+	claims := JWTClaims{
+		UserID:   params.UserID,
+		ClientID: params.ClientID,
+		Scope:    params.Scope,
+		Custom:   params.Custom,
+		Claims: jwt.Claims{
+			Subject:  subject,
+			Expiry:   jwt.NewNumericDate(expireAt),
+			IssuedAt: jwt.NewNumericDate(now),
+			ID:       atID,
+		},
+	}
+	jwtToken, err := NewJWT(&claims, tc)
 	if err != nil {
 		return nil, err
 	}
@@ -28,44 +62,87 @@ func NewAccessToken(uid, secrets string, ttl time.Duration) (*models.AccessToken
 	at := models.AccessToken{
 		ID:       atID,
 		Value:    jwtToken,
-		UserID:   uid,
+		UserID:   params.UserID,
+		ClientID: params.ClientID,
+		Scope:    params.Scope,
 		ExpireAt: expireAt,
 	}
 
 	return &at, err
 }
 
-// NewRefreshToken returns an access refresh token object embedding the token.
-func NewRefreshToken(uid string, ttl time.Duration) *models.RefreshToken {
+// NewRefreshToken returns an access refresh token object embedding the token. The token starts a new
+// token family.
+func NewRefreshToken(uid, clientID, scope string, ttl time.Duration) *models.RefreshToken {
 	id := uuid.New().String()
-	expireAt := time.Now().Add(ttl)
+	now := time.Now()
+	expireAt := now.Add(ttl)
 
 	rt := models.RefreshToken{
 		ID:       id,
 		Value:    id,
 		UserID:   uid,
+		ClientID: clientID,
+		Scope:    scope,
+		FamilyID: id,
+		IssuedAt: now,
 		ExpireAt: expireAt,
 	}
 
 	return &rt
 }
 
+// getCustomClaims returns the custom claims of the user from the ClaimsFunc of the token config.
+func getCustomClaims(parent context.Context, ds store.DataStore, tc *TokenConfig, uid string) (map[string]interface{}, error) {
+	if tc.ClaimsFunc == nil {
+		return nil, nil
+	}
+
+	user, err := ds.GetUser(parent, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.ClaimsFunc(parent, user)
+}
+
+// StoredClaims is the ClaimsFunc that returns the custom claims kept on the user.
+func StoredClaims(_ context.Context, user *models.User) (map[string]interface{}, error) {
+	return user.Claims, nil
+}
+
 type CreateOAuthTokenParams struct {
-	UID        string
-	Secret     string
+	UID string
+	// ClientID is the client the tokens are issued to, or empty for a first-party sign-in.
+	ClientID string
+	// Scope is the scope granted to the client the tokens are issued to.
+	Scope      string
 	AccessTTL  time.Duration
 	RefreshTTL time.Duration
 }
 
-func CreateOAuthToken(parent context.Context, ds store.DataStore, uid, secret string, aTTL, rTTL time.Duration) (*oauth2.Token, error) {
+func CreateOAuthToken(parent context.Context, ds store.DataStore, tc *TokenConfig,
+	params *CreateOAuthTokenParams) (*oauth2.Token, error) {
+	custom, err := getCustomClaims(parent, ds, tc, params.UID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Access token
-	at, err := NewAccessToken(uid, secret, aTTL)
+	at, err := NewAccessToken(tc, &AccessTokenParams{
+		UserID:   params.UID,
+		ClientID: params.ClientID,
+		Scope:    params.Scope,
+		Custom:   custom,
+		TTL:      params.AccessTTL,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Refresh token
-	rt := NewRefreshToken(uid, rTTL)
+	rt := NewRefreshToken(params.UID, params.ClientID, params.Scope, params.RefreshTTL)
+	at.FamilyID = rt.FamilyID
 
 	// Save the tokens
 	if err := ds.SaveAccessToken(parent, at); err != nil {
@@ -85,3 +162,121 @@ func CreateOAuthToken(parent context.Context, ds store.DataStore, uid, secret st
 
 	return &otoken, nil
 }
+
+// RevokeTokenFamily removes all access and refresh tokens descending from the same sign-in. It
+// returns the ids of the revoked access tokens.
+func RevokeTokenFamily(parent context.Context, ds store.DataStore, familyID string) ([]string, error) {
+	if err := ds.RemoveRefreshTokensByFamily(parent, familyID); err != nil {
+		return nil, err
+	}
+
+	return ds.RemoveAccessTokensByFamily(parent, familyID)
+}
+
+// RevokeUserTokens revokes all the access and refresh tokens of the user, eg. after the password is
+// reset. It returns the ids of the revoked access tokens.
+func RevokeUserTokens(parent context.Context, ds store.DataStore, uid string) ([]string, error) {
+	return revokeUserTokens(parent, ds, uid, "")
+}
+
+// RevokeOtherUserTokens revokes the access and refresh tokens of the user, except the ones issued
+// with the same sign-in as the access token atid, so that the user stays signed in on the current
+// device only. It returns the ids of the revoked access tokens.
+func RevokeOtherUserTokens(parent context.Context, ds store.DataStore, uid, atid string) ([]string, error) {
+	at, err := ds.GetAccessToken(parent, atid)
+	if err == store.ErrorNotFound {
+		return RevokeUserTokens(parent, ds, uid)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return revokeUserTokens(parent, ds, uid, at.FamilyID)
+}
+
+func revokeUserTokens(parent context.Context, ds store.DataStore, uid, keepFamilyID string) ([]string, error) {
+	if err := ds.RemoveRefreshTokensByUser(parent, uid, keepFamilyID); err != nil {
+		return nil, err
+	}
+
+	return ds.RemoveAccessTokensByUser(parent, uid, keepFamilyID)
+}
+
+// RefreshOAuthToken redeems a refresh token for a new access token. If rotate is true, the refresh
+// token is exchanged for a new one in the same family and the old one is marked as used. Presenting
+// a used refresh token is treated as a token theft and the whole token family is revoked. The refresh
+// token must have been issued to the client clientID, which is empty for a first-party sign-in.
+func RefreshOAuthToken(parent context.Context, ds store.DataStore, tc *TokenConfig, clientID, rtValue string,
+	aTTL, rTTL time.Duration, rotate bool) (*oauth2.Token, error) {
+	rt, err := ds.GetRefreshToken(parent, rtValue)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+	if rt.ClientID != clientID {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if rt.Used {
+		if _, err := RevokeTokenFamily(parent, ds, rt.FamilyID); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrReusedRefreshToken
+	}
+	if time.Now().After(rt.ExpireAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Rotate the refresh token.
+	if rotate {
+		// Another request may have redeemed the same token concurrently, treat it as a replay.
+		err := ds.MarkRefreshTokenUsed(parent, rt.ID)
+		if err == store.ErrorNotFound {
+			if _, rerr := RevokeTokenFamily(parent, ds, rt.FamilyID); rerr != nil {
+				return nil, rerr
+			}
+
+			return nil, ErrReusedRefreshToken
+		} else if err != nil {
+			return nil, err
+		}
+
+		nrt := NewRefreshToken(rt.UserID, rt.ClientID, rt.Scope, rTTL)
+		nrt.FamilyID = rt.FamilyID
+		if err := ds.SaveRefreshToken(parent, nrt); err != nil {
+			return nil, err
+		}
+		rt = nrt
+	}
+
+	// Access token, the custom claims are refreshed as well.
+	custom, err := getCustomClaims(parent, ds, tc, rt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	at, err := NewAccessToken(tc, &AccessTokenParams{
+		UserID:   rt.UserID,
+		ClientID: rt.ClientID,
+		Scope:    rt.Scope,
+		Custom:   custom,
+		TTL:      aTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	at.FamilyID = rt.FamilyID
+	if err := ds.SaveAccessToken(parent, at); err != nil {
+		return nil, err
+	}
+
+	// OAuth2 token
+	otoken := oauth2.Token{
+		AccessToken:  at.Value,
+		TokenType:    tokenType,
+		RefreshToken: rt.Value,
+		Expiry:       at.ExpireAt,
+	}
+
+	return &otoken, nil
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+
+	"github.com/cybersamx/authx/pkg/models"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// UserClaims are the standard OpenID Connect claims describing the user.
+type UserClaims struct {
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Name              string `json:"name,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// IDTokenClaims represents the claims of an OpenID Connect id token.
+type IDTokenClaims struct {
+	jwt.Claims
+	UserClaims
+	Nonce           string           `json:"nonce,omitempty"`
+	AuthTime        *jwt.NumericDate `json:"auth_time,omitempty"`
+	AccessTokenHash string           `json:"at_hash,omitempty"`
+}
+
+// IDTokenParams are the parameters of the authentication request the id token is issued for.
+type IDTokenParams struct {
+	ClientID string
+	Scope    string
+	Nonce    string
+	AuthTime time.Time
+	// AccessToken is the access token issued along with the id token.
+	AccessToken string
+	TTL         time.Duration
+}
+
+// HasScope returns true if the space-delimited list of scopes contains the scope.
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewUserClaims returns the claims of the user that are released for the scopes.
+func NewUserClaims(user *models.User, scope string) *UserClaims {
+	var claims UserClaims
+	if HasScope(scope, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+		claims.Name = user.Name
+	}
+	if HasScope(scope, ScopeEmail) && user.Email != "" {
+		verified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+
+	return &claims
+}
+
+// NewIDToken returns an id token for the user signed by the current signing key of the key set.
+// The audience of the id token is the client. It returns ErrSymmetricIDToken if the key is symmetric,
+// as the clients couldn't verify the id token without the secret of the service.
+func NewIDToken(user *models.User, params *IDTokenParams, tc *TokenConfig) (string, error) {
+	key, err := tc.Keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	if public := key.Public(); !public.Valid() {
+		return "", ErrSymmetricIDToken
+	}
+
+	now := time.Now()
+	claims := IDTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:   tc.Issuer,
+			Subject:  user.ID,
+			Audience: jwt.Audience{params.ClientID},
+			Expiry:   jwt.NewNumericDate(now.Add(params.TTL)),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		UserClaims: *NewUserClaims(user, params.Scope),
+		Nonce:      params.Nonce,
+	}
+	if !params.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(params.AuthTime)
+	}
+	if params.AccessToken != "" {
+		claims.AccessTokenHash = accessTokenHash(params.AccessToken, key.Algorithm)
+	}
+
+	return signJWT(key, claims)
+}
+
+// accessTokenHash returns the at_hash claim, which is the left-most half of the hash of the access
+// token using the hash function of the signing algorithm.
+func accessTokenHash(accessToken, alg string) string {
+	var h hash.Hash
+	if jose.SignatureAlgorithm(alg) == jose.EdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	_, _ = h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
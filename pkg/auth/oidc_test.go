@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+)
+
+func Test_AccessTokenHash(t *testing.T) {
+	// Example from https://openid.net/specs/openid-connect-core-1_0.html#id_tokenExample
+	assert.Equal(t, "77QmUPtjPfzWtF2AnpK9RQ", accessTokenHash("jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS256"))
+}
+
+func Test_NewIDToken(t *testing.T) {
+	keys, err := NewAsymmetricKeySet("ES256", newTestPrivateKey(t, "ES256"), "")
+	require.NoError(t, err)
+	user := models.User{
+		ID:       "1",
+		Username: "chan",
+		Name:     "Chan",
+		Email:    "chan@example.com",
+	}
+	authTime := time.Now().Add(-time.Minute)
+	tc := TokenConfig{
+		Keys:     keys,
+		Issuer:   "https://auth.example.com",
+		Audience: []string{"https://api.example.com"},
+	}
+	params := IDTokenParams{
+		ClientID:    "spa",
+		Scope:       "openid profile",
+		Nonce:       "n-0S6_WzA2Mj",
+		AuthTime:    authTime,
+		AccessToken: "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y",
+		TTL:         time.Hour,
+	}
+
+	idToken, err := NewIDToken(&user, &params, &tc)
+	require.NoError(t, err)
+
+	signedJWT, err := jwt.ParseSigned(idToken)
+	require.NoError(t, err)
+	key, err := keys.VerificationKey(signedJWT.Headers[0].KeyID)
+	require.NoError(t, err)
+	var claims IDTokenClaims
+	require.NoError(t, signedJWT.Claims(key.Public().Key, &claims))
+
+	assert.NoError(t, claims.Validate(jwt.Expected{
+		Issuer:   tc.Issuer,
+		Subject:  user.ID,
+		Audience: jwt.Audience{params.ClientID},
+		Time:     time.Now(),
+	}))
+	assert.Equal(t, params.Nonce, claims.Nonce)
+	assert.Equal(t, authTime.Unix(), claims.AuthTime.Time().Unix())
+	assert.Equal(t, "77QmUPtjPfzWtF2AnpK9RQ", claims.AccessTokenHash)
+
+	// Only the claims of the requested scopes are released.
+	assert.Equal(t, user.Username, claims.PreferredUsername)
+	assert.Equal(t, user.Name, claims.Name)
+	assert.Empty(t, claims.Email)
+}
+
+func Test_NewIDToken_SymmetricKey(t *testing.T) {
+	tc := TokenConfig{Keys: NewSymmetricKeySet("secret"), Issuer: "https://auth.example.com"}
+	params := IDTokenParams{ClientID: "spa", Scope: "openid", TTL: time.Hour}
+
+	_, err := NewIDToken(&models.User{ID: "1"}, &params, &tc)
+	assert.Equal(t, ErrSymmetricIDToken, err)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules of the password policy. They double as the keys of the translated messages of the
+// violations.
+const (
+	PolicyMinLength      = "password_min_length"
+	PolicyMinCharClasses = "password_min_char_classes"
+	PolicyMaxRepeated    = "password_max_repeated"
+	PolicyUserInfo       = "password_user_info"
+	PolicyBreached       = "password_breached"
+
+	// minUserInfoLen is the length of the shortest username or email local part that a password
+	// can't contain, so that a short one doesn't ban too many passwords.
+	minUserInfoLen = 3
+)
+
+// PolicyViolation is a rule of the password policy that a password breaks. Param is the limit set
+// by the rule, if any.
+type PolicyViolation struct {
+	Rule  string
+	Param string
+}
+
+// PolicyError lists the rules of the password policy that a password breaks.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (pe *PolicyError) Error() string {
+	rules := make([]string, len(pe.Violations))
+	for i, v := range pe.Violations {
+		rules[i] = v.Rule
+	}
+
+	return "password violates the policy: " + strings.Join(rules, ", ")
+}
+
+// PasswordPolicy is the set of rules a new password must follow. A zero limit disables the rule.
+type PasswordPolicy struct {
+	MinLength int
+	// MinCharClasses is the number of character classes, out of lowercase letters, uppercase
+	// letters, digits and others, the password must have.
+	MinCharClasses int
+	// MaxRepeated is the longest run of the same character allowed.
+	MaxRepeated int
+	// BanUserInfo bans passwords containing the username or the email address.
+	BanUserInfo bool
+	// Breached, if set, bans the passwords exposed in data breaches.
+	Breached *BreachedPasswords
+}
+
+// Check returns a PolicyError if the password of the user with the username and email breaks any
+// rule of the policy.
+func (pp *PasswordPolicy) Check(password, username, email string) error {
+	var violations []PolicyViolation
+	violate := func(rule string, param int) {
+		violations = append(violations, PolicyViolation{Rule: rule, Param: strconv.Itoa(param)})
+	}
+
+	if pp.MinLength > 0 && utf8.RuneCountInString(password) < pp.MinLength {
+		violate(PolicyMinLength, pp.MinLength)
+	}
+	if pp.MinCharClasses > 0 && countCharClasses(password) < pp.MinCharClasses {
+		violate(PolicyMinCharClasses, pp.MinCharClasses)
+	}
+	if pp.MaxRepeated > 0 && longestRun(password) > pp.MaxRepeated {
+		violate(PolicyMaxRepeated, pp.MaxRepeated)
+	}
+	if pp.BanUserInfo && containsUserInfo(password, username, email) {
+		violations = append(violations, PolicyViolation{Rule: PolicyUserInfo})
+	}
+	if pp.Breached != nil && pp.Breached.Contains(password) {
+		violations = append(violations, PolicyViolation{Rule: PolicyBreached})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// checkPassword checks the password against the policy, if there's one.
+func checkPassword(policy *PasswordPolicy, password, username, email string) error {
+	if policy == nil {
+		return nil
+	}
+
+	return policy.Check(password, username, email)
+}
+
+func countCharClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+func longestRun(password string) int {
+	var longest, run int
+	var prev rune
+	for i, r := range password {
+		if i > 0 && r == prev {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+		prev = r
+	}
+
+	return longest
+}
+
+func containsUserInfo(password, username, email string) bool {
+	lowered := strings.ToLower(password)
+	local := email
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		local = email[:i]
+	}
+
+	for _, info := range []string{username, email, local} {
+		if utf8.RuneCountInString(info) >= minUserInfoLen && strings.Contains(lowered, strings.ToLower(info)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func violatedRules(err error) []string {
+	perr, ok := err.(*PolicyError)
+	if !ok {
+		return nil
+	}
+
+	rules := make([]string, len(perr.Violations))
+	for i, v := range perr.Violations {
+		rules[i] = v.Rule
+	}
+
+	return rules
+}
+
+func Test_PasswordPolicy(t *testing.T) {
+	breached := BreachedPasswords{ranges: map[string]map[string]struct{}{}}
+	// SHA-1 of "password1".
+	breached.add("E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D")
+	policy := PasswordPolicy{
+		MinLength:      8,
+		MinCharClasses: 2,
+		MaxRepeated:    3,
+		BanUserInfo:    true,
+		Breached:       &breached,
+	}
+
+	cases := []struct {
+		description string
+		password    string
+		rules       []string
+	}{
+		{"valid password", "correct horse", nil},
+		{"too short", "ab12", []string{PolicyMinLength}},
+		{"too short in characters, not bytes", "ééééé1", []string{PolicyMinLength, PolicyMaxRepeated}},
+		{"too few character classes", "correcthorse", []string{PolicyMinCharClasses}},
+		{"too many repeated characters", "correct hoooorse", []string{PolicyMaxRepeated}},
+		{"contains the username", "Chan is 1337", []string{PolicyUserInfo}},
+		{"contains the email local part", "i am chan.lee!", []string{PolicyUserInfo}},
+		{"breached", "password1", []string{PolicyBreached}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			err := policy.Check(c.password, "chan", "chan.lee@example.com")
+			if c.rules == nil {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, c.rules, violatedRules(err))
+		})
+	}
+}
+
+func Test_PasswordPolicy_Disabled(t *testing.T) {
+	var policy PasswordPolicy
+
+	assert.NoError(t, policy.Check("a", "a", ""))
+	assert.NoError(t, checkPassword(nil, "a", "a", ""))
+}
+
+func Test_PolicyError(t *testing.T) {
+	err := &PolicyError{Violations: []PolicyViolation{
+		{Rule: PolicyMinLength, Param: "8"},
+		{Rule: PolicyBreached},
+	}}
+
+	assert.Equal(t, "password violates the policy: password_min_length, password_breached", err.Error())
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	resetTokenLen = 32
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+)
+
+// CreatePasswordReset generates a token that allows the user to set a new password until it expires.
+func CreatePasswordReset(parent context.Context, ds store.DataStore, uid string, ttl time.Duration) (string, error) {
+	token, err := utils.GetRandSecret(resetTokenLen)
+	if err != nil {
+		return "", err
+	}
+
+	pr := models.PasswordReset{
+		ID:       hashCode(token),
+		UserID:   uid,
+		ExpireAt: time.Now().Add(ttl),
+	}
+	if err := ds.SavePasswordReset(parent, &pr); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword sets the password of the user of the reset token. The password must follow the
+// policy, if set, and the token stays valid if it doesn't or if the password can't be saved. The token can only be used once. The other
+// reset tokens and all the tokens of the user are revoked, and the revoked access tokens invalidated
+// in the checker, so that anyone who has taken over the account is signed out.
+func ResetPassword(parent context.Context, ds store.DataStore, checker TokenChecker, token, password string,
+	params *crypto.PasswordParams, policy *PasswordPolicy) (*models.User, error) {
+	id := hashCode(token)
+	pr, err := ds.GetPasswordReset(parent, id)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidResetToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(pr.ExpireAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := ds.GetUser(parent, pr.UserID)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidResetToken
+	} else if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(policy, password, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+
+	// Consume the token only now, so that it can be used once even if it's raced, and save it back if
+	// the password isn't set.
+	if _, err := ds.ConsumePasswordReset(parent, id); err == store.ErrorNotFound {
+		return nil, ErrInvalidResetToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := setPassword(parent, ds, user, password, params); err != nil {
+		if rerr := ds.SavePasswordReset(parent, pr); rerr != nil {
+			log.Printf("failed to restore password reset %s: %v\n", pr.ID, rerr)
+		}
+		return nil, err
+	}
+
+	if err := ds.RemovePasswordResetsByUser(parent, user.ID); err != nil {
+		return nil, err
+	}
+	atids, err := RevokeUserTokens(parent, ds, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, atid := range atids {
+		checker.Invalidate(atid)
+	}
+
+	return user, nil
+}
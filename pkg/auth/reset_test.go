@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+func Test_ResetPassword_Conflict(t *testing.T) {
+	ctx := context.Background()
+	ds := newRacingStore(t, maxUpdateAttempts, &models.User{ID: "1", Username: "chan"})
+	checker := NewStoreTokenChecker(ds)
+	token, err := CreatePasswordReset(ctx, ds, "1", time.Hour)
+	require.NoError(t, err)
+
+	// The token stays valid if the password can't be saved.
+	_, err = ResetPassword(ctx, ds, checker, token, "newpassword", testPasswordParams, nil)
+	assert.Equal(t, store.ErrConflict, err)
+
+	user, err := ResetPassword(ctx, ds, checker, token, "newpassword", testPasswordParams, nil)
+	require.NoError(t, err)
+	assert.True(t, Authenticate(user.Password, "newpassword", user.Salt))
+
+	_, err = ResetPassword(ctx, ds, checker, token, "otherpassword", testPasswordParams, nil)
+	assert.Equal(t, ErrInvalidResetToken, err)
+}
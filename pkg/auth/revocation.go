@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	maxCachedTokens = 10000
+)
+
+// TokenChecker checks if an access token has been revoked.
+type TokenChecker interface {
+	// IsRevoked returns true if the access token with the id has been revoked.
+	IsRevoked(parent context.Context, id string) (bool, error)
+	// Invalidate discards anything known about the access token with the id, if applicable.
+	Invalidate(id string)
+}
+
+// StoreTokenChecker looks up access tokens in the data store. An access token that isn't in the
+// data store is considered revoked.
+type StoreTokenChecker struct {
+	ds store.DataStore
+}
+
+func NewStoreTokenChecker(ds store.DataStore) *StoreTokenChecker {
+	return &StoreTokenChecker{ds: ds}
+}
+
+func (sc *StoreTokenChecker) IsRevoked(parent context.Context, id string) (bool, error) {
+	_, err := sc.ds.GetAccessToken(parent, id)
+	if err == store.ErrorNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (sc *StoreTokenChecker) Invalidate(_ string) {}
+
+type cachedResult struct {
+	revoked  bool
+	expireAt time.Time
+}
+
+// CachedTokenChecker caches the results of another TokenChecker for a ttl. Invalidate drops the
+// cached result so that a revocation made by this process takes effect immediately; revocations made
+// elsewhere take effect once the cached result expires.
+type CachedTokenChecker struct {
+	checker TokenChecker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+func NewCachedTokenChecker(checker TokenChecker, ttl time.Duration) *CachedTokenChecker {
+	return &CachedTokenChecker{
+		checker: checker,
+		ttl:     ttl,
+		results: make(map[string]cachedResult),
+	}
+}
+
+func (cc *CachedTokenChecker) get(id string, now time.Time) (cachedResult, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	res, ok := cc.results[id]
+	if !ok || now.After(res.expireAt) {
+		return res, false
+	}
+
+	return res, true
+}
+
+func (cc *CachedTokenChecker) set(id string, revoked bool, now time.Time) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	// Evict the expired results before the cache grows unbounded.
+	if len(cc.results) >= maxCachedTokens {
+		for key, res := range cc.results {
+			if now.After(res.expireAt) {
+				delete(cc.results, key)
+			}
+		}
+		if len(cc.results) >= maxCachedTokens {
+			cc.results = make(map[string]cachedResult)
+		}
+	}
+
+	cc.results[id] = cachedResult{
+		revoked:  revoked,
+		expireAt: now.Add(cc.ttl),
+	}
+}
+
+func (cc *CachedTokenChecker) IsRevoked(parent context.Context, id string) (bool, error) {
+	now := time.Now()
+	if res, ok := cc.get(id, now); ok {
+		return res.revoked, nil
+	}
+
+	revoked, err := cc.checker.IsRevoked(parent, id)
+	if err != nil {
+		return false, err
+	}
+	cc.set(id, revoked, now)
+
+	return revoked, nil
+}
+
+func (cc *CachedTokenChecker) Invalidate(id string) {
+	cc.mu.Lock()
+	delete(cc.results, id)
+	cc.mu.Unlock()
+
+	cc.checker.Invalidate(id)
+}
+
+// RevokeToken revokes an access or refresh token (RFC 7009) issued to the client clientID, which is
+// empty for the tokens of a first-party sign-in. The hint names the token type to try first. Revoking
+// a refresh token revokes all the access and refresh tokens of its family. It returns the ids of the
+// revoked access tokens. An invalid token, or one issued to another client, is ignored.
+func RevokeToken(parent context.Context, ds store.DataStore, tc *TokenConfig, clientID, token, hint string) ([]string, error) {
+	if hint == TokenTypeHintRefreshToken {
+		ok, atids, err := revokeRefreshToken(parent, ds, clientID, token)
+		if ok || err != nil {
+			return atids, err
+		}
+		_, atids, err = revokeAccessToken(parent, ds, tc, clientID, token)
+
+		return atids, err
+	}
+
+	ok, atids, err := revokeAccessToken(parent, ds, tc, clientID, token)
+	if ok || err != nil {
+		return atids, err
+	}
+	_, atids, err = revokeRefreshToken(parent, ds, clientID, token)
+
+	return atids, err
+}
+
+// revokeAccessToken revokes the access token, if it's one issued to the client. It returns true if
+// the token is an access token, and the id of the revoked access token.
+func revokeAccessToken(parent context.Context, ds store.DataStore, tc *TokenConfig, clientID, token string) (bool, []string, error) {
+	claims, err := ParseJWT(token, tc)
+	if err != nil {
+		return false, nil, nil
+	}
+	if claims.ClientID != clientID {
+		return true, nil, nil
+	}
+
+	if err := ds.RemoveAccessToken(parent, claims.ID); err != nil {
+		return true, nil, err
+	}
+
+	return true, []string{claims.ID}, nil
+}
+
+// revokeRefreshToken revokes the family of the refresh token, if it's one issued to the client. It
+// returns true if the token is a refresh token, and the ids of the revoked access tokens.
+func revokeRefreshToken(parent context.Context, ds store.DataStore, clientID, token string) (bool, []string, error) {
+	rt, err := ds.GetRefreshToken(parent, token)
+	if err == store.ErrorNotFound {
+		return false, nil, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+	if rt.ClientID != clientID {
+		return true, nil, nil
+	}
+
+	atids, err := RevokeTokenFamily(parent, ds, rt.FamilyID)
+
+	return true, atids, err
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingChecker struct {
+	revoked map[string]bool
+	calls   int
+}
+
+func (cc *countingChecker) IsRevoked(_ context.Context, id string) (bool, error) {
+	cc.calls++
+	return cc.revoked[id], nil
+}
+
+func (cc *countingChecker) Invalidate(_ string) {}
+
+func Test_CachedTokenChecker(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingChecker{revoked: map[string]bool{}}
+	checker := NewCachedTokenChecker(inner, time.Hour)
+
+	// The first lookup hits the inner checker, the second is cached.
+	revoked, err := checker.IsRevoked(ctx, "at1")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = checker.IsRevoked(ctx, "at1")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	assert.Equal(t, 1, inner.calls)
+
+	// A revocation is seen only after the cached result is invalidated.
+	inner.revoked["at1"] = true
+	revoked, _ = checker.IsRevoked(ctx, "at1")
+	assert.False(t, revoked)
+	checker.Invalidate("at1")
+	revoked, err = checker.IsRevoked(ctx, "at1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func Test_CachedTokenChecker_Expiry(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingChecker{revoked: map[string]bool{}}
+	checker := NewCachedTokenChecker(inner, time.Millisecond)
+
+	_, err := checker.IsRevoked(ctx, "at1")
+	assert.NoError(t, err)
+	inner.revoked["at1"] = true
+	time.Sleep(5 * time.Millisecond)
+
+	revoked, err := checker.IsRevoked(ctx, "at1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	assert.Equal(t, 2, inner.calls)
+}
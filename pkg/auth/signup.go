@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cybersamx/authx/pkg/crypto"
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/utils"
+)
+
+const (
+	SignUpOpen       = "open"
+	SignUpInviteOnly = "invite-only"
+	SignUpDisabled   = "disabled"
+
+	inviteCodeLen = 32
+)
+
+var (
+	ErrSignUpDisabled = errors.New("sign-up is disabled")
+	ErrInvalidInvite  = errors.New("invalid invite code")
+	ErrUsernameTaken  = errors.New("username is already taken")
+)
+
+// SignUpParams are the details of a new user.
+type SignUpParams struct {
+	Username string
+	Password string
+	Name     string
+	Email    string
+	// InviteCode is only required when the sign-up is invite-only.
+	InviteCode string
+}
+
+// CreateInvite generates an invite code that allows a single user to sign up when the sign-up is
+// invite-only.
+func CreateInvite(parent context.Context, ds store.DataStore, ttl time.Duration) (string, error) {
+	code, err := utils.GetRandSecret(inviteCodeLen)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	invite := models.Invite{
+		ID:        hashCode(code),
+		CreatedAt: now,
+		ExpireAt:  now.Add(ttl),
+	}
+	if err := ds.SaveInvite(parent, &invite); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// SignUp creates a user according to the sign-up mode, which is one of SignUpOpen,
+// SignUpInviteOnly or SignUpDisabled. The password must follow the policy, if set. The invite code is
+// checked before anything else, so that the sign-up reveals nothing about the users without a valid
+// invite, and it's redeemed only if the user is created.
+func SignUp(parent context.Context, ds store.DataStore, mode string, params *SignUpParams,
+	pwdParams *crypto.PasswordParams, policy *PasswordPolicy) (*models.User, error) {
+	if mode != SignUpOpen && mode != SignUpInviteOnly {
+		return nil, ErrSignUpDisabled
+	}
+
+	// The invite is consumed up front, so that it can't be redeemed twice, and saved back if the user
+	// isn't created.
+	var invite *models.Invite
+	if mode == SignUpInviteOnly {
+		var err error
+		invite, err = ds.ConsumeInvite(parent, hashCode(params.InviteCode))
+		if err == store.ErrorNotFound {
+			return nil, ErrInvalidInvite
+		} else if err != nil {
+			return nil, err
+		}
+		if time.Now().After(invite.ExpireAt) {
+			return nil, ErrInvalidInvite
+		}
+	}
+
+	user, err := createUser(parent, ds, params, pwdParams, policy)
+	if err != nil {
+		if invite != nil {
+			if rerr := ds.SaveInvite(parent, invite); rerr != nil {
+				log.Printf("failed to restore invite %s: %v\n", invite.ID, rerr)
+			}
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createUser saves a new user with the details, if the username is free and the password follows the
+// policy, if set.
+func createUser(parent context.Context, ds store.DataStore, params *SignUpParams, pwdParams *crypto.PasswordParams,
+	policy *PasswordPolicy) (*models.User, error) {
+	_, err := ds.GetUserByUsername(parent, params.Username)
+	if err == nil {
+		return nil, ErrUsernameTaken
+	} else if err != store.ErrorNotFound {
+		return nil, err
+	}
+
+	if err := checkPassword(policy, params.Password, params.Username, params.Email); err != nil {
+		return nil, err
+	}
+
+	hashed, err := crypto.HashPassword(params.Password, pwdParams)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		ID:       uuid.New().String(),
+		Username: params.Username,
+		Name:     params.Name,
+		Email:    params.Email,
+		Password: hashed,
+	}
+	// The username may have been taken since it was checked.
+	if err := ds.SaveUser(parent, &user); err == store.ErrDuplicate {
+		return nil, ErrUsernameTaken
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	accountFailuresPrefix = "user:"
+	ipFailuresPrefix      = "ip:"
+
+	// ResetThrottlePrefix sets apart the password reset requests from the failed sign-ins.
+	ResetThrottlePrefix = "reset:"
+
+	// maxBackoffShift keeps the backoff from overflowing, the lockout caps it long before.
+	maxBackoffShift = 30
+)
+
+// ThrottleError is returned when the sign-ins, or the other throttled requests, of a username or
+// from a client IP address are throttled after too many failures.
+type ThrottleError struct {
+	// RetryAfter is how long to wait before signing in again.
+	RetryAfter time.Duration
+}
+
+func (te *ThrottleError) Error() string {
+	return fmt.Sprintf("too many attempts, retry after %s", te.RetryAfter)
+}
+
+// ThrottleOptions are the limits on the failed sign-ins. A zero limit disables it.
+type ThrottleOptions struct {
+	// FreeFailures is the number of failures of a username before every further failure doubles the
+	// wait, starting from a second, up to the lockout.
+	FreeFailures int
+	// MaxFailures is the number of failures that lock out a username.
+	MaxFailures int
+	// MaxIPFailures is the number of failures that lock out a client IP address.
+	MaxIPFailures int
+	// Lockout is how long a lockout lasts. The failures are forgotten once the lockout has passed
+	// since the last one.
+	Lockout time.Duration
+	// Prefix sets apart the failures of another kind of request, eg. the password reset requests, from
+	// the failed sign-ins, which have no prefix.
+	Prefix string
+}
+
+// SignInThrottle slows down password guessing by tracking the failed sign-ins per username and per
+// client IP address in the data store. The sign-ins of a username back off exponentially and are
+// locked out after too many failures, and so are the sign-ins from an IP address that fails too
+// often, eg. when guessing the passwords of many users.
+type SignInThrottle struct {
+	ds   store.DataStore
+	opts ThrottleOptions
+}
+
+func NewSignInThrottle(ds store.DataStore, opts ThrottleOptions) *SignInThrottle {
+	return &SignInThrottle{ds: ds, opts: opts}
+}
+
+// accountFailuresID returns the id of the failures of the username, which is normalized as the data
+// stores normalize it, so that the variants of a username share the failures.
+func accountFailuresID(username string) string {
+	return accountFailuresPrefix + store.NormalizeUsername(username)
+}
+
+func ipFailuresID(ip string) string {
+	return ipFailuresPrefix + ip
+}
+
+// throttleLimit is the limit on the failures of a username or of a client IP address.
+type throttleLimit struct {
+	id        string
+	free, max int
+}
+
+func (st *SignInThrottle) limits(username, ip string) []throttleLimit {
+	return []throttleLimit{
+		{st.opts.Prefix + accountFailuresID(username), st.opts.FreeFailures, st.opts.MaxFailures},
+		{st.opts.Prefix + ipFailuresID(ip), 0, st.opts.MaxIPFailures},
+	}
+}
+
+// Attempt counts an attempt to sign in as the username from the IP address as a failure upfront, so
+// that concurrent attempts can't get past the limits. A successful attempt is released by Succeed,
+// and one that turns out not to be a failure, eg. a correct password that must be completed with the
+// second factor, by Release. It returns a ThrottleError if the sign-ins are throttled, in which case
+// the attempt isn't counted.
+func (st *SignInThrottle) Attempt(parent context.Context, username, ip string) error {
+	limits := st.limits(username, ip)
+
+	// Hold off before counting the attempt, so that the throttled attempts don't extend the wait.
+	now := time.Now()
+	seen := make([]int, len(limits))
+	var retryAfter time.Duration
+	for i, limit := range limits {
+		sf, err := st.ds.GetSignInFailures(parent, limit.id)
+		if err == store.ErrorNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// The data store may keep the failures for a while after they expire.
+		if now.After(sf.ExpireAt) {
+			if err := st.ds.RemoveSignInFailures(parent, limit.id); err != nil {
+				return err
+			}
+			continue
+		}
+
+		seen[i] = sf.Count
+		if wait := st.blockedUntil(sf, limit.free, limit.max).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &ThrottleError{RetryAfter: retryAfter}
+	}
+
+	// The attempts counted by the other requests since the failures were read are waited for like
+	// failures.
+	for i, limit := range limits {
+		sf, err := st.ds.AddSignInFailure(parent, limit.id, now, now.Add(st.opts.Lockout))
+		if err != nil {
+			st.release(parent, limits[:i])
+			return err
+		}
+
+		if prior := sf.Count - 1; prior > seen[i] {
+			if wait := st.wait(prior, limit.free, limit.max); wait > 0 {
+				st.release(parent, limits[:i+1])
+				return &ThrottleError{RetryAfter: wait}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Succeed forgets the failed sign-ins of the username, but only releases the attempt from the IP
+// address, so that an attacker can't reset the count by signing in to their own account.
+func (st *SignInThrottle) Succeed(parent context.Context, username, ip string) {
+	if err := st.ds.RemoveSignInFailures(parent, st.opts.Prefix+accountFailuresID(username)); err != nil {
+		log.Printf("failed to reset the failed sign-ins of %s: %v\n", username, err)
+	}
+	st.release(parent, st.limits(username, ip)[1:])
+}
+
+// Release releases the attempt of the username from the IP address, which isn't counted as a failure.
+func (st *SignInThrottle) Release(parent context.Context, username, ip string) {
+	st.release(parent, st.limits(username, ip))
+}
+
+func (st *SignInThrottle) release(parent context.Context, limits []throttleLimit) {
+	for _, limit := range limits {
+		if err := st.ds.ReleaseSignInFailure(parent, limit.id); err != nil {
+			log.Printf("failed to release the attempt of %s: %v\n", limit.id, err)
+		}
+	}
+}
+
+// blockedUntil returns the time until which the sign-ins are blocked after the failures.
+func (st *SignInThrottle) blockedUntil(sf *models.SignInFailures, free, max int) time.Time {
+	if time.Now().After(sf.ExpireAt) {
+		return time.Time{}
+	}
+
+	wait := st.wait(sf.Count, free, max)
+	if wait == 0 {
+		return time.Time{}
+	}
+
+	return sf.LastFailureAt.Add(wait)
+}
+
+// wait returns how long the sign-ins wait after count failures. The sign-ins back off after free
+// failures, if not 0, and are locked out after max failures, if not 0.
+func (st *SignInThrottle) wait(count, free, max int) time.Duration {
+	if max > 0 && count >= max {
+		return st.opts.Lockout
+	}
+	if free == 0 || count <= free {
+		return 0
+	}
+
+	backoff := st.opts.Lockout
+	if shift := count - free - 1; shift < maxBackoffShift {
+		if b := time.Second << uint(shift); b < backoff {
+			backoff = b
+		}
+	}
+
+	return backoff
+}
+
+// throttlePrefixes are the prefixes of the kinds of requests that are throttled.
+var throttlePrefixes = []string{"", ResetThrottlePrefix}
+
+// UnlockAccount forgets the failed sign-ins and the password reset requests of the username, which
+// lifts its lockout.
+func UnlockAccount(parent context.Context, ds store.DataStore, username string) error {
+	return unlock(parent, ds, accountFailuresID(username))
+}
+
+// UnlockIP forgets the failed sign-ins and the password reset requests from the IP address, which
+// lifts its lockout.
+func UnlockIP(parent context.Context, ds store.DataStore, ip string) error {
+	return unlock(parent, ds, ipFailuresID(ip))
+}
+
+func unlock(parent context.Context, ds store.DataStore, id string) error {
+	for _, prefix := range throttlePrefixes {
+		if err := ds.RemoveSignInFailures(parent, prefix+id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/memory"
+)
+
+// failureStore is a data store that only keeps the failed sign-ins.
+type failureStore struct {
+	store.DataStore
+
+	failures map[string]models.SignInFailures
+}
+
+func (fs *failureStore) GetSignInFailures(_ context.Context, id string) (*models.SignInFailures, error) {
+	sf, ok := fs.failures[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+
+	return &sf, nil
+}
+
+func (fs *failureStore) AddSignInFailure(_ context.Context, id string, at, expireAt time.Time) (*models.SignInFailures, error) {
+	sf := fs.failures[id]
+	sf.ID = id
+	sf.Count++
+	sf.LastFailureAt = at
+	sf.ExpireAt = expireAt
+	fs.failures[id] = sf
+
+	return &sf, nil
+}
+
+func (fs *failureStore) ReleaseSignInFailure(_ context.Context, id string) error {
+	if sf, ok := fs.failures[id]; ok && sf.Count > 0 {
+		sf.Count--
+		fs.failures[id] = sf
+	}
+
+	return nil
+}
+
+func (fs *failureStore) RemoveSignInFailures(_ context.Context, id string) error {
+	delete(fs.failures, id)
+
+	return nil
+}
+
+// backdate moves the last failure of the id back by d, as if d has passed.
+func (fs *failureStore) backdate(id string, d time.Duration) {
+	sf := fs.failures[id]
+	sf.LastFailureAt = sf.LastFailureAt.Add(-d)
+	fs.failures[id] = sf
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	terr, ok := err.(*ThrottleError)
+	require.True(t, ok, "expected a ThrottleError, got %v", err)
+
+	return terr.RetryAfter
+}
+
+func Test_SignInThrottle_Account(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	throttle := NewSignInThrottle(ds, ThrottleOptions{FreeFailures: 2, MaxFailures: 5, Lockout: time.Hour})
+	id := accountFailuresID("chan")
+
+	// The free failures aren't throttled.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	}
+
+	// The wait doubles with every further failure, and the throttled attempts aren't counted.
+	assert.InDelta(t, time.Second, retryAfter(t, throttle.Attempt(ctx, "chan", "10.0.0.2")), float64(time.Second))
+	ds.backdate(id, time.Second)
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	assert.InDelta(t, 2*time.Second, retryAfter(t, throttle.Attempt(ctx, "chan", "10.0.0.2")), float64(time.Second))
+	assert.Equal(t, 4, ds.failures[id].Count)
+	assert.NoError(t, throttle.Attempt(ctx, "john", "10.0.0.1"))
+
+	// Then the username is locked out until it's unlocked.
+	ds.backdate(id, 2*time.Second)
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	assert.InDelta(t, time.Hour, retryAfter(t, throttle.Attempt(ctx, "chan", "10.0.0.2")), float64(time.Second))
+	require.NoError(t, UnlockAccount(ctx, ds, "chan"))
+	assert.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.2"))
+}
+
+func Test_SignInThrottle_IP(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	throttle := NewSignInThrottle(ds, ThrottleOptions{FreeFailures: 2, MaxIPFailures: 3, Lockout: time.Hour})
+
+	// Signing in successfully doesn't reset the failures from the IP address.
+	require.NoError(t, throttle.Attempt(ctx, "admin", "10.0.0.1"))
+	throttle.Succeed(ctx, "admin", "10.0.0.1")
+	for _, username := range []string{"chan", "john", "patel"} {
+		require.NoError(t, throttle.Attempt(ctx, username, "10.0.0.1"))
+	}
+	assert.InDelta(t, time.Hour, retryAfter(t, throttle.Attempt(ctx, "admin", "10.0.0.1")), float64(time.Second))
+	assert.NoError(t, throttle.Attempt(ctx, "admin", "10.0.0.2"))
+	require.NoError(t, UnlockIP(ctx, ds, "10.0.0.1"))
+	assert.NoError(t, throttle.Attempt(ctx, "admin", "10.0.0.1"))
+}
+
+func Test_SignInThrottle_Prefix(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	opts := ThrottleOptions{MaxFailures: 1, Lockout: time.Hour}
+	signIns := NewSignInThrottle(ds, opts)
+	opts.Prefix = ResetThrottlePrefix
+	resets := NewSignInThrottle(ds, opts)
+
+	require.NoError(t, resets.Attempt(ctx, "chan", "10.0.0.1"))
+
+	// The password reset requests don't throttle the sign-ins.
+	assert.InDelta(t, time.Hour, retryAfter(t, resets.Attempt(ctx, "chan", "10.0.0.2")), float64(time.Second))
+	assert.NoError(t, signIns.Attempt(ctx, "chan", "10.0.0.2"))
+	require.NoError(t, UnlockAccount(ctx, ds, "chan"))
+	assert.NoError(t, resets.Attempt(ctx, "chan", "10.0.0.2"))
+}
+
+func Test_SignInThrottle_Expired(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	throttle := NewSignInThrottle(ds, ThrottleOptions{MaxFailures: 1, Lockout: time.Hour})
+	ds.failures[accountFailuresID("chan")] = models.SignInFailures{
+		ID:            accountFailuresID("chan"),
+		Count:         5,
+		LastFailureAt: time.Now().Add(-2 * time.Hour),
+		ExpireAt:      time.Now().Add(-time.Hour),
+	}
+
+	// The count starts over.
+	assert.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	assert.Equal(t, 1, ds.failures[accountFailuresID("chan")].Count)
+}
+
+func Test_SignInThrottle_Succeed(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	throttle := NewSignInThrottle(ds, ThrottleOptions{FreeFailures: 1, Lockout: time.Hour})
+
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	throttle.Succeed(ctx, "chan", "10.0.0.1")
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	assert.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+
+	// The successful attempt isn't counted from the IP address.
+	assert.Equal(t, 2, ds.failures[accountFailuresID("chan")].Count)
+	assert.Equal(t, 3, ds.failures[ipFailuresID("10.0.0.1")].Count)
+}
+
+func Test_SignInThrottle_Release(t *testing.T) {
+	ctx := context.Background()
+	ds := &failureStore{failures: map[string]models.SignInFailures{}}
+	throttle := NewSignInThrottle(ds, ThrottleOptions{MaxFailures: 1, MaxIPFailures: 1, Lockout: time.Hour})
+
+	// A released attempt isn't a failure.
+	require.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+	throttle.Release(ctx, "chan", "10.0.0.1")
+	assert.NoError(t, throttle.Attempt(ctx, "chan", "10.0.0.1"))
+}
+
+func Test_SignInThrottle_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	ds := memory.New()
+	throttle := NewSignInThrottle(ds, ThrottleOptions{FreeFailures: 3, MaxFailures: 5, Lockout: time.Hour})
+
+	// The attempts that run concurrently can't get past the free failures, nor the lockout.
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if throttle.Attempt(ctx, "chan", "10.0.0.1") == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, allowed, int32(4))
+	sf, err := ds.GetSignInFailures(ctx, accountFailuresID("chan"))
+	require.NoError(t, err)
+	assert.Equal(t, int(allowed), sf.Count)
+}
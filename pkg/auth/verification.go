@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	// verificationPurpose separates the MACs of the verification tokens from the other MACs made
+	// with the same secret.
+	verificationPurpose = "email-verification"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+	ErrEmailNotVerified         = errors.New("email address isn't verified")
+)
+
+func signVerificationPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(verificationPurpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewVerificationToken returns a token, signed with the secret, that verifies the email address of
+// the user until the expiry. The token is bound to the address, so it's void once the user changes
+// the address.
+func NewVerificationToken(secret string, user *models.User, expireAt time.Time) string {
+	payload := strings.Join([]string{user.ID, user.Email, strconv.FormatInt(expireAt.Unix(), 10)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encoded + "." + signVerificationPayload(secret, encoded)
+}
+
+// parseVerificationToken returns the user id and email address of a valid token.
+func parseVerificationToken(secret, token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", ErrInvalidVerificationToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(signVerificationPayload(secret, parts[0]))) {
+		return "", "", ErrInvalidVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", ErrInvalidVerificationToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 3 {
+		return "", "", ErrInvalidVerificationToken
+	}
+	exp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().After(time.Unix(exp, 0)) {
+		return "", "", ErrInvalidVerificationToken
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// VerifyEmail marks the email address of the user in the token as verified.
+func VerifyEmail(parent context.Context, ds store.DataStore, secret, token string) (*models.User, error) {
+	uid, email, err := parseVerificationToken(secret, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ds.GetUser(parent, uid)
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidVerificationToken
+	} else if err != nil {
+		return nil, err
+	}
+	if email == "" || user.Email != email {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if !user.EmailVerified {
+		err := updateUser(parent, ds, user, func(updated *models.User) error {
+			if updated.Email != email {
+				return ErrInvalidVerificationToken
+			}
+			updated.EmailVerified = true
+			return nil
+		})
+		if err == ErrUserNotFound {
+			return nil, ErrInvalidVerificationToken
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
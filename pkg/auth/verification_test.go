@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+)
+
+func Test_VerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Email: "chan@example.com"}
+	ds := &userStore{users: map[string]*models.User{"1": &user}}
+	token := NewVerificationToken("secret", &user, time.Now().Add(time.Hour))
+
+	// The token must be signed with the same secret.
+	_, err := VerifyEmail(ctx, ds, "other-secret", token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	_, err = VerifyEmail(ctx, ds, "secret", strings.Replace(token, ".", "x.", 1))
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	assert.False(t, ds.users["1"].EmailVerified)
+
+	verified, err := VerifyEmail(ctx, ds, "secret", token)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	assert.True(t, ds.users["1"].EmailVerified)
+}
+
+func Test_VerifyEmail_Expired(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Email: "chan@example.com"}
+	ds := &userStore{users: map[string]*models.User{"1": &user}}
+	token := NewVerificationToken("secret", &user, time.Now().Add(-time.Second))
+
+	_, err := VerifyEmail(ctx, ds, "secret", token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+}
+
+func Test_VerifyEmail_ChangedEmail(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Email: "chan@example.com"}
+	token := NewVerificationToken("secret", &user, time.Now().Add(time.Hour))
+	changed := user
+	changed.Email = "chan@example.org"
+	ds := &userStore{users: map[string]*models.User{"1": &changed}}
+
+	_, err := VerifyEmail(ctx, ds, "secret", token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	assert.False(t, ds.users["1"].EmailVerified)
+}
+
+func Test_VerifyEmail_Conflict(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan", Email: "chan@example.com"}
+	ds := newRacingStore(t, 1, &user)
+	token := NewVerificationToken("secret", &user, time.Now().Add(time.Hour))
+
+	verified, err := VerifyEmail(ctx, ds, "secret", token)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	assert.Equal(t, "blue", verified.Claims["team"])
+
+	// The email address that was changed meanwhile isn't verified.
+	other := models.User{ID: "2", Username: "lee", Email: "lee@example.com"}
+	require.NoError(t, ds.SaveUser(ctx, &other))
+	token = NewVerificationToken("secret", &other, time.Now().Add(time.Hour))
+	ds.races = 1
+	ds.change = func(user *models.User) {
+		user.Email = "lee@example.org"
+	}
+	_, err = VerifyEmail(ctx, ds, "secret", token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+}
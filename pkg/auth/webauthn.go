@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/webauthn"
+)
+
+const (
+	ceremonyRegistration = "registration"
+	ceremonySignIn       = "signin"
+
+	// defaultCredentialName names the credentials that the user hasn't named.
+	defaultCredentialName = "Passkey"
+)
+
+var (
+	ErrInvalidWebAuthnChallenge = errors.New("invalid or expired webauthn challenge")
+	ErrInvalidWebAuthnResponse  = errors.New("invalid webauthn response")
+	ErrUnknownWebAuthnCred      = errors.New("unknown webauthn credential")
+	ErrWebAuthnCredExists       = errors.New("webauthn credential is already registered")
+	ErrWebAuthnSignCount        = errors.New("webauthn sign count didn't increase, the authenticator may be cloned")
+)
+
+// WebAuthnOptions identify the relying party, ie. the server, to the authenticators.
+type WebAuthnOptions struct {
+	// RPID is the domain of the server, that the credentials are scoped to.
+	RPID   string
+	RPName string
+	// Origin is the origin of the web pages that run the ceremonies, eg. https://auth.example.com.
+	Origin string
+	// Timeout is how long the user has to complete a ceremony.
+	Timeout time.Duration
+}
+
+// WebAuthn runs the registration and the assertion ceremonies of the WebAuthn credentials, eg. the
+// passkeys of platform authenticators, which sign in the users without a password. The challenges of
+// the pending ceremonies and the credentials are saved in the data store.
+type WebAuthn struct {
+	ds   store.DataStore
+	opts WebAuthnOptions
+}
+
+func NewWebAuthn(ds store.DataStore, opts WebAuthnOptions) *WebAuthn {
+	return &WebAuthn{ds: ds, opts: opts}
+}
+
+// newSession saves the challenge of a ceremony of the user, if any.
+func (wa *WebAuthn) newSession(parent context.Context, uid, ceremony string) (webauthn.Bytes, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	ws := models.WebAuthnSession{
+		ID:       hashCode(challenge.String()),
+		UserID:   uid,
+		Ceremony: ceremony,
+		ExpireAt: time.Now().Add(wa.opts.Timeout),
+	}
+	if err := wa.ds.SaveWebAuthnSession(parent, &ws); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// verifyClientData checks the client data of the ceremony and uses up its challenge, so that a
+// response can't be replayed. It returns the session of the challenge.
+func (wa *WebAuthn) verifyClientData(parent context.Context, data []byte, typ, ceremony string) (*models.WebAuthnSession, error) {
+	cd, err := webauthn.ParseClientData(data)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if err := cd.Check(typ, wa.opts.Origin); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	ws, err := wa.ds.ConsumeWebAuthnSession(parent, hashCode(cd.Challenge))
+	if err == store.ErrorNotFound {
+		return nil, ErrInvalidWebAuthnChallenge
+	} else if err != nil {
+		return nil, err
+	}
+	if ws.Ceremony != ceremony || time.Now().After(ws.ExpireAt) {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	return ws, nil
+}
+
+// verifyAuthData checks that the authenticator data is scoped to the relying party and that the user
+// was present.
+func (wa *WebAuthn) verifyAuthData(ad *webauthn.AuthenticatorData) error {
+	if !ad.MatchesRPID(wa.opts.RPID) || !ad.UserPresent() {
+		return ErrInvalidWebAuthnResponse
+	}
+
+	return nil
+}
+
+func (wa *WebAuthn) timeoutMillis() int64 {
+	return wa.opts.Timeout.Milliseconds()
+}
+
+// Credentials returns the credentials of the user.
+func (wa *WebAuthn) Credentials(parent context.Context, uid string) ([]*models.WebAuthnCredential, error) {
+	return wa.ds.GetWebAuthnCredentialsByUser(parent, uid)
+}
+
+// RemoveCredential removes a credential of the user.
+func (wa *WebAuthn) RemoveCredential(parent context.Context, uid, id string) error {
+	cred, err := wa.ds.GetWebAuthnCredential(parent, id)
+	if err == store.ErrorNotFound || (err == nil && cred.UserID != uid) {
+		return ErrUnknownWebAuthnCred
+	} else if err != nil {
+		return err
+	}
+
+	return wa.ds.RemoveWebAuthnCredential(parent, id)
+}
+
+// BeginRegistration starts the registration of a discoverable credential of the user and returns the
+// options for navigator.credentials.create(). The credentials already registered are excluded, so
+// that an authenticator isn't registered twice.
+func (wa *WebAuthn) BeginRegistration(parent context.Context, user *models.User) (*webauthn.CreationOptions, error) {
+	creds, err := wa.ds.GetWebAuthnCredentialsByUser(parent, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := wa.newSession(parent, user.ID, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = user.Username
+	}
+	options := webauthn.CreationOptions{
+		RP: webauthn.RelyingParty{ID: wa.opts.RPID, Name: wa.opts.RPName},
+		// The user handle of the assertions is the user id.
+		User:      webauthn.UserEntity{ID: webauthn.Bytes(user.ID), Name: user.Username, DisplayName: displayName},
+		Challenge: challenge,
+		Timeout:   wa.timeoutMillis(),
+		AuthenticatorSelection: webauthn.AuthenticatorSelection{
+			ResidentKey:        webauthn.ResidentKeyRequired,
+			RequireResidentKey: true,
+			UserVerification:   webauthn.UserVerificationPreferred,
+		},
+		Attestation: webauthn.AttestationNone,
+	}
+	for _, alg := range webauthn.SupportedAlgs {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, webauthn.CredentialParameter{
+			Type: webauthn.CredentialType,
+			Alg:  alg,
+		})
+	}
+	for _, cred := range creds {
+		id, err := base64.RawURLEncoding.DecodeString(cred.ID)
+		if err != nil {
+			continue
+		}
+		options.ExcludeCredentials = append(options.ExcludeCredentials, webauthn.CredentialDescriptor{
+			Type: webauthn.CredentialType,
+			ID:   id,
+		})
+	}
+
+	return &options, nil
+}
+
+// FinishRegistration verifies the credential created by the authenticator for the user, and saves it
+// with the name, if any.
+func (wa *WebAuthn) FinishRegistration(parent context.Context, user *models.User, name string,
+	resp *webauthn.RegistrationResponse) (*models.WebAuthnCredential, error) {
+	ws, err := wa.verifyClientData(parent, resp.Response.ClientDataJSON, webauthn.TypeCreate, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if ws.UserID != user.ID {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	ad, err := webauthn.ParseAttestationObject(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if err := wa.verifyAuthData(ad); err != nil {
+		return nil, err
+	}
+	if resp.Type != webauthn.CredentialType || !bytes.Equal(ad.CredentialID, resp.RawID) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if _, err := webauthn.ParsePublicKey(ad.PublicKey); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	id := webauthn.Bytes(ad.CredentialID).String()
+	if _, err := wa.ds.GetWebAuthnCredential(parent, id); err == nil {
+		return nil, ErrWebAuthnCredExists
+	} else if err != store.ErrorNotFound {
+		return nil, err
+	}
+
+	if name == "" {
+		name = defaultCredentialName
+	}
+	cred := models.WebAuthnCredential{
+		ID:        id,
+		UserID:    user.ID,
+		Name:      name,
+		PublicKey: ad.PublicKey,
+		SignCount: ad.SignCount,
+		CreatedAt: time.Now(),
+	}
+	if err := wa.ds.SaveWebAuthnCredential(parent, &cred); err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// BeginSignIn starts a sign-in with a discoverable credential and returns the options for
+// navigator.credentials.get(). The user is only known from the credential of the assertion.
+func (wa *WebAuthn) BeginSignIn(parent context.Context) (*webauthn.RequestOptions, error) {
+	challenge, err := wa.newSession(parent, "", ceremonySignIn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.RequestOptions{
+		Challenge:        challenge,
+		Timeout:          wa.timeoutMillis(),
+		RPID:             wa.opts.RPID,
+		UserVerification: webauthn.UserVerificationPreferred,
+	}, nil
+}
+
+// FinishSignIn verifies the assertion signed by the authenticator and returns the user of the
+// credential, and whether the authenticator verified the user, eg. with a biometric or a PIN. The sign
+// count of the credential must increase, unless the authenticator doesn't count the signatures, to
+// detect a cloned authenticator.
+func (wa *WebAuthn) FinishSignIn(parent context.Context,
+	resp *webauthn.AuthenticationResponse) (*models.User, bool, error) {
+	if _, err := wa.verifyClientData(parent, resp.Response.ClientDataJSON, webauthn.TypeGet, ceremonySignIn); err != nil {
+		return nil, false, err
+	}
+	if resp.Type != webauthn.CredentialType {
+		return nil, false, ErrInvalidWebAuthnResponse
+	}
+
+	cred, err := wa.ds.GetWebAuthnCredential(parent, resp.RawID.String())
+	if err == store.ErrorNotFound {
+		return nil, false, ErrUnknownWebAuthnCred
+	} else if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Response.UserHandle) > 0 && string(resp.Response.UserHandle) != cred.UserID {
+		return nil, false, ErrUnknownWebAuthnCred
+	}
+
+	ad, err := webauthn.ParseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, false, ErrInvalidWebAuthnResponse
+	}
+	if err := wa.verifyAuthData(ad); err != nil {
+		return nil, false, err
+	}
+	key, err := webauthn.ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := key.Verify(resp.Response.SignedData(), resp.Response.Signature); err != nil {
+		return nil, false, ErrInvalidWebAuthnResponse
+	}
+
+	err = wa.ds.UpdateWebAuthnSignCount(parent, cred.ID, ad.SignCount, time.Now())
+	if err == store.ErrorNotFound {
+		return nil, false, ErrWebAuthnSignCount
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	user, err := wa.ds.GetUser(parent, cred.UserID)
+	if err == store.ErrorNotFound {
+		return nil, false, ErrUnknownWebAuthnCred
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return user, ad.UserVerified(), nil
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/webauthn/webauthntest"
+)
+
+const (
+	testRPID   = "auth.example.com"
+	testOrigin = "https://auth.example.com"
+)
+
+// webAuthnStore is a data store that keeps users, WebAuthn sessions and credentials.
+type webAuthnStore struct {
+	userStore
+
+	sessions    map[string]*models.WebAuthnSession
+	credentials map[string]*models.WebAuthnCredential
+}
+
+func (ws *webAuthnStore) SaveWebAuthnSession(_ context.Context, session *models.WebAuthnSession) error {
+	ws.sessions[session.ID] = session
+	return nil
+}
+
+func (ws *webAuthnStore) ConsumeWebAuthnSession(_ context.Context, id string) (*models.WebAuthnSession, error) {
+	session, ok := ws.sessions[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	delete(ws.sessions, id)
+
+	return session, nil
+}
+
+func (ws *webAuthnStore) GetWebAuthnCredential(_ context.Context, id string) (*models.WebAuthnCredential, error) {
+	cred, ok := ws.credentials[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	copied := *cred
+
+	return &copied, nil
+}
+
+func (ws *webAuthnStore) GetWebAuthnCredentialsByUser(_ context.Context, uid string) ([]*models.WebAuthnCredential, error) {
+	var creds []*models.WebAuthnCredential
+	for _, cred := range ws.credentials {
+		if cred.UserID == uid {
+			creds = append(creds, cred)
+		}
+	}
+
+	return creds, nil
+}
+
+func (ws *webAuthnStore) SaveWebAuthnCredential(_ context.Context, cred *models.WebAuthnCredential) error {
+	ws.credentials[cred.ID] = cred
+	return nil
+}
+
+func (ws *webAuthnStore) UpdateWebAuthnSignCount(_ context.Context, id string, signCount uint32, usedAt time.Time) error {
+	cred, ok := ws.credentials[id]
+	if !ok || (signCount <= cred.SignCount && (signCount != 0 || cred.SignCount != 0)) {
+		return store.ErrorNotFound
+	}
+	cred.SignCount = signCount
+	cred.LastUsedAt = usedAt
+
+	return nil
+}
+
+func (ws *webAuthnStore) RemoveWebAuthnCredential(_ context.Context, id string) error {
+	delete(ws.credentials, id)
+	return nil
+}
+
+func newTestWebAuthn(users ...*models.User) (*WebAuthn, *webAuthnStore) {
+	ws := webAuthnStore{
+		userStore:   userStore{users: map[string]*models.User{}},
+		sessions:    map[string]*models.WebAuthnSession{},
+		credentials: map[string]*models.WebAuthnCredential{},
+	}
+	for _, user := range users {
+		ws.users[user.ID] = user
+	}
+
+	wa := NewWebAuthn(&ws, WebAuthnOptions{
+		RPID:    testRPID,
+		RPName:  "authx",
+		Origin:  testOrigin,
+		Timeout: time.Minute,
+	})
+
+	return wa, &ws
+}
+
+// registerCredential registers a credential of the authenticator for the user.
+func registerCredential(t *testing.T, wa *WebAuthn, authn *webauthntest.Authenticator,
+	user *models.User) *models.WebAuthnCredential {
+	ctx := context.Background()
+	options, err := wa.BeginRegistration(ctx, user)
+	require.NoError(t, err)
+	resp, err := authn.Register(options)
+	require.NoError(t, err)
+	cred, err := wa.FinishRegistration(ctx, user, "", resp)
+	require.NoError(t, err)
+
+	return cred
+}
+
+func Test_WebAuthn_Registration(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, ws := newTestWebAuthn(&user)
+	authn := webauthntest.New(testRPID, testOrigin)
+
+	options, err := wa.BeginRegistration(ctx, &user)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(user.ID), []byte(options.User.ID))
+	assert.Equal(t, "chan", options.User.DisplayName)
+	resp, err := authn.Register(options)
+	require.NoError(t, err)
+
+	// The response is only accepted for the user of the challenge.
+	_, err = wa.FinishRegistration(ctx, &models.User{ID: "2"}, "", resp)
+	assert.Equal(t, ErrInvalidWebAuthnChallenge, err)
+
+	options, err = wa.BeginRegistration(ctx, &user)
+	require.NoError(t, err)
+	resp, err = authn.Register(options)
+	require.NoError(t, err)
+	cred, err := wa.FinishRegistration(ctx, &user, "Laptop", resp)
+	require.NoError(t, err)
+	assert.Equal(t, resp.ID, cred.ID)
+	assert.Equal(t, "Laptop", cred.Name)
+	assert.Contains(t, ws.credentials, cred.ID)
+
+	// The challenge can only be used once.
+	_, err = wa.FinishRegistration(ctx, &user, "", resp)
+	assert.Equal(t, ErrInvalidWebAuthnChallenge, err)
+
+	// The registered credentials are excluded.
+	options, err = wa.BeginRegistration(ctx, &user)
+	require.NoError(t, err)
+	require.Len(t, options.ExcludeCredentials, 1)
+	_, err = authn.Register(options)
+	assert.Equal(t, webauthntest.ErrCredentialExcluded, err)
+}
+
+func Test_WebAuthn_Registration_WrongOrigin(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, _ := newTestWebAuthn(&user)
+
+	tests := []*webauthntest.Authenticator{
+		webauthntest.New(testRPID, "https://evil.example.com"),
+		webauthntest.New("example.com", testOrigin),
+	}
+	for _, authn := range tests {
+		options, err := wa.BeginRegistration(ctx, &user)
+		require.NoError(t, err)
+		options.RP.ID = ""
+		resp, err := authn.Register(options)
+		require.NoError(t, err)
+		_, err = wa.FinishRegistration(ctx, &user, "", resp)
+		assert.Equal(t, ErrInvalidWebAuthnResponse, err)
+	}
+}
+
+func Test_WebAuthn_SignIn(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, ws := newTestWebAuthn(&user)
+	authn := webauthntest.New(testRPID, testOrigin)
+	cred := registerCredential(t, wa, authn, &user)
+
+	options, err := wa.BeginSignIn(ctx)
+	require.NoError(t, err)
+	resp, err := authn.Assert(options)
+	require.NoError(t, err)
+	got, uv, err := wa.FinishSignIn(ctx, resp)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, got.ID)
+	assert.False(t, uv)
+	assert.Equal(t, uint32(1), ws.credentials[cred.ID].SignCount)
+
+	// The assertion can't be replayed.
+	_, _, err = wa.FinishSignIn(ctx, resp)
+	assert.Equal(t, ErrInvalidWebAuthnChallenge, err)
+
+	// The user verification is reported.
+	authn.UserVerified = true
+	options, err = wa.BeginSignIn(ctx)
+	require.NoError(t, err)
+	resp, err = authn.Assert(options)
+	require.NoError(t, err)
+	_, uv, err = wa.FinishSignIn(ctx, resp)
+	require.NoError(t, err)
+	assert.True(t, uv)
+}
+
+func Test_WebAuthn_SignIn_InvalidSignature(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, _ := newTestWebAuthn(&user)
+	authn := webauthntest.New(testRPID, testOrigin)
+	registerCredential(t, wa, authn, &user)
+
+	options, err := wa.BeginSignIn(ctx)
+	require.NoError(t, err)
+	resp, err := authn.Assert(options)
+	require.NoError(t, err)
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+
+	_, _, err = wa.FinishSignIn(ctx, resp)
+	assert.Equal(t, ErrInvalidWebAuthnResponse, err)
+}
+
+func Test_WebAuthn_SignIn_ClonedAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, _ := newTestWebAuthn(&user)
+	authn := webauthntest.New(testRPID, testOrigin)
+	registerCredential(t, wa, authn, &user)
+	clone := authn.Clone()
+
+	signIn := func(authn *webauthntest.Authenticator) error {
+		options, err := wa.BeginSignIn(ctx)
+		require.NoError(t, err)
+		resp, err := authn.Assert(options)
+		require.NoError(t, err)
+		_, _, err = wa.FinishSignIn(ctx, resp)
+
+		return err
+	}
+
+	assert.NoError(t, signIn(authn))
+	// The clone signs with the same count as the original did.
+	assert.Equal(t, ErrWebAuthnSignCount, signIn(clone))
+	assert.NoError(t, signIn(authn))
+}
+
+func Test_WebAuthn_RemoveCredential(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "1", Username: "chan"}
+	wa, ws := newTestWebAuthn(&user)
+	authn := webauthntest.New(testRPID, testOrigin)
+	cred := registerCredential(t, wa, authn, &user)
+
+	assert.Equal(t, ErrUnknownWebAuthnCred, wa.RemoveCredential(ctx, "2", cred.ID))
+	require.NoError(t, wa.RemoveCredential(ctx, user.ID, cred.ID))
+	assert.NotContains(t, ws.credentials, cred.ID)
+
+	// The removed credential can't sign in.
+	options, err := wa.BeginSignIn(ctx)
+	require.NoError(t, err)
+	resp, err := authn.Assert(options)
+	require.NoError(t, err)
+	_, _, err = wa.FinishSignIn(ctx, resp)
+	assert.Equal(t, ErrUnknownWebAuthnCred, err)
+}
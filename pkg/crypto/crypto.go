@@ -1,22 +1,170 @@
 package crypto
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	pwdHashLen       = 64
-	pwdHashIteration = 8
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+
+	// Parameters of the legacy hashes, which are hex-encoded and salted separately.
+	legacyHashLen       = 64
+	legacyHashIteration = 8
+)
+
+var (
+	ErrUnsupportedHashAlgorithm = errors.New("unsupported password hash algorithm")
+	ErrInvalidHash              = errors.New("invalid password hash")
 )
 
-func HashString(str, salt string) string {
-	var hashed []byte
-	textData := []byte(str)
-	saltData := []byte(salt)
-	hashed = pbkdf2.Key(textData, saltData, 1<<pwdHashIteration, pwdHashLen, sha256.New)
+// PasswordParams are the algorithm and cost for hashing passwords.
+type PasswordParams struct {
+	// Algorithm is either AlgorithmArgon2id or AlgorithmBcrypt.
+	Algorithm string
+	// Argon2Time is the number of passes over the memory.
+	Argon2Time uint32
+	// Argon2Memory is the memory used in KiB.
+	Argon2Memory  uint32
+	Argon2Threads uint8
+	BcryptCost    int
+}
+
+// argon2Hash represents an argon2id hash in the PHC string format, eg.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2Hash struct {
+	version uint32
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (ah *argon2Hash) String() string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", AlgorithmArgon2id, ah.version, ah.memory, ah.time,
+		ah.threads, base64.RawStdEncoding.EncodeToString(ah.salt), base64.RawStdEncoding.EncodeToString(ah.key))
+}
+
+func parseArgon2Hash(encoded string) (*argon2Hash, error) {
+	// The first field is empty as the string starts with $.
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[1] != AlgorithmArgon2id {
+		return nil, ErrInvalidHash
+	}
+
+	var ah argon2Hash
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &ah.version); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &ah.memory, &ah.time, &ah.threads); err != nil {
+		return nil, ErrInvalidHash
+	}
+
+	var err error
+	if ah.salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if ah.key, err = base64.RawStdEncoding.DecodeString(fields[5]); err != nil {
+		return nil, ErrInvalidHash
+	}
+
+	return &ah, nil
+}
+
+// HashPassword returns the self-describing hash of the password, which embeds the algorithm, the
+// parameters and the salt.
+func HashPassword(password string, params *PasswordParams) (string, error) {
+	switch params.Algorithm {
+	case AlgorithmArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		ah := argon2Hash{
+			version: argon2.Version,
+			memory:  params.Argon2Memory,
+			time:    params.Argon2Time,
+			threads: params.Argon2Threads,
+			salt:    salt,
+		}
+		ah.key = argon2.IDKey([]byte(password), salt, ah.time, ah.memory, ah.threads, argon2KeyLen)
+
+		return ah.String(), nil
+	case AlgorithmBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hashed), nil
+	}
+
+	return "", ErrUnsupportedHashAlgorithm
+}
+
+// VerifyPassword returns true if the password matches the hash. The salt is only used by the legacy
+// hashes, which predate the self-describing hashes.
+func VerifyPassword(encoded, password, salt string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$"+AlgorithmArgon2id+"$"):
+		ah, err := parseArgon2Hash(encoded)
+		if err != nil || ah.version != argon2.Version {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), ah.salt, ah.time, ah.memory, ah.threads, uint32(len(ah.key)))
+
+		return subtle.ConstantTimeCompare(ah.key, key) == 1
+	case strings.HasPrefix(encoded, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(legacyHash(password, salt))) == 1
+}
+
+// NeedsRehash returns true if the hash isn't using the algorithm and parameters, eg. the cost is
+// raised, so the password should be hashed again when it's known ie. on a successful sign-in.
+func NeedsRehash(encoded string, params *PasswordParams) bool {
+	switch params.Algorithm {
+	case AlgorithmArgon2id:
+		ah, err := parseArgon2Hash(encoded)
+		if err != nil {
+			return true
+		}
+
+		return ah.version != argon2.Version || ah.memory != params.Argon2Memory ||
+			ah.time != params.Argon2Time || ah.threads != params.Argon2Threads
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return true
+		}
+
+		return cost != params.BcryptCost
+	}
+
+	return false
+}
+
+// legacyHash is the PBKDF2-SHA256 hash used before the self-describing hashes, whose salt is kept
+// apart from the hash. It's only kept for verifying the existing hashes.
+func legacyHash(str, salt string) string {
+	hashed := pbkdf2.Key([]byte(str), []byte(salt), 1<<legacyHashIteration, legacyHashLen, sha256.New)
 
 	return hex.EncodeToString(hashed)
 }
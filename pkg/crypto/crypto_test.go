@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testParams(alg string) *PasswordParams {
+	return &PasswordParams{
+		Algorithm:     alg,
+		Argon2Time:    1,
+		Argon2Memory:  64,
+		Argon2Threads: 1,
+		BcryptCost:    4,
+	}
+}
+
+func Test_HashPassword_Argon2id(t *testing.T) {
+	params := testParams(AlgorithmArgon2id)
+	hashed, err := HashPassword("mypassword", params)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hashed, "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	assert.True(t, VerifyPassword(hashed, "mypassword", ""))
+	assert.False(t, VerifyPassword(hashed, "wrong", ""))
+	assert.False(t, NeedsRehash(hashed, params))
+
+	// Every hash has its own salt.
+	other, err := HashPassword("mypassword", params)
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, other)
+}
+
+func Test_HashPassword_Bcrypt(t *testing.T) {
+	params := testParams(AlgorithmBcrypt)
+	hashed, err := HashPassword("mypassword", params)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hashed, "$2a$04$"))
+
+	assert.True(t, VerifyPassword(hashed, "mypassword", ""))
+	assert.False(t, VerifyPassword(hashed, "wrong", ""))
+	assert.False(t, NeedsRehash(hashed, params))
+}
+
+func Test_HashPassword_UnsupportedAlgorithm(t *testing.T) {
+	_, err := HashPassword("mypassword", testParams("md5"))
+	assert.Equal(t, ErrUnsupportedHashAlgorithm, err)
+}
+
+func Test_VerifyPassword_Legacy(t *testing.T) {
+	// PBKDF2-SHA256 hash of "mypassword" with the salt "salt".
+	hashed := "ce176b09ef0c03b781314ba461cf986e69f39f151a58d9c2631bcbf5c223e838" +
+		"9f20028a0c89eadf6d7b0a0a0d7c602fdac1347f1a95d8160448be91227ea3b6"
+
+	assert.True(t, VerifyPassword(hashed, "mypassword", "salt"))
+	assert.False(t, VerifyPassword(hashed, "mypassword", "pepper"))
+	assert.False(t, VerifyPassword(hashed, "wrong", "salt"))
+	assert.True(t, NeedsRehash(hashed, testParams(AlgorithmArgon2id)))
+	assert.True(t, NeedsRehash(hashed, testParams(AlgorithmBcrypt)))
+}
+
+func Test_VerifyPassword_InvalidHash(t *testing.T) {
+	assert.False(t, VerifyPassword("$argon2id$v=19$m=64,t=1,p=1$notbase64!$", "mypassword", ""))
+	assert.False(t, VerifyPassword("", "mypassword", ""))
+}
+
+func Test_NeedsRehash(t *testing.T) {
+	params := testParams(AlgorithmArgon2id)
+	hashed, err := HashPassword("mypassword", params)
+	require.NoError(t, err)
+
+	// Raising the cost or switching the algorithm outdates the hash.
+	raised := *params
+	raised.Argon2Time = 2
+	assert.True(t, NeedsRehash(hashed, &raised))
+	assert.True(t, NeedsRehash(hashed, testParams(AlgorithmBcrypt)))
+
+	bcrypted, err := HashPassword("mypassword", testParams(AlgorithmBcrypt))
+	require.NoError(t, err)
+	raised = *testParams(AlgorithmBcrypt)
+	raised.BcryptCost = 5
+	assert.True(t, NeedsRehash(bcrypted, &raised))
+	assert.True(t, NeedsRehash(bcrypted, params))
+}
@@ -0,0 +1,119 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	filePerm = 0600
+)
+
+var (
+	// tokenRegexp matches the token in the query of a link, eg. of a password reset.
+	tokenRegexp = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+)
+
+// Message is a plain text email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Bytes returns the message in the RFC 5322 format.
+func (m *Message) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(m.Body)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server. The connection is upgraded with STARTTLS if the
+// server supports it.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer returns a mailer that sends emails through the SMTP server at the address, eg.
+// smtp.example.com:587. The PLAIN authentication is used if the username isn't empty.
+func NewSMTPMailer(addr, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address %s: %v", addr, err)
+	}
+
+	mailer := SMTPMailer{addr: addr}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &mailer, nil
+}
+
+func (sm *SMTPMailer) Send(_ context.Context, msg *Message) error {
+	return smtp.SendMail(sm.addr, sm.auth, msg.From, []string{msg.To}, msg.Bytes())
+}
+
+// FileMailer appends the emails to a file instead of sending them, eg. for development and testing.
+type FileMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+func (fm *FileMailer) Send(_ context.Context, msg *Message) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	file, err := os.OpenFile(fm.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(msg.Bytes(), "\r\n"...)); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// LogMailer writes the emails to the log instead of sending them. The tokens of the links are
+// redacted, so that whoever reads the logs can't verify an email address or reset a password.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (lm *LogMailer) Send(_ context.Context, msg *Message) error {
+	log.Printf("mail to %s\n%s", msg.To, tokenRegexp.ReplaceAll(msg.Bytes(), []byte("${1}REDACTED")))
+
+	return nil
+}
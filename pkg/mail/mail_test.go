@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.txt")
+	mailer := NewFileMailer(path)
+	msg := Message{
+		From:    "authx@example.com",
+		To:      "chan@example.com",
+		Subject: "Hello",
+		Body:    "Hello there",
+	}
+	require.NoError(t, mailer.Send(context.Background(), &msg))
+	msg.Subject = "Bye"
+	require.NoError(t, mailer.Send(context.Background(), &msg))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "To: chan@example.com\r\n")
+	assert.Contains(t, string(data), "Subject: Hello\r\n")
+	assert.Contains(t, string(data), "Subject: Bye\r\n")
+	assert.Contains(t, string(data), "\r\n\r\nHello there\r\n")
+}
+
+func Test_NewSMTPMailer_InvalidAddress(t *testing.T) {
+	_, err := NewSMTPMailer("smtp.example.com", "", "")
+	assert.Error(t, err)
+
+	mailer, err := NewSMTPMailer("smtp.example.com:587", "user", "pass")
+	assert.NoError(t, err)
+	assert.NotNil(t, mailer.auth)
+}
+
+func Test_LogMailer_RedactsTokens(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	msg := Message{
+		To:      "chan@example.com",
+		Subject: "Reset your password",
+		Body:    "Open the link below.\r\n\r\nhttp://localhost:8080/password/reset?token=s3cr3t&lang=en\r\n",
+	}
+	require.NoError(t, NewLogMailer().Send(context.Background(), &msg))
+
+	assert.Contains(t, buf.String(), "/password/reset?token=REDACTED&lang=en")
+	assert.NotContains(t, buf.String(), "s3cr3t")
+}
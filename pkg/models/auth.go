@@ -4,16 +4,43 @@ import (
 	"time"
 )
 
+// AccessToken represents an access token. An access token issued by the client credentials grant
+// has a ClientID and no UserID.
 type AccessToken struct {
 	ID       string    `bson:"_id"`
 	Value    string    `bson:"value"`
 	UserID   string    `bson:"userID"`
+	ClientID string    `bson:"clientID"`
+	Scope    string    `bson:"scope"`
+	FamilyID string    `bson:"familyID"`
 	ExpireAt time.Time `bson:"expireAt"`
 }
 
+// RefreshToken represents a refresh token. All refresh tokens descending from the same sign-in share
+// the same FamilyID. A rotated-out refresh token is kept (marked as used) until it expires so that a
+// replay can be detected. A refresh token issued by a first-party sign-in has no ClientID.
 type RefreshToken struct {
 	ID       string    `bson:"_id"`
 	Value    string    `bson:"value"`
 	UserID   string    `bson:"userID"`
+	ClientID string    `bson:"clientID"`
+	Scope    string    `bson:"scope"`
+	FamilyID string    `bson:"familyID"`
+	Used     bool      `bson:"used"`
+	IssuedAt time.Time `bson:"issuedAt"`
 	ExpireAt time.Time `bson:"expireAt"`
 }
+
+// AuthCode is an OAuth2 authorization code. The ID is the hash of the code.
+type AuthCode struct {
+	ID                  string    `bson:"_id"`
+	ClientID            string    `bson:"clientID"`
+	UserID              string    `bson:"userID"`
+	RedirectURI         string    `bson:"redirectURI"`
+	Scope               string    `bson:"scope"`
+	CodeChallenge       string    `bson:"codeChallenge"`
+	CodeChallengeMethod string    `bson:"codeChallengeMethod"`
+	Nonce               string    `bson:"nonce"`
+	AuthTime            time.Time `bson:"authTime"`
+	ExpireAt            time.Time `bson:"expireAt"`
+}
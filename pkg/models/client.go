@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// Client is an application registered to request tokens. A public client, eg. a single-page app,
+// can't keep a secret and has none.
+type Client struct {
+	ID           string    `bson:"_id"`
+	Name         string    `bson:"name"`
+	Secret       string    `bson:"secret"`
+	Salt         string    `bson:"salt"`
+	RedirectURIs []string  `bson:"redirectURIs"`
+	GrantTypes   []string  `bson:"grantTypes"`
+	Scopes       []string  `bson:"scopes"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+func (c *Client) IsPublic() bool {
+	return c.Secret == ""
+}
+
+func (c *Client) HasRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+func (c *Client) HasGrantType(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+func (c *Client) HasScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+func (c *Client) RemoveSensitiveData() {
+	c.Secret = ""
+	c.Salt = ""
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
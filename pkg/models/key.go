@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// SigningKey is a key for signing JWTs. The key that was activated last is the one that signs new
+// JWTs, while the older keys are kept around to verify the JWTs they have signed. A key is published
+// before it's activated, so that the clients caching the public keys know it by then.
+type SigningKey struct {
+	ID         string    `bson:"_id"`
+	Algorithm  string    `bson:"algorithm"`
+	PrivateKey string    `bson:"privateKey"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	ActivateAt time.Time `bson:"activateAt"`
+	// PredecessorID is the id of the key this key replaces, or empty for the first key. A key has a
+	// single successor, which keeps the replicas from rotating the same key at once.
+	PredecessorID string `bson:"predecessorID"`
+}
+
+// ActiveFrom returns the time the key starts signing. The keys created before the activation time
+// was kept sign from their creation.
+func (k *SigningKey) ActiveFrom() time.Time {
+	if k.ActivateAt.IsZero() {
+		return k.CreatedAt
+	}
+
+	return k.ActivateAt
+}
@@ -1,13 +1,98 @@
 package models
 
+import (
+	"time"
+)
+
 type User struct {
 	ID       string `bson:"_id"`
 	Username string `bson:"username"`
-	Password string `bson:"password"`
-	Salt     string `bson:"salt"`
+	Name     string `bson:"name"`
+	Email    string `bson:"email"`
+	// EmailVerified is true if the user has proven to own the email address.
+	EmailVerified bool   `bson:"emailVerified"`
+	Password      string `bson:"password"`
+	Salt          string `bson:"salt"`
+	// Claims are the custom claims, eg. roles, added to the access tokens of the user.
+	Claims map[string]interface{} `bson:"claims,omitempty"`
+	// MFA is the second factor of the user, if enrolled.
+	MFA MFA `bson:"mfa"`
+	// Version is incremented by every update, so that an update of a stale copy of the user fails
+	// rather than overwriting a concurrent update.
+	Version int64 `bson:"version"`
+}
+
+// MFA is the TOTP (RFC 6238) second factor of a user. The secret is pending until the user proves to
+// have set up the authenticator with a valid code, which enables the MFA. Only the hashes of the
+// one-time recovery codes are saved.
+type MFA struct {
+	Enabled    bool   `bson:"enabled"`
+	TOTPSecret string `bson:"totpSecret,omitempty"`
+	// LastTOTPStep is the time step of the last accepted code, so that a code can't be replayed.
+	LastTOTPStep  int64    `bson:"lastTOTPStep,omitempty"`
+	RecoveryCodes []string `bson:"recoveryCodes,omitempty"`
 }
 
 func (u *User) RemoveSensitiveData() {
 	u.Password = ""
 	u.Salt = ""
+	u.MFA.TOTPSecret = ""
+	u.MFA.RecoveryCodes = nil
+}
+
+// Invite allows a user to sign up when the sign-up is invite-only. Only the hash of the invite
+// code is saved.
+type Invite struct {
+	ID        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpireAt  time.Time `bson:"expireAt"`
+}
+
+// PasswordReset allows the user to set a new password without the current password. Only the hash
+// of the reset token is saved.
+type PasswordReset struct {
+	ID       string    `bson:"_id"`
+	UserID   string    `bson:"userID"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// MFAChallenge allows a user who has signed in with the password to complete the sign-in with the
+// second factor. Only the hash of the challenge token is saved.
+type MFAChallenge struct {
+	ID       string    `bson:"_id"`
+	UserID   string    `bson:"userID"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// SignInFailures counts the failed sign-ins of a username or from a client IP address, which make up
+// the ID. It expires a while after the last failure, when the failures are forgotten.
+type SignInFailures struct {
+	ID            string    `bson:"_id"`
+	Count         int       `bson:"count"`
+	LastFailureAt time.Time `bson:"lastFailureAt"`
+	ExpireAt      time.Time `bson:"expireAt"`
+}
+
+// WebAuthnSession is a pending WebAuthn ceremony, which can only be completed once. Only the hash of
+// the challenge is saved. The UserID is empty for a sign-in, as the user is only known from the
+// credential of the assertion.
+type WebAuthnSession struct {
+	ID       string    `bson:"_id"`
+	UserID   string    `bson:"userID,omitempty"`
+	Ceremony string    `bson:"ceremony"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// WebAuthnCredential is a public key credential, eg. a passkey, that the user has registered to sign
+// in. The ID is the base64url encoding of the credential id.
+type WebAuthnCredential struct {
+	ID     string `bson:"_id"`
+	UserID string `bson:"userID"`
+	Name   string `bson:"name"`
+	// PublicKey is the credential public key in the COSE key format.
+	PublicKey []byte `bson:"publicKey"`
+	// SignCount is the signature counter of the last assertion, which detects cloned authenticators.
+	SignCount  uint32    `bson:"signCount"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	LastUsedAt time.Time `bson:"lastUsedAt,omitempty"`
 }
@@ -24,6 +24,8 @@ func New(cfg *config.Config) *Server {
 		Router: gin.Default(),
 		cfg:    cfg,
 	}
+	// Otherwise, any client can claim any IP address, eg. to dodge the sign-in throttling.
+	s.Router.ForwardedByClientIP = cfg.TrustForwardedFor
 
 	return s
 }
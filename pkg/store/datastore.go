@@ -3,27 +3,111 @@ package store
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/cybersamx/authx/pkg/models"
 )
 
 var (
-	ErrorNotFound = errors.New("object not found")
+	ErrorNotFound    = errors.New("object not found")
+	ErrDuplicate     = errors.New("object with the same id or unique field already exists")
+	ErrConflict      = errors.New("object has been changed since it was read")
+	ErrInvalidCursor = errors.New("invalid cursor")
 )
 
 type DataStore interface {
 	Close()
 
+	// The usernames are unique regardless of case, and are normalized with NormalizeUsername before
+	// they are saved or looked up.
 	GetUser(parent context.Context, id string) (*models.User, error)
 	GetUserByUsername(parent context.Context, username string) (*models.User, error)
+	// SaveUser normalizes the username of the user and saves it. It returns ErrDuplicate if a user
+	// with the same id or username exists.
 	SaveUser(parent context.Context, user *models.User) error
+	// UpdateUser normalizes the username of the user and replaces an existing user if the saved version
+	// is the version of the user, and increments the version of the user. It returns ErrorNotFound if
+	// the user doesn't exist, ErrConflict if the user has been updated since it was read, and
+	// ErrDuplicate if another user has the username.
+	UpdateUser(parent context.Context, user *models.User) error
 	RemoveUser(parent context.Context, id string) error
+	// ListUsers returns up to limit users that the filter selects, or all of them if limit isn't
+	// positive, sorted by username and then id. The cursor is empty for the first page, and the one
+	// returned is the cursor of the next page, which is empty if there are no more users. It returns
+	// ErrInvalidCursor if the cursor is malformed.
+	ListUsers(parent context.Context, filter *UserFilter, cursor string, limit int) ([]*models.User, string, error)
+	// CountUsers returns the number of users that the filter selects.
+	CountUsers(parent context.Context, filter *UserFilter) (int64, error)
+
+	SaveInvite(parent context.Context, invite *models.Invite) error
+	// ConsumeInvite atomically gets and removes an invite.
+	ConsumeInvite(parent context.Context, id string) (*models.Invite, error)
+
+	GetPasswordReset(parent context.Context, id string) (*models.PasswordReset, error)
+	SavePasswordReset(parent context.Context, pr *models.PasswordReset) error
+	// ConsumePasswordReset atomically gets and removes a password reset.
+	ConsumePasswordReset(parent context.Context, id string) (*models.PasswordReset, error)
+	RemovePasswordResetsByUser(parent context.Context, userID string) error
+
+	GetMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error)
+	SaveMFAChallenge(parent context.Context, mc *models.MFAChallenge) error
+	// ConsumeMFAChallenge atomically gets and removes an MFA challenge.
+	ConsumeMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error)
+
+	SaveWebAuthnSession(parent context.Context, ws *models.WebAuthnSession) error
+	// ConsumeWebAuthnSession atomically gets and removes a WebAuthn session.
+	ConsumeWebAuthnSession(parent context.Context, id string) (*models.WebAuthnSession, error)
+
+	GetWebAuthnCredential(parent context.Context, id string) (*models.WebAuthnCredential, error)
+	GetWebAuthnCredentialsByUser(parent context.Context, userID string) ([]*models.WebAuthnCredential, error)
+	SaveWebAuthnCredential(parent context.Context, cred *models.WebAuthnCredential) error
+	// UpdateWebAuthnSignCount atomically sets the sign count and the time of the last use of a
+	// credential, if the sign count is greater than the saved one or both are 0. It returns
+	// ErrorNotFound if the credential doesn't exist or the sign count isn't greater.
+	UpdateWebAuthnSignCount(parent context.Context, id string, signCount uint32, usedAt time.Time) error
+	RemoveWebAuthnCredential(parent context.Context, id string) error
+
+	GetSignInFailures(parent context.Context, id string) (*models.SignInFailures, error)
+	// AddSignInFailure atomically increments the count of the failed sign-ins, starting from 0 if
+	// there's none, and sets the time of the last failure and the expiry. It returns the updated count.
+	AddSignInFailure(parent context.Context, id string, at, expireAt time.Time) (*models.SignInFailures, error)
+	// ReleaseSignInFailure atomically decrements the count of the failed sign-ins, if it's greater than
+	// 0, eg. when an attempt counted upfront doesn't fail. It does nothing if there's none.
+	ReleaseSignInFailure(parent context.Context, id string) error
+	RemoveSignInFailures(parent context.Context, id string) error
 
 	GetAccessToken(parent context.Context, id string) (*models.AccessToken, error)
 	SaveAccessToken(parent context.Context, at *models.AccessToken) error
 	RemoveAccessToken(parent context.Context, id string) error
+	// RemoveAccessTokensByFamily removes the access tokens of the family, and returns their ids.
+	RemoveAccessTokensByFamily(parent context.Context, familyID string) ([]string, error)
+	// RemoveAccessTokensByUser removes the access tokens of the user, except the ones in the family
+	// keepFamilyID if it isn't empty, and returns their ids.
+	RemoveAccessTokensByUser(parent context.Context, userID, keepFamilyID string) ([]string, error)
 
 	GetRefreshToken(parent context.Context, id string) (*models.RefreshToken, error)
 	SaveRefreshToken(parent context.Context, rt *models.RefreshToken) error
 	RemoveRefreshToken(parent context.Context, id string) error
+	RemoveRefreshTokensByFamily(parent context.Context, familyID string) error
+	// RemoveRefreshTokensByUser removes the refresh tokens of the user, except the ones in the family
+	// keepFamilyID if it isn't empty.
+	RemoveRefreshTokensByUser(parent context.Context, userID, keepFamilyID string) error
+	// MarkRefreshTokenUsed atomically flags an unused refresh token as used. It returns ErrorNotFound
+	// if the token doesn't exist or has already been used.
+	MarkRefreshTokenUsed(parent context.Context, id string) error
+
+	GetClient(parent context.Context, id string) (*models.Client, error)
+	GetClients(parent context.Context) ([]*models.Client, error)
+	SaveClient(parent context.Context, client *models.Client) error
+	// UpdateClient replaces an existing client. It returns ErrorNotFound if the client doesn't exist.
+	UpdateClient(parent context.Context, client *models.Client) error
+	RemoveClient(parent context.Context, id string) error
+
+	SaveAuthCode(parent context.Context, ac *models.AuthCode) error
+	// ConsumeAuthCode atomically gets and removes an authorization code.
+	ConsumeAuthCode(parent context.Context, id string) (*models.AuthCode, error)
+
+	GetSigningKeys(parent context.Context) ([]*models.SigningKey, error)
+	SaveSigningKey(parent context.Context, key *models.SigningKey) error
+	RemoveSigningKey(parent context.Context, id string) error
 }
@@ -0,0 +1,762 @@
+// Package memory implements a data store that keeps the objects in memory, eg. for the tests and
+// for running a single node in development without a database. The objects are lost when the
+// process exits.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	atCollection   = "access_tokens"
+	rtCollection   = "refresh_tokens"
+	userCollection = "users"
+	keyCollection  = "signing_keys"
+	acCollection   = "auth_codes"
+	clCollection   = "clients"
+	invCollection  = "invites"
+	prCollection   = "password_resets"
+	sfCollection   = "signin_failures"
+	mcCollection   = "mfa_challenges"
+	wsCollection   = "webauthn_sessions"
+	wcCollection   = "webauthn_credentials"
+
+	// purgeInterval is how often the expired objects are removed, like the TTL monitor of Mongo.
+	purgeInterval = 60 * time.Second
+)
+
+// uniqueKeys are the string fields whose values are unique in the collections, like the unique
+// indexes in Mongo.
+var uniqueKeys = map[string][]string{
+	userCollection: {"username"},
+	keyCollection:  {"predecessorID"},
+}
+
+// document is a saved object, encoded in BSON as in Mongo. Every read decodes a new copy of the
+// object, so the callers can't change the saved objects, and get the same values as from Mongo, eg.
+// the times truncated to milliseconds.
+type document struct {
+	data bson.Raw
+	// expireAt is the time after which the object is gone, if it has an expireAt field as the
+	// objects in the collections with a TTL index in Mongo.
+	expireAt time.Time
+}
+
+func newDocument(obj interface{}) (*document, string, error) {
+	data, err := bson.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+
+	doc := document{data: data}
+	if expireAt, ok := doc.data.Lookup("expireAt").TimeOK(); ok {
+		doc.expireAt = expireAt
+	}
+
+	return &doc, doc.data.Lookup("_id").StringValue(), nil
+}
+
+func (d *document) expired(now time.Time) bool {
+	return !d.expireAt.IsZero() && !now.Before(d.expireAt)
+}
+
+// matches returns true if the string field key of the document equals val.
+func (d *document) matches(key, val string) bool {
+	s, ok := d.data.Lookup(key).StringValueOK()
+	return ok && s == val
+}
+
+// --- Store ---
+
+// Store is a concurrency-safe data store in memory. The objects expire at their expireAt field like
+// with the TTL indexes of Mongo, but they are gone as soon as they expire rather than when the
+// expired objects are removed periodically.
+type Store struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]*document
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New() *Store {
+	s := Store{
+		collections: map[string]map[string]*document{},
+		done:        make(chan struct{}),
+	}
+	go s.purgeExpired()
+
+	return &s
+}
+
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// purgeExpired removes the expired objects periodically, so they don't pile up, until the store is
+// closed.
+func (s *Store) purgeExpired() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for _, docs := range s.collections {
+				for id, doc := range docs {
+					if doc.expired(now) {
+						delete(docs, id)
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// lookup returns the unexpired document of the id in the collection. The caller must hold the lock.
+func (s *Store) lookup(collection, id string) (*document, bool) {
+	doc, ok := s.collections[collection][id]
+	if !ok || doc.expired(time.Now()) {
+		return nil, false
+	}
+
+	return doc, true
+}
+
+// put saves the document of the id in the collection, replacing the one with the same id. The caller
+// must hold the write lock.
+func (s *Store) put(collection, id string, doc *document) {
+	docs, ok := s.collections[collection]
+	if !ok {
+		docs = map[string]*document{}
+		s.collections[collection] = docs
+	}
+	docs[id] = doc
+}
+
+// putObject saves the object in the collection, replacing the one with the same id. The caller must
+// hold the write lock.
+func (s *Store) putObject(collection string, obj interface{}) error {
+	doc, id, err := newDocument(obj)
+	if err != nil {
+		return err
+	}
+	if err := s.checkUnique(collection, id, doc); err != nil {
+		return err
+	}
+	s.put(collection, id, doc)
+
+	return nil
+}
+
+// checkUnique returns ErrDuplicate if an unexpired document other than the one of the id in the
+// collection has the value of a unique field of the document. The caller must hold the lock.
+func (s *Store) checkUnique(collection, id string, doc *document) error {
+	now := time.Now()
+	for _, key := range uniqueKeys[collection] {
+		val, _ := doc.data.Lookup(key).StringValueOK()
+		for otherID, other := range s.collections[collection] {
+			if otherID != id && !other.expired(now) && other.matches(key, val) {
+				return store.ErrDuplicate
+			}
+		}
+	}
+
+	return nil
+}
+
+// find returns the unexpired documents in the collection, whose string field key equals val, or all of
+// them if the key is empty, sorted by the field sortKey. The caller must hold the lock.
+func (s *Store) find(collection, key, val, sortKey string) []*document {
+	now := time.Now()
+
+	var docs []*document
+	for _, doc := range s.collections[collection] {
+		if !doc.expired(now) && (key == "" || doc.matches(key, val)) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		vi, vj := docs[i].data.Lookup(sortKey), docs[j].data.Lookup(sortKey)
+		if ti, ok := vi.TimeOK(); ok {
+			tj, _ := vj.TimeOK()
+
+			return ti.Before(tj)
+		}
+
+		si, _ := vi.StringValueOK()
+		sj, _ := vj.StringValueOK()
+
+		return si < sj
+	})
+
+	return docs
+}
+
+func (s *Store) getAndBindObject(collection, key, val string, obj interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var doc *document
+	if key == "_id" {
+		doc, _ = s.lookup(collection, val)
+	} else if docs := s.find(collection, key, val, "_id"); len(docs) > 0 {
+		doc = docs[0]
+	}
+	if doc == nil {
+		return store.ErrorNotFound
+	}
+
+	return bson.Unmarshal(doc.data, obj)
+}
+
+func (s *Store) saveObject(collection string, obj interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, id, err := newDocument(obj)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.lookup(collection, id); ok {
+		return store.ErrDuplicate
+	}
+	if err := s.checkUnique(collection, id, doc); err != nil {
+		return err
+	}
+	s.put(collection, id, doc)
+
+	return nil
+}
+
+// replaceObject replaces the object with the same id. It returns ErrorNotFound if there's none.
+func (s *Store) replaceObject(collection, id string, obj interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(collection, id); !ok {
+		return store.ErrorNotFound
+	}
+
+	return s.putObject(collection, obj)
+}
+
+// updateObject binds the object of the id and saves it if update returns true. It returns
+// ErrorNotFound if there's no object or update returns false.
+func (s *Store) updateObject(collection, id string, obj interface{}, update func() bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.lookup(collection, id)
+	if !ok {
+		return store.ErrorNotFound
+	}
+	if err := bson.Unmarshal(doc.data, obj); err != nil {
+		return err
+	}
+	if !update() {
+		return store.ErrorNotFound
+	}
+
+	return s.putObject(collection, obj)
+}
+
+// consumeObject atomically binds and removes the object of the id.
+func (s *Store) consumeObject(collection, id string, obj interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.lookup(collection, id)
+	if !ok {
+		return store.ErrorNotFound
+	}
+	delete(s.collections[collection], id)
+
+	return bson.Unmarshal(doc.data, obj)
+}
+
+func (s *Store) removeObject(collection, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.collections[collection], id)
+
+	return nil
+}
+
+// removeObjects removes the objects in the collection whose field key equals val, and returns their
+// ids.
+func (s *Store) removeObjects(collection, key, val string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, doc := range s.collections[collection] {
+		if doc.matches(key, val) {
+			delete(s.collections[collection], id)
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// removeUserTokens removes the tokens of the user in the collection, except the ones in the family
+// keepFamilyID if it isn't empty, and returns their ids.
+func (s *Store) removeUserTokens(collection, userID, keepFamilyID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, doc := range s.collections[collection] {
+		if doc.matches("userID", userID) && (keepFamilyID == "" || !doc.matches("familyID", keepFamilyID)) {
+			delete(s.collections[collection], id)
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *Store) GetUser(_ context.Context, id string) (*models.User, error) {
+	var user models.User
+	if err := s.getAndBindObject(userCollection, "_id", id, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *Store) GetUserByUsername(_ context.Context, username string) (*models.User, error) {
+	var user models.User
+	if err := s.getAndBindObject(userCollection, "username", store.NormalizeUsername(username), &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *Store) SaveUser(_ context.Context, user *models.User) error {
+	user.Username = store.NormalizeUsername(user.Username)
+	return s.saveObject(userCollection, user)
+}
+
+func (s *Store) UpdateUser(_ context.Context, user *models.User) error {
+	user.Username = store.NormalizeUsername(user.Username)
+	var saved models.User
+	conflict := false
+	err := s.updateObject(userCollection, user.ID, &saved, func() bool {
+		if saved.Version != user.Version {
+			conflict = true
+			return false
+		}
+		saved = *user
+		saved.Version++
+
+		return true
+	})
+	if conflict {
+		return store.ErrConflict
+	} else if err != nil {
+		return err
+	}
+	user.Version = saved.Version
+
+	return nil
+}
+
+func (s *Store) RemoveUser(_ context.Context, id string) error {
+	return s.removeObject(userCollection, id)
+}
+
+// findUsers returns the users that the filter selects, sorted by username and then id.
+func (s *Store) findUsers(filter *store.UserFilter) ([]*models.User, error) {
+	var users []*models.User
+	for _, doc := range s.collections[userCollection] {
+		var user models.User
+		if err := bson.Unmarshal(doc.data, &user); err != nil {
+			return nil, err
+		}
+		if filter.Match(&user) {
+			users = append(users, &user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Username != users[j].Username {
+			return users[i].Username < users[j].Username
+		}
+
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+func (s *Store) ListUsers(_ context.Context, filter *store.UserFilter, cursor string, limit int) ([]*models.User, string, error) {
+	after, err := store.ParseUserCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users, err := s.findUsers(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := sort.Search(len(users), func(i int) bool {
+		return !after.Before(users[i])
+	})
+	users = users[start:]
+	if limit <= 0 || len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+
+	return users, store.NewUserCursor(users[limit-1]).String(), nil
+}
+
+func (s *Store) CountUsers(_ context.Context, filter *store.UserFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users, err := s.findUsers(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(users)), nil
+}
+
+func (s *Store) SaveInvite(_ context.Context, invite *models.Invite) error {
+	return s.saveObject(invCollection, invite)
+}
+
+func (s *Store) ConsumeInvite(_ context.Context, id string) (*models.Invite, error) {
+	var invite models.Invite
+	if err := s.consumeObject(invCollection, id, &invite); err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (s *Store) GetPasswordReset(_ context.Context, id string) (*models.PasswordReset, error) {
+	var pr models.PasswordReset
+	if err := s.getAndBindObject(prCollection, "_id", id, &pr); err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) SavePasswordReset(_ context.Context, pr *models.PasswordReset) error {
+	return s.saveObject(prCollection, pr)
+}
+
+func (s *Store) ConsumePasswordReset(_ context.Context, id string) (*models.PasswordReset, error) {
+	var pr models.PasswordReset
+	if err := s.consumeObject(prCollection, id, &pr); err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) RemovePasswordResetsByUser(_ context.Context, userID string) error {
+	_, err := s.removeObjects(prCollection, "userID", userID)
+
+	return err
+}
+
+func (s *Store) GetMFAChallenge(_ context.Context, id string) (*models.MFAChallenge, error) {
+	var mc models.MFAChallenge
+	if err := s.getAndBindObject(mcCollection, "_id", id, &mc); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveMFAChallenge(_ context.Context, mc *models.MFAChallenge) error {
+	return s.saveObject(mcCollection, mc)
+}
+
+func (s *Store) ConsumeMFAChallenge(_ context.Context, id string) (*models.MFAChallenge, error) {
+	var mc models.MFAChallenge
+	if err := s.consumeObject(mcCollection, id, &mc); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveWebAuthnSession(_ context.Context, ws *models.WebAuthnSession) error {
+	return s.saveObject(wsCollection, ws)
+}
+
+func (s *Store) ConsumeWebAuthnSession(_ context.Context, id string) (*models.WebAuthnSession, error) {
+	var ws models.WebAuthnSession
+	if err := s.consumeObject(wsCollection, id, &ws); err != nil {
+		return nil, err
+	}
+
+	return &ws, nil
+}
+
+func (s *Store) GetWebAuthnCredential(_ context.Context, id string) (*models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	if err := s.getAndBindObject(wcCollection, "_id", id, &cred); err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+func (s *Store) GetWebAuthnCredentialsByUser(_ context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var creds []*models.WebAuthnCredential
+	for _, doc := range s.find(wcCollection, "userID", userID, "createdAt") {
+		var cred models.WebAuthnCredential
+		if err := bson.Unmarshal(doc.data, &cred); err != nil {
+			return nil, err
+		}
+		creds = append(creds, &cred)
+	}
+
+	return creds, nil
+}
+
+func (s *Store) SaveWebAuthnCredential(_ context.Context, cred *models.WebAuthnCredential) error {
+	return s.saveObject(wcCollection, cred)
+}
+
+func (s *Store) UpdateWebAuthnSignCount(_ context.Context, id string, signCount uint32, usedAt time.Time) error {
+	var cred models.WebAuthnCredential
+	return s.updateObject(wcCollection, id, &cred, func() bool {
+		if signCount <= cred.SignCount && (signCount != 0 || cred.SignCount != 0) {
+			return false
+		}
+		cred.SignCount = signCount
+		cred.LastUsedAt = usedAt
+
+		return true
+	})
+}
+
+func (s *Store) RemoveWebAuthnCredential(_ context.Context, id string) error {
+	return s.removeObject(wcCollection, id)
+}
+
+func (s *Store) GetSignInFailures(_ context.Context, id string) (*models.SignInFailures, error) {
+	var sf models.SignInFailures
+	if err := s.getAndBindObject(sfCollection, "_id", id, &sf); err != nil {
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+func (s *Store) AddSignInFailure(_ context.Context, id string, at, expireAt time.Time) (*models.SignInFailures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sf := models.SignInFailures{ID: id}
+	if doc, ok := s.lookup(sfCollection, id); ok {
+		if err := bson.Unmarshal(doc.data, &sf); err != nil {
+			return nil, err
+		}
+	}
+	sf.Count++
+	sf.LastFailureAt = at
+	sf.ExpireAt = expireAt
+	if err := s.putObject(sfCollection, &sf); err != nil {
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+func (s *Store) ReleaseSignInFailure(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.lookup(sfCollection, id)
+	if !ok {
+		return nil
+	}
+	var sf models.SignInFailures
+	if err := bson.Unmarshal(doc.data, &sf); err != nil {
+		return err
+	}
+	if sf.Count == 0 {
+		return nil
+	}
+	sf.Count--
+
+	return s.putObject(sfCollection, &sf)
+}
+
+func (s *Store) RemoveSignInFailures(_ context.Context, id string) error {
+	return s.removeObject(sfCollection, id)
+}
+
+func (s *Store) GetAccessToken(_ context.Context, id string) (*models.AccessToken, error) {
+	var at models.AccessToken
+	if err := s.getAndBindObject(atCollection, "_id", id, &at); err != nil {
+		return nil, err
+	}
+
+	return &at, nil
+}
+
+func (s *Store) SaveAccessToken(_ context.Context, at *models.AccessToken) error {
+	return s.saveObject(atCollection, at)
+}
+
+func (s *Store) RemoveAccessToken(_ context.Context, id string) error {
+	return s.removeObject(atCollection, id)
+}
+
+func (s *Store) RemoveAccessTokensByFamily(_ context.Context, familyID string) ([]string, error) {
+	return s.removeObjects(atCollection, "familyID", familyID)
+}
+
+func (s *Store) RemoveAccessTokensByUser(_ context.Context, userID, keepFamilyID string) ([]string, error) {
+	return s.removeUserTokens(atCollection, userID, keepFamilyID)
+}
+
+func (s *Store) GetRefreshToken(_ context.Context, id string) (*models.RefreshToken, error) {
+	var rt models.RefreshToken
+	if err := s.getAndBindObject(rtCollection, "_id", id, &rt); err != nil {
+		return nil, err
+	}
+
+	return &rt, nil
+}
+
+func (s *Store) SaveRefreshToken(_ context.Context, rt *models.RefreshToken) error {
+	return s.saveObject(rtCollection, rt)
+}
+
+func (s *Store) RemoveRefreshToken(_ context.Context, id string) error {
+	return s.removeObject(rtCollection, id)
+}
+
+func (s *Store) RemoveRefreshTokensByFamily(_ context.Context, familyID string) error {
+	_, err := s.removeObjects(rtCollection, "familyID", familyID)
+
+	return err
+}
+
+func (s *Store) RemoveRefreshTokensByUser(_ context.Context, userID, keepFamilyID string) error {
+	_, err := s.removeUserTokens(rtCollection, userID, keepFamilyID)
+
+	return err
+}
+
+func (s *Store) MarkRefreshTokenUsed(_ context.Context, id string) error {
+	var rt models.RefreshToken
+	return s.updateObject(rtCollection, id, &rt, func() bool {
+		if rt.Used {
+			return false
+		}
+		rt.Used = true
+
+		return true
+	})
+}
+
+func (s *Store) GetClient(_ context.Context, id string) (*models.Client, error) {
+	var client models.Client
+	if err := s.getAndBindObject(clCollection, "_id", id, &client); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s *Store) GetClients(_ context.Context) ([]*models.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var clients []*models.Client
+	for _, doc := range s.find(clCollection, "", "", "_id") {
+		var client models.Client
+		if err := bson.Unmarshal(doc.data, &client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, &client)
+	}
+
+	return clients, nil
+}
+
+func (s *Store) SaveClient(_ context.Context, client *models.Client) error {
+	return s.saveObject(clCollection, client)
+}
+
+func (s *Store) UpdateClient(_ context.Context, client *models.Client) error {
+	return s.replaceObject(clCollection, client.ID, client)
+}
+
+func (s *Store) RemoveClient(_ context.Context, id string) error {
+	return s.removeObject(clCollection, id)
+}
+
+func (s *Store) SaveAuthCode(_ context.Context, ac *models.AuthCode) error {
+	return s.saveObject(acCollection, ac)
+}
+
+func (s *Store) ConsumeAuthCode(_ context.Context, id string) (*models.AuthCode, error) {
+	var ac models.AuthCode
+	if err := s.consumeObject(acCollection, id, &ac); err != nil {
+		return nil, err
+	}
+
+	return &ac, nil
+}
+
+func (s *Store) GetSigningKeys(_ context.Context) ([]*models.SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []*models.SigningKey
+	for _, doc := range s.find(keyCollection, "", "", "createdAt") {
+		var key models.SigningKey
+		if err := bson.Unmarshal(doc.data, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+func (s *Store) SaveSigningKey(_ context.Context, key *models.SigningKey) error {
+	return s.saveObject(keyCollection, key)
+}
+
+func (s *Store) RemoveSigningKey(_ context.Context, id string) error {
+	return s.removeObject(keyCollection, id)
+}
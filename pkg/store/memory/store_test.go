@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/storetest"
+)
+
+func Test_Conformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) store.DataStore {
+		s := New()
+		t.Cleanup(s.Close)
+
+		return s
+	})
+}
+
+func Test_Expiry(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	defer s.Close()
+
+	require.NoError(t, s.SaveAccessToken(ctx, &models.AccessToken{
+		ID:       "at1",
+		UserID:   "user1",
+		ExpireAt: time.Now().Add(-time.Second),
+	}))
+	require.NoError(t, s.SaveAuthCode(ctx, &models.AuthCode{
+		ID:       "ac1",
+		ExpireAt: time.Now().Add(-time.Second),
+	}))
+
+	// The expired objects are gone.
+	_, err := s.GetAccessToken(ctx, "at1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = s.ConsumeAuthCode(ctx, "ac1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	// An expired object can be replaced.
+	at := models.AccessToken{ID: "at1", UserID: "user1", ExpireAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.SaveAccessToken(ctx, &at))
+	got, err := s.GetAccessToken(ctx, "at1")
+	require.NoError(t, err)
+	assert.Equal(t, at.ExpireAt.Truncate(time.Millisecond).UTC(), got.ExpireAt)
+	assert.Equal(t, store.ErrDuplicate, s.SaveAccessToken(ctx, &at))
+}
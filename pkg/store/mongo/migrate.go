@@ -0,0 +1,137 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	migrationCollection = "schema_migrations"
+	migrateTimeout      = 60 * time.Second
+)
+
+var (
+	ErrDuplicateUsernames = errors.New("users have the same username once normalized")
+)
+
+// migration is a version of the data. The migrations are applied in order, and each only once, so a
+// released migration must never change. Change the data with a new migration instead.
+type migration struct {
+	version int
+	update  func(ctx context.Context, db *mongo.Database) error
+}
+
+var migrations = []migration{
+	{
+		// The migration fails if users have the same username once normalized, which must be renamed.
+		version: 1,
+		update:  normalizeUsernames,
+	},
+}
+
+// migrate applies the migrations that the database hasn't had yet. The replicas that start at the
+// same time may apply the same migration, so a migration must be safe to apply again.
+func migrate(parent context.Context, db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(parent, migrateTimeout)
+	defer cancel()
+
+	for _, m := range migrations {
+		n, err := db.Collection(migrationCollection).CountDocuments(ctx, bson.D{{Key: "_id", Value: m.version}})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		if err := m.update(ctx, db); err != nil {
+			return fmt.Errorf("can't apply mongo migration %d: %w", m.version, err)
+		}
+		_, err = db.Collection(migrationCollection).InsertOne(ctx, bson.D{
+			{Key: "_id", Value: m.version},
+			{Key: "appliedAt", Value: time.Now()},
+		})
+		if err != nil && !isDuplicateKeyError(err) {
+			return err
+		}
+
+		log.Printf("applied mongo migration %d\n", m.version)
+	}
+
+	return nil
+}
+
+// storedUsername is the username of a user as it's stored.
+type storedUsername struct {
+	ID       string `bson:"_id"`
+	Username string `bson:"username"`
+}
+
+// normalizeUsernames normalizes the usernames of the users that were saved before the usernames were
+// normalized.
+func normalizeUsernames(ctx context.Context, db *mongo.Database) error {
+	opts := options.Find().SetProjection(bson.D{{Key: "username", Value: 1}})
+	cursor, err := db.Collection(userCollection).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	var users []storedUsername
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	renames, err := usernameRenames(users)
+	if err != nil {
+		return err
+	}
+	for id, username := range renames {
+		_, err := db.Collection(userCollection).UpdateOne(ctx, bson.D{{Key: "_id", Value: id}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "username", Value: username}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// usernameRenames returns the normalized usernames, by user id, of the users whose username isn't
+// normalized. It returns ErrDuplicateUsernames, with the users to rename, if users have the same
+// username once normalized.
+func usernameRenames(users []storedUsername) (map[string]string, error) {
+	ids := map[string][]string{}
+	renames := map[string]string{}
+	for _, user := range users {
+		username := store.NormalizeUsername(user.Username)
+		ids[username] = append(ids[username], user.ID)
+		if username != user.Username {
+			renames[user.ID] = username
+		}
+	}
+
+	var duplicates []string
+	for username, uids := range ids {
+		if len(uids) > 1 {
+			sort.Strings(uids)
+			duplicates = append(duplicates, fmt.Sprintf("%q (users %s)", username, strings.Join(uids, ", ")))
+		}
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return nil, fmt.Errorf("%w: %s; rename all but one user of each username and restart",
+			ErrDuplicateUsernames, strings.Join(duplicates, "; "))
+	}
+
+	return renames, nil
+}
@@ -0,0 +1,53 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+func Test_UsernameRenames(t *testing.T) {
+	renames, err := usernameRenames([]storedUsername{
+		{ID: "1", Username: "chan"},
+		{ID: "2", Username: "John"},
+		{ID: "3", Username: " Patel "},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"2": "john", "3": "patel"}, renames)
+
+	_, err = usernameRenames([]storedUsername{
+		{ID: "1", Username: "chan"},
+		{ID: "2", Username: "Chan"},
+		{ID: "3", Username: "john"},
+	})
+	assert.True(t, errors.Is(err, ErrDuplicateUsernames))
+	assert.Contains(t, err.Error(), `"chan" (users 1, 2)`)
+}
+
+func Test_NormalizeUsernames(t *testing.T) {
+	clearMongo(t, ds)
+
+	ctx := context.Background()
+	// The users are saved as they were before the usernames were normalized.
+	for _, user := range []storedUsername{{ID: "1", Username: "Chan"}, {ID: "2", Username: "john"}} {
+		_, err := ds.db.Collection(userCollection).InsertOne(ctx, user)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, normalizeUsernames(ctx, ds.db))
+
+	users, _, err := ds.ListUsers(ctx, &store.UserFilter{UsernamePrefix: "CH"}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "chan", users[0].Username)
+
+	// A username that is the same as another once normalized must be renamed.
+	_, err = ds.db.Collection(userCollection).InsertOne(ctx, storedUsername{ID: "3", Username: " John"})
+	require.NoError(t, err)
+	assert.True(t, errors.Is(normalizeUsernames(ctx, ds.db), ErrDuplicateUsernames))
+}
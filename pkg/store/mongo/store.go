@@ -3,10 +3,12 @@ package mongo
 import (
 	"context"
 	"log"
+	"regexp"
 	"time"
 
 	"github.com/flowchartsman/retry"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -22,6 +24,15 @@ const (
 	atCollection   = "access_tokens"
 	rtCollection   = "refresh_tokens"
 	userCollection = "users"
+	keyCollection  = "signing_keys"
+	acCollection   = "auth_codes"
+	clCollection   = "clients"
+	invCollection  = "invites"
+	prCollection   = "password_resets"
+	sfCollection   = "signin_failures"
+	mcCollection   = "mfa_challenges"
+	wsCollection   = "webauthn_sessions"
+	wcCollection   = "webauthn_credentials"
 	database       = "authx"
 
 	// Retry
@@ -30,12 +41,28 @@ const (
 	maxDelay     = 6 * time.Second
 )
 
+// ttlCollections are the collections of the objects that expire.
+var ttlCollections = map[string]bool{
+	atCollection:  true,
+	rtCollection:  true,
+	acCollection:  true,
+	invCollection: true,
+	prCollection:  true,
+	sfCollection:  true,
+	mcCollection:  true,
+	wsCollection:  true,
+}
+
+// usernameCollation compares the usernames regardless of case, which keeps the case variants of a
+// username apart even if one was saved without being normalized.
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 // setupMongo configure the mongo store with indexes, collections, etc.
 func setupMongo(parent context.Context, db *mongo.Database) error {
 	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
 	defer cancel()
 
-	for _, colName := range []string{atCollection, rtCollection} {
+	for colName := range ttlCollections {
 		// Create TTL index.
 		opts := IndexOptions{isTTL: true}
 		_, err := createIndex(ctx, db.Collection(colName), "expireAt", &opts)
@@ -44,6 +71,18 @@ func setupMongo(parent context.Context, db *mongo.Database) error {
 		}
 	}
 
+	// The usernames are unique regardless of case, as well as once they are normalized.
+	opts := IndexOptions{isUnique: true, collation: usernameCollation}
+	if _, err := createIndex(ctx, db.Collection(userCollection), "username", &opts); err != nil {
+		return err
+	}
+
+	// A signing key has a single successor. The keys saved before have no predecessor.
+	opts = IndexOptions{isUnique: true, isSparse: true}
+	if _, err := createIndex(ctx, db.Collection(keyCollection), "predecessorID", &opts); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -110,7 +149,7 @@ func newClient(parent context.Context, dsn string, retries int, initialDelay, ma
 	return client, err
 }
 
-func New(cfg *config.Config) *Store {
+func New(cfg *config.Config) (*Store, error) {
 	dsn := cfg.MongoAddr
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -119,21 +158,27 @@ func New(cfg *config.Config) *Store {
 
 	if err != nil {
 		log.Printf("exhausted all %d retries", retries)
-		panic(err)
+		return nil, err
 	}
 
 	log.Printf("connected to mongo %s successfully\n", maskDSN(dsn))
 
-	// Additional setup.
+	// Additional setup. The data is migrated first, so that the indexes are created on the migrated
+	// data.
 	db := client.Database(database)
+	if err := migrate(ctx, db); err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, err
+	}
 	if err := setupMongo(ctx, db); err != nil {
-		panic(err)
+		_ = client.Disconnect(ctx)
+		return nil, err
 	}
 
 	return &Store{
 		client: client,
 		db:     db,
-	}
+	}, nil
 }
 
 func (s *Store) Close() {
@@ -144,13 +189,23 @@ func (s *Store) Close() {
 	}
 }
 
-func (s *Store) getAndBindObject(parent context.Context, collection, key, val string, obj interface{}) error {
+// liveFilter returns the filter of the objects in the collection whose key is val, without the ones that
+// have expired. Mongo removes the expired objects only periodically.
+func liveFilter(collection, key, val string) bson.D {
+	filter := bson.D{{Key: key, Value: val}}
+	if ttlCollections[collection] {
+		filter = append(filter, bson.E{Key: "expireAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}})
+	}
+
+	return filter
+}
+
+func (s *Store) getAndBindObject(parent context.Context, collection, key, val string, obj interface{},
+	opts ...*options.FindOneOptions) error {
 	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
 	defer cancel()
 
-	err := s.db.Collection(collection).FindOne(ctx, bson.D{
-		{Key: key, Value: val},
-	}).Decode(obj)
+	err := s.db.Collection(collection).FindOne(ctx, liveFilter(collection, key, val), opts...).Decode(obj)
 	if err == mongo.ErrNoDocuments {
 		return store.ErrorNotFound
 	} else if err != nil {
@@ -165,7 +220,9 @@ func (s *Store) saveObject(parent context.Context, collection string, obj interf
 	defer cancel()
 
 	_, err := s.db.Collection(collection).InsertOne(ctx, obj)
-	if err != nil {
+	if isDuplicateKeyError(err) {
+		return store.ErrDuplicate
+	} else if err != nil {
 		return err
 	}
 
@@ -183,6 +240,76 @@ func (s *Store) removeObject(parent context.Context, collection, id string) erro
 	return err
 }
 
+// removeObjects removes the objects in the collection whose field key equals val, and returns their
+// ids.
+func (s *Store) removeObjects(parent context.Context, collection, key, val string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	return s.removeByFilter(ctx, collection, bson.D{{Key: key, Value: val}})
+}
+
+// removeByFilter removes the objects in the collection that the filter selects, and returns their ids.
+// The objects are removed by the ids found, so an object inserted in between is picked up by the next
+// round instead of being removed without its id being returned.
+func (s *Store) removeByFilter(ctx context.Context, collection string, filter bson.D) ([]string, error) {
+	var removed []string
+	for {
+		ids, err := s.findIDs(ctx, collection, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return removed, nil
+		}
+
+		_, err = s.db.Collection(collection).DeleteMany(ctx, bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		removed = append(removed, ids...)
+	}
+}
+
+// findIDs returns the ids of the objects in the collection that the filter selects.
+func (s *Store) findIDs(ctx context.Context, collection string, filter bson.D) ([]string, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := s.db.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+
+	return ids, nil
+}
+
+// removeUserTokens removes the tokens of the user in the collection, except the ones in the family
+// keepFamilyID if it isn't empty, and returns their ids.
+func (s *Store) removeUserTokens(parent context.Context, collection, userID, keepFamilyID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "userID", Value: userID}}
+	if keepFamilyID != "" {
+		filter = append(filter, bson.E{Key: "familyID", Value: bson.D{{Key: "$ne", Value: keepFamilyID}}})
+	}
+
+	return s.removeByFilter(ctx, collection, filter)
+}
+
 func (s *Store) GetUser(parent context.Context, id string) (*models.User, error) {
 	var user models.User
 	if err := s.getAndBindObject(parent, userCollection, "_id", id, &user); err != nil {
@@ -194,7 +321,8 @@ func (s *Store) GetUser(parent context.Context, id string) (*models.User, error)
 
 func (s *Store) GetUserByUsername(parent context.Context, username string) (*models.User, error) {
 	var user models.User
-	if err := s.getAndBindObject(parent, userCollection, "username", username, &user); err != nil {
+	opts := options.FindOne().SetCollation(usernameCollation)
+	if err := s.getAndBindObject(parent, userCollection, "username", store.NormalizeUsername(username), &user, opts); err != nil {
 		return nil, err
 	}
 
@@ -202,13 +330,331 @@ func (s *Store) GetUserByUsername(parent context.Context, username string) (*mod
 }
 
 func (s *Store) SaveUser(parent context.Context, user *models.User) error {
+	user.Username = store.NormalizeUsername(user.Username)
 	return s.saveObject(parent, userCollection, user)
 }
 
+func (s *Store) UpdateUser(parent context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	user.Username = store.NormalizeUsername(user.Username)
+
+	var version interface{} = user.Version
+	if user.Version == 0 {
+		// The users saved before they had a version have none.
+		version = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+	updated := *user
+	updated.Version++
+	res, err := s.db.Collection(userCollection).ReplaceOne(ctx, bson.D{
+		{Key: "_id", Value: user.ID},
+		{Key: "version", Value: version},
+	}, &updated)
+	if isDuplicateKeyError(err) {
+		return store.ErrDuplicate
+	} else if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		n, err := s.db.Collection(userCollection).CountDocuments(ctx, bson.D{{Key: "_id", Value: user.ID}})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return store.ErrorNotFound
+		}
+
+		return store.ErrConflict
+	}
+	user.Version = updated.Version
+
+	return nil
+}
+
 func (s *Store) RemoveUser(parent context.Context, id string) error {
 	return s.removeObject(parent, userCollection, id)
 }
 
+// userFilter returns the Mongo filter of the users that the filter selects.
+func userFilter(filter *store.UserFilter) bson.D {
+	query := bson.D{}
+	if filter == nil {
+		return query
+	}
+
+	if prefix := store.NormalizeUsername(filter.UsernamePrefix); prefix != "" {
+		query = append(query, bson.E{Key: "username", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}})
+	}
+	if filter.EmailVerified != nil {
+		query = append(query, bson.E{Key: "emailVerified", Value: *filter.EmailVerified})
+	}
+	if filter.MFAEnabled != nil {
+		query = append(query, bson.E{Key: "mfa.enabled", Value: *filter.MFAEnabled})
+	}
+
+	return query
+}
+
+func (s *Store) ListUsers(parent context.Context, filter *store.UserFilter, cursor string, limit int) ([]*models.User, string, error) {
+	after, err := store.ParseUserCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	query := userFilter(filter)
+	if after != nil {
+		query = append(query, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "username", Value: bson.D{{Key: "$gt", Value: after.Username}}}},
+			bson.D{{Key: "username", Value: after.Username}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: after.ID}}}},
+		}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		// One more user tells if there's a next page.
+		opts.SetLimit(int64(limit) + 1)
+	}
+	res, err := s.db.Collection(userCollection).Find(ctx, query, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := []*models.User{}
+	if err := res.All(ctx, &users); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 || len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+
+	return users, store.NewUserCursor(users[limit-1]).String(), nil
+}
+
+func (s *Store) CountUsers(parent context.Context, filter *store.UserFilter) (int64, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	return s.db.Collection(userCollection).CountDocuments(ctx, userFilter(filter))
+}
+
+func (s *Store) SaveInvite(parent context.Context, invite *models.Invite) error {
+	return s.saveObject(parent, invCollection, invite)
+}
+
+func (s *Store) ConsumeInvite(parent context.Context, id string) (*models.Invite, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var invite models.Invite
+	err := s.db.Collection(invCollection).FindOneAndDelete(ctx, liveFilter(invCollection, "_id", id)).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (s *Store) GetPasswordReset(parent context.Context, id string) (*models.PasswordReset, error) {
+	var pr models.PasswordReset
+	if err := s.getAndBindObject(parent, prCollection, "_id", id, &pr); err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) SavePasswordReset(parent context.Context, pr *models.PasswordReset) error {
+	return s.saveObject(parent, prCollection, pr)
+}
+
+func (s *Store) ConsumePasswordReset(parent context.Context, id string) (*models.PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var pr models.PasswordReset
+	err := s.db.Collection(prCollection).FindOneAndDelete(ctx, liveFilter(prCollection, "_id", id)).Decode(&pr)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) RemovePasswordResetsByUser(parent context.Context, userID string) error {
+	_, err := s.removeObjects(parent, prCollection, "userID", userID)
+
+	return err
+}
+
+func (s *Store) GetMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error) {
+	var mc models.MFAChallenge
+	if err := s.getAndBindObject(parent, mcCollection, "_id", id, &mc); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveMFAChallenge(parent context.Context, mc *models.MFAChallenge) error {
+	return s.saveObject(parent, mcCollection, mc)
+}
+
+func (s *Store) ConsumeMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var mc models.MFAChallenge
+	err := s.db.Collection(mcCollection).FindOneAndDelete(ctx, liveFilter(mcCollection, "_id", id)).Decode(&mc)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveWebAuthnSession(parent context.Context, ws *models.WebAuthnSession) error {
+	return s.saveObject(parent, wsCollection, ws)
+}
+
+func (s *Store) ConsumeWebAuthnSession(parent context.Context, id string) (*models.WebAuthnSession, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var ws models.WebAuthnSession
+	err := s.db.Collection(wsCollection).FindOneAndDelete(ctx, liveFilter(wsCollection, "_id", id)).Decode(&ws)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &ws, nil
+}
+
+func (s *Store) GetWebAuthnCredential(parent context.Context, id string) (*models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	if err := s.getAndBindObject(parent, wcCollection, "_id", id, &cred); err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+func (s *Store) GetWebAuthnCredentialsByUser(parent context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := s.db.Collection(wcCollection).Find(ctx, bson.D{{Key: "userID", Value: userID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []*models.WebAuthnCredential
+	if err := cursor.All(ctx, &creds); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (s *Store) SaveWebAuthnCredential(parent context.Context, cred *models.WebAuthnCredential) error {
+	return s.saveObject(parent, wcCollection, cred)
+}
+
+func (s *Store) UpdateWebAuthnSignCount(parent context.Context, id string, signCount uint32, usedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	countFilter := bson.D{{Key: "$lt", Value: signCount}}
+	if signCount == 0 {
+		countFilter = bson.D{{Key: "$eq", Value: 0}}
+	}
+	res, err := s.db.Collection(wcCollection).UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "signCount", Value: countFilter},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "signCount", Value: signCount}, {Key: "lastUsedAt", Value: usedAt}}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrorNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) RemoveWebAuthnCredential(parent context.Context, id string) error {
+	return s.removeObject(parent, wcCollection, id)
+}
+
+func (s *Store) GetSignInFailures(parent context.Context, id string) (*models.SignInFailures, error) {
+	var sf models.SignInFailures
+	if err := s.getAndBindObject(parent, sfCollection, "_id", id, &sf); err != nil {
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+func (s *Store) AddSignInFailure(parent context.Context, id string, at, expireAt time.Time) (*models.SignInFailures, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var sf models.SignInFailures
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	// The count starts over if the failures have expired but haven't been removed yet.
+	count := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$gt", Value: bson.A{"$expireAt", time.Now()}}},
+		bson.D{{Key: "$add", Value: bson.A{"$count", 1}}},
+		1,
+	}}}
+	err := s.db.Collection(sfCollection).FindOneAndUpdate(ctx, bson.D{
+		{Key: "_id", Value: id},
+	}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "count", Value: count},
+			{Key: "lastFailureAt", Value: at},
+			{Key: "expireAt", Value: expireAt},
+		}}},
+	}, opts).Decode(&sf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+func (s *Store) ReleaseSignInFailure(parent context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	_, err := s.db.Collection(sfCollection).UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "count", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "count", Value: -1}}},
+	})
+
+	return err
+}
+
+func (s *Store) RemoveSignInFailures(parent context.Context, id string) error {
+	return s.removeObject(parent, sfCollection, id)
+}
+
 func (s *Store) GetAccessToken(parent context.Context, id string) (*models.AccessToken, error) {
 	var at models.AccessToken
 	if err := s.getAndBindObject(parent, atCollection, "_id", id, &at); err != nil {
@@ -226,6 +672,14 @@ func (s *Store) RemoveAccessToken(parent context.Context, id string) error {
 	return s.removeObject(parent, atCollection, id)
 }
 
+func (s *Store) RemoveAccessTokensByFamily(parent context.Context, familyID string) ([]string, error) {
+	return s.removeObjects(parent, atCollection, "familyID", familyID)
+}
+
+func (s *Store) RemoveAccessTokensByUser(parent context.Context, userID, keepFamilyID string) ([]string, error) {
+	return s.removeUserTokens(parent, atCollection, userID, keepFamilyID)
+}
+
 func (s *Store) GetRefreshToken(parent context.Context, id string) (*models.RefreshToken, error) {
 	var rt models.RefreshToken
 	if err := s.getAndBindObject(parent, rtCollection, "_id", id, &rt); err != nil {
@@ -242,3 +696,130 @@ func (s *Store) SaveRefreshToken(parent context.Context, rt *models.RefreshToken
 func (s *Store) RemoveRefreshToken(parent context.Context, id string) error {
 	return s.removeObject(parent, rtCollection, id)
 }
+
+func (s *Store) RemoveRefreshTokensByFamily(parent context.Context, familyID string) error {
+	_, err := s.removeObjects(parent, rtCollection, "familyID", familyID)
+
+	return err
+}
+
+func (s *Store) RemoveRefreshTokensByUser(parent context.Context, userID, keepFamilyID string) error {
+	_, err := s.removeUserTokens(parent, rtCollection, userID, keepFamilyID)
+
+	return err
+}
+
+func (s *Store) MarkRefreshTokenUsed(parent context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	filter := append(liveFilter(rtCollection, "_id", id), bson.E{Key: "used", Value: bson.D{{Key: "$ne", Value: true}}})
+	res, err := s.db.Collection(rtCollection).UpdateOne(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "used", Value: true}}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrorNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) GetClient(parent context.Context, id string) (*models.Client, error) {
+	var client models.Client
+	if err := s.getAndBindObject(parent, clCollection, "_id", id, &client); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s *Store) GetClients(parent context.Context) ([]*models.Client, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := s.db.Collection(clCollection).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []*models.Client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (s *Store) SaveClient(parent context.Context, client *models.Client) error {
+	return s.saveObject(parent, clCollection, client)
+}
+
+func (s *Store) UpdateClient(parent context.Context, client *models.Client) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	res, err := s.db.Collection(clCollection).ReplaceOne(ctx, bson.D{
+		{Key: "_id", Value: client.ID},
+	}, client)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrorNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) RemoveClient(parent context.Context, id string) error {
+	return s.removeObject(parent, clCollection, id)
+}
+
+func (s *Store) SaveAuthCode(parent context.Context, ac *models.AuthCode) error {
+	return s.saveObject(parent, acCollection, ac)
+}
+
+func (s *Store) ConsumeAuthCode(parent context.Context, id string) (*models.AuthCode, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var ac models.AuthCode
+	err := s.db.Collection(acCollection).FindOneAndDelete(ctx, liveFilter(acCollection, "_id", id)).Decode(&ac)
+	if err == mongo.ErrNoDocuments {
+		return nil, store.ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &ac, nil
+}
+
+func (s *Store) GetSigningKeys(parent context.Context) ([]*models.SigningKey, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := s.db.Collection(keyCollection).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*models.SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *Store) SaveSigningKey(parent context.Context, key *models.SigningKey) error {
+	return s.saveObject(parent, keyCollection, key)
+}
+
+func (s *Store) RemoveSigningKey(parent context.Context, id string) error {
+	return s.removeObject(parent, keyCollection, id)
+}
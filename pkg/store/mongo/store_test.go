@@ -9,9 +9,12 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 
 	"github.com/cybersamx/authx/pkg/config"
 	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/storetest"
 )
 
 var (
@@ -46,9 +49,12 @@ func clearMongo(t *testing.T, s *Store) {
 	ctx, cancel := newTestContext()
 	defer cancel()
 
-	collections := []string{userCollection, atCollection, rtCollection}
+	collections := []string{userCollection, atCollection, rtCollection, keyCollection, acCollection, clCollection,
+		invCollection, prCollection, sfCollection, mcCollection, wsCollection, wcCollection}
+	// The objects are removed rather than the collections dropped, which keeps the indexes.
 	for _, collect := range collections {
-		require.NoError(t, s.db.Collection(collect).Drop(ctx))
+		_, err := s.db.Collection(collect).DeleteMany(ctx, bson.D{})
+		require.NoError(t, err)
 	}
 }
 
@@ -81,7 +87,11 @@ func TestMain(m *testing.M) {
 		cfg.MongoAddr = dsn
 	}
 
-	ds = New(cfg)
+	var err error
+	ds, err = New(cfg)
+	if err != nil {
+		panic(err)
+	}
 	defer func() {
 		fmt.Println("Mongo tearing down...")
 		ds.Close()
@@ -133,7 +143,7 @@ func Test_SaveUser_UserExists(t *testing.T) {
 	ctx := context.Background()
 	err := ds.SaveUser(ctx, &testUser)
 
-	assert.Error(t, err)
+	assert.Equal(t, store.ErrDuplicate, err)
 }
 
 func Test_RemoveUser_UserExists(t *testing.T) {
@@ -203,3 +213,11 @@ func Test_RemoveAccessToken_TokenNotExists(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func Test_Conformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) store.DataStore {
+		clearMongo(t, ds)
+
+		return ds
+	})
+}
@@ -12,9 +12,17 @@ import (
 )
 
 type IndexOptions struct {
-	isTTL bool
+	isTTL    bool
+	isUnique bool
+	// isSparse leaves out the documents without the field, which are then not unique.
+	isSparse bool
+	// collation is the collation of the index, which the queries must have to use the index, if any.
+	collation *options.Collation
 }
 
+// duplicateKeyCodes are the codes of the errors that violate a unique index.
+var duplicateKeyCodes = map[int]bool{11000: true, 11001: true, 12582: true}
+
 const (
 	indexTimeout = 10 * time.Second
 )
@@ -44,13 +52,14 @@ func containsChildKey(m primitive.M, key string) bool {
 
 // indexExists checks to see if index for `field` in `collection` already exist.
 func indexExists(parent context.Context, collection *mongo.Collection, field string, opts ...*IndexOptions) (bool, error) {
-	var isTTL bool
+	var isTTL, isUnique bool
 	for _, opt := range opts {
 		if opt == nil {
 			continue
 		}
 
 		isTTL = opt.isTTL
+		isUnique = opt.isUnique
 	}
 
 	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
@@ -71,6 +80,10 @@ func indexExists(parent context.Context, collection *mongo.Collection, field str
 			if containsKey(m, "expireAfterSeconds") && containsChildKey(m, field) {
 				return true, nil
 			}
+		} else if isUnique {
+			if containsKey(m, "unique") && containsChildKey(m, field) {
+				return true, nil
+			}
 		} else {
 			if containsChildKey(m, field) {
 				return true, nil
@@ -83,13 +96,13 @@ func indexExists(parent context.Context, collection *mongo.Collection, field str
 
 // createIndex creates an index associated with `field` in `collection`.
 func createIndex(parent context.Context, collection *mongo.Collection, field string, opts ...*IndexOptions) (string, error) {
-	var isTTL bool
+	var idxOpts IndexOptions
 	for _, opt := range opts {
 		if opt == nil {
 			continue
 		}
 
-		isTTL = opt.isTTL
+		idxOpts = *opt
 	}
 
 	ok, err := indexExists(parent, collection, field, opts...)
@@ -108,10 +121,16 @@ func createIndex(parent context.Context, collection *mongo.Collection, field str
 		},
 	}
 
-	if isTTL {
+	if idxOpts.isTTL {
 		// TODO: We may want to check that the field is of type Date as it is needed for TTL to work properly.
 		model.Options = options.Index().SetExpireAfterSeconds(0)
 	}
+	if idxOpts.isUnique {
+		model.Options = options.Index().SetUnique(true).SetCollation(idxOpts.collation)
+		if idxOpts.isSparse {
+			model.Options.SetSparse(true)
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
 	defer cancel()
@@ -121,3 +140,19 @@ func createIndex(parent context.Context, collection *mongo.Collection, field str
 
 	return indexName, err
 }
+
+// isDuplicateKeyError returns true if the error is the violation of a unique index.
+func isDuplicateKeyError(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if duplicateKeyCodes[we.Code] {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return duplicateKeyCodes[int(e.Code)]
+	}
+
+	return false
+}
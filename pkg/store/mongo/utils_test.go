@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func Test_MaskDSN(t *testing.T) {
@@ -43,3 +44,11 @@ func Test_MaskDSN(t *testing.T) {
 		assert.Equal(t, tcase.uri, actual)
 	}
 }
+
+func Test_IsDuplicateKeyError(t *testing.T) {
+	assert.True(t, isDuplicateKeyError(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}))
+	assert.True(t, isDuplicateKeyError(mongo.CommandError{Code: 11000}))
+	assert.False(t, isDuplicateKeyError(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 2}}}))
+	assert.False(t, isDuplicateKeyError(mongo.ErrNoDocuments))
+	assert.False(t, isDuplicateKeyError(nil))
+}
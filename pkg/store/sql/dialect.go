@@ -0,0 +1,106 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+var (
+	ErrUnsupportedDialect = errors.New("unsupported sql dialect")
+)
+
+// dialect is what differs between the databases in the queries and the setup.
+type dialect struct {
+	driver string
+	// jsonType is the column type of the objects in JSON.
+	jsonType string
+	// numbered is true if the placeholders are numbered, eg. $1, rather than ?.
+	numbered bool
+	// lockMigrations is the statement that keeps other nodes from running the migrations at the same
+	// time, if any.
+	lockMigrations string
+	// maxOpenConns limits the connections, if it isn't 0.
+	maxOpenConns int
+	// uncancelable is true if the statements must not be canceled with their context.
+	uncancelable bool
+	// collate is the clause that sorts the text by bytes, as Mongo and Go do, if the default collation
+	// doesn't.
+	collate string
+	// duplicate returns true if the error is the violation of a unique index.
+	duplicate func(err error) bool
+}
+
+var dialects = map[string]*dialect{
+	// SQLite locks the whole database to write, so a single connection avoids the busy errors. Its
+	// driver may interrupt the next statement on the connection when the context of a statement that
+	// has finished is canceled, so the statements aren't canceled.
+	SQLite: {
+		driver:       "sqlite",
+		jsonType:     "TEXT",
+		maxOpenConns: 1,
+		uncancelable: true,
+		duplicate:    sqliteDuplicate,
+	},
+	Postgres: {
+		driver:         "postgres",
+		jsonType:       "JSONB",
+		numbered:       true,
+		lockMigrations: "LOCK TABLE schema_migrations IN EXCLUSIVE MODE",
+		collate:        ` COLLATE "C"`,
+		duplicate:      postgresDuplicate,
+	},
+}
+
+func sqliteDuplicate(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+
+	return serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || serr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func postgresDuplicate(err error) bool {
+	var perr *pq.Error
+	return errors.As(err, &perr) && perr.Code.Name() == "unique_violation"
+}
+
+// context returns the context of a statement for the driver.
+func (d *dialect) context(ctx context.Context) context.Context {
+	if d.uncancelable {
+		return context.Background()
+	}
+
+	return ctx
+}
+
+// rebind replaces the ? placeholders of the query with the ones of the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
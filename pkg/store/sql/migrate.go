@@ -0,0 +1,179 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	migrateTimeout = 60 * time.Second
+)
+
+// migration is a version of the schema. The migrations are applied in order, and each only once, so
+// a released migration must never change. Change the schema with a new migration instead.
+type migration struct {
+	version int
+	// statements returns the statements that migrate the schema from the previous version, if any.
+	statements func(d *dialect) []string
+}
+
+// The objects are saved as JSON in the data column, and the fields that are queried are copied to
+// columns. The times in the columns are in milliseconds since the epoch.
+var migrations = []migration{
+	{
+		version: 1,
+		statements: func(d *dialect) []string {
+			return []string{
+				`CREATE TABLE users (
+					id TEXT PRIMARY KEY,
+					username TEXT NOT NULL,
+					email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+					mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX users_username ON users (username)`,
+				`CREATE INDEX users_username_id ON users (username` + d.collate + `, id)`,
+				`CREATE TABLE invites (
+					id TEXT PRIMARY KEY,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE password_resets (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE INDEX password_resets_user_id ON password_resets (user_id)`,
+				`CREATE TABLE mfa_challenges (
+					id TEXT PRIMARY KEY,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE webauthn_sessions (
+					id TEXT PRIMARY KEY,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE webauthn_credentials (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL,
+					created_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE INDEX webauthn_credentials_user_id ON webauthn_credentials (user_id)`,
+				`CREATE TABLE signin_failures (
+					id TEXT PRIMARY KEY,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE access_tokens (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL,
+					family_id TEXT NOT NULL,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE INDEX access_tokens_user_id ON access_tokens (user_id)`,
+				`CREATE INDEX access_tokens_family_id ON access_tokens (family_id)`,
+				`CREATE TABLE refresh_tokens (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL,
+					family_id TEXT NOT NULL,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id)`,
+				`CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id)`,
+				`CREATE TABLE clients (
+					id TEXT PRIMARY KEY,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE auth_codes (
+					id TEXT PRIMARY KEY,
+					expire_at BIGINT NOT NULL,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE TABLE signing_keys (
+					id TEXT PRIMARY KEY,
+					created_at BIGINT NOT NULL,
+					predecessor_id TEXT,
+					data ` + d.jsonType + ` NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX signing_keys_predecessor_id ON signing_keys (predecessor_id)`,
+			}
+		},
+	},
+}
+
+// migrate applies the migrations that the database hasn't had yet.
+func migrate(parent context.Context, db *sql.DB, d *dialect) error {
+	ctx, cancel := context.WithTimeout(parent, migrateTimeout)
+	defer cancel()
+	ctx = d.context(ctx)
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if err := applyMigration(ctx, db, d, m); err != nil {
+			return fmt.Errorf("can't apply sql migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies the migration in a transaction, unless it has been applied.
+func applyMigration(ctx context.Context, db *sql.DB, d *dialect, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rolling back a committed transaction does nothing.
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if d.lockMigrations != "" {
+		if _, err := tx.ExecContext(ctx, d.lockMigrations); err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	err = tx.QueryRowContext(ctx, d.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), m.version).
+		Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	for _, stmt := range m.statements(d) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+		m.version, toMillis(time.Now()))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("applied sql migration %d\n", m.version)
+
+	return nil
+}
@@ -0,0 +1,949 @@
+// Package sql implements a data store on a SQL database with database/sql, SQLite for development
+// on a single node and Postgres for production. The schema is migrated when the store is created.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	// The drivers of the dialects.
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+const (
+	atomicTimeout = 15 * time.Second
+
+	// sweepInterval is how often the expired objects are removed, like the TTL monitor of Mongo.
+	sweepInterval = 60 * time.Second
+)
+
+// columnNames maps the fields that are copied to columns to the column names.
+var columnNames = map[string]string{
+	"ID":            "id",
+	"Username":      "username",
+	"UserID":        "user_id",
+	"FamilyID":      "family_id",
+	"ExpireAt":      "expire_at",
+	"CreatedAt":     "created_at",
+	"PredecessorID": "predecessor_id",
+	// The fields of embedded structs are dotted.
+	"EmailVerified": "email_verified",
+	"MFA.Enabled":   "mfa_enabled",
+}
+
+// table is a table of objects, which has the id, the object in JSON and the fields that are queried.
+type table struct {
+	name string
+	// fields are the fields that are copied to columns.
+	fields []string
+}
+
+var (
+	userTable = &table{name: "users", fields: []string{"Username", "EmailVerified", "MFA.Enabled"}}
+	invTable  = &table{name: "invites", fields: []string{"ExpireAt"}}
+	prTable   = &table{name: "password_resets", fields: []string{"UserID", "ExpireAt"}}
+	mcTable   = &table{name: "mfa_challenges", fields: []string{"ExpireAt"}}
+	wsTable   = &table{name: "webauthn_sessions", fields: []string{"ExpireAt"}}
+	wcTable   = &table{name: "webauthn_credentials", fields: []string{"UserID", "CreatedAt"}}
+	sfTable   = &table{name: "signin_failures", fields: []string{"ExpireAt"}}
+	atTable   = &table{name: "access_tokens", fields: []string{"UserID", "FamilyID", "ExpireAt"}}
+	rtTable   = &table{name: "refresh_tokens", fields: []string{"UserID", "FamilyID", "ExpireAt"}}
+	clTable   = &table{name: "clients"}
+	acTable   = &table{name: "auth_codes", fields: []string{"ExpireAt"}}
+	keyTable  = &table{name: "signing_keys", fields: []string{"CreatedAt", "PredecessorID"}}
+
+	tables = []*table{userTable, invTable, prTable, mcTable, wsTable, wcTable, sfTable, atTable, rtTable, clTable,
+		acTable, keyTable}
+)
+
+// expires returns true if the objects of the table expire at their ExpireAt field.
+func (t *table) expires() bool {
+	for _, field := range t.fields {
+		if field == "ExpireAt" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// columns returns the names of the columns of the fields.
+func (t *table) columns() []string {
+	cols := make([]string, 0, len(t.fields))
+	for _, field := range t.fields {
+		cols = append(cols, columnNames[field])
+	}
+
+	return cols
+}
+
+// values returns the values of the columns of the fields of the object.
+func (t *table) values(obj interface{}) []interface{} {
+	vals := make([]interface{}, 0, len(t.fields))
+	for _, field := range t.fields {
+		vals = append(vals, fieldValue(obj, field))
+	}
+
+	return vals
+}
+
+// fieldValue returns the value of the column of the field of the object that obj points to. The
+// times are in milliseconds since the epoch.
+func fieldValue(obj interface{}, field string) interface{} {
+	v := reflect.ValueOf(obj).Elem()
+	for _, name := range strings.Split(field, ".") {
+		v = v.FieldByName(name)
+	}
+
+	if tm, ok := v.Interface().(time.Time); ok {
+		return toMillis(tm)
+	}
+
+	return v.Interface()
+}
+
+// toMillis returns the milliseconds since the epoch, as Mongo keeps the times.
+func toMillis(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+}
+
+// resetObject sets the object that obj points to to its zero value, so that no field is left over
+// when it's bound again.
+func resetObject(obj interface{}) {
+	v := reflect.ValueOf(obj).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
+// --- Store ---
+
+type Store struct {
+	db      *sql.DB
+	dialect *dialect
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New connects to the database of the dialect, SQLite or Postgres, at the dsn and migrates its
+// schema.
+func New(dialectName, dsn string) (*Store, error) {
+	d, ok := dialects[dialectName]
+	if !ok {
+		return nil, ErrUnsupportedDialect
+	}
+
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.maxOpenConns > 0 {
+		db.SetMaxOpenConns(d.maxOpenConns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), atomicTimeout)
+	defer cancel()
+	if err := db.PingContext(d.context(ctx)); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrate(ctx, db, d); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	log.Printf("connected to %s successfully\n", dialectName)
+
+	s := Store{
+		db:      db,
+		dialect: d,
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.sweepExpired()
+
+	return &s, nil
+}
+
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		if err := s.db.Close(); err != nil {
+			log.Printf("can't close the sql database: %v\n", err)
+		}
+	})
+}
+
+// sweepExpired removes the expired objects periodically, as SQL has no TTL indexes, until the store
+// is closed.
+func (s *Store) sweepExpired() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if err := s.sweep(context.Background(), now); err != nil {
+				log.Printf("can't remove the expired objects: %v\n", err)
+			}
+		}
+	}
+}
+
+// sweep removes the objects that have expired by now.
+func (s *Store) sweep(parent context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	for _, t := range tables {
+		if !t.expires() {
+			continue
+		}
+		if _, err := s.exec(ctx, "DELETE FROM "+t.name+" WHERE expire_at <= ?", toMillis(now)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(s.dialect.context(ctx), s.dialect.rebind(query), args...)
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(s.dialect.context(ctx), s.dialect.rebind(query), args...)
+}
+
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(s.dialect.context(ctx), s.dialect.rebind(query), args...)
+}
+
+// where returns the where clause of the condition, if any, which also leaves out the expired objects
+// of the table, and its arguments.
+func where(t *table, cond string, args ...interface{}) (string, []interface{}) {
+	if t.expires() {
+		if cond != "" {
+			cond += " AND "
+		}
+		cond += "expire_at > ?"
+		args = append(args, toMillis(time.Now()))
+	}
+	if cond == "" {
+		return "", args
+	}
+
+	return " WHERE " + cond, args
+}
+
+// findObjects returns the objects in the table, whose field equals val, or all of them if the field
+// is empty, in JSON sorted by sortField.
+func (s *Store) findObjects(parent context.Context, t *table, field, val, sortField string) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	var clause string
+	var args []interface{}
+	if field != "" {
+		clause, args = where(t, columnNames[field]+" = ?", val)
+	} else {
+		clause, args = where(t, "")
+	}
+
+	return s.queryObjects(ctx, "SELECT data FROM "+t.name+clause+" ORDER BY "+columnNames[sortField], args...)
+}
+
+// queryObjects returns the objects in JSON that the query selects.
+func (s *Store) queryObjects(ctx context.Context, query string, args ...interface{}) ([][]byte, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs [][]byte
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		docs = append(docs, data)
+	}
+
+	return docs, rows.Err()
+}
+
+// queryIDs returns the ids that the query selects.
+func (s *Store) queryIDs(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) getAndBindObject(parent context.Context, t *table, field, val string, obj interface{}) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	clause, args := where(t, columnNames[field]+" = ?", val)
+	var data []byte
+	err := s.queryRow(ctx, "SELECT data FROM "+t.name+clause+" ORDER BY id LIMIT 1", args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return store.ErrorNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, obj)
+}
+
+func (s *Store) saveObject(parent context.Context, t *table, obj interface{}) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	id := fieldValue(obj, "ID").(string)
+
+	// An expired object that hasn't been swept yet doesn't keep the id.
+	if t.expires() {
+		if _, err := s.exec(ctx, "DELETE FROM "+t.name+" WHERE id = ? AND expire_at <= ?", id, toMillis(time.Now())); err != nil {
+			return err
+		}
+	}
+
+	cols := append(append([]string{"id"}, t.columns()...), "data")
+	args := append(append([]interface{}{id}, t.values(obj)...), string(data))
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	// Nothing is inserted if the id, or a unique field, is taken.
+	res, err := s.exec(ctx, "INSERT INTO "+t.name+" ("+strings.Join(cols, ", ")+") VALUES ("+placeholders+
+		") ON CONFLICT DO NOTHING", args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrDuplicate
+	}
+
+	return nil
+}
+
+// setObject replaces the object of the id with obj, if cond holds. It returns true if it did.
+func (s *Store) setObject(ctx context.Context, t *table, id string, obj interface{}, cond string,
+	condArgs ...interface{}) (bool, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+
+	var sets []string
+	for _, col := range append(t.columns(), "data") {
+		sets = append(sets, col+" = ?")
+	}
+	clause, args := where(t, "id = ?"+cond, append([]interface{}{id}, condArgs...)...)
+	args = append(append(t.values(obj), string(data)), args...)
+	res, err := s.exec(ctx, "UPDATE "+t.name+" SET "+strings.Join(sets, ", ")+clause, args...)
+	if err != nil && s.dialect.duplicate(err) {
+		return false, store.ErrDuplicate
+	} else if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// replaceObject replaces the object with the same id. It returns ErrorNotFound if there's none.
+func (s *Store) replaceObject(parent context.Context, t *table, id string, obj interface{}) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	ok, err := s.setObject(ctx, t, id, obj, "")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return store.ErrorNotFound
+	}
+
+	return nil
+}
+
+// updateObject binds the object of the id and saves it if update returns true. It returns
+// ErrorNotFound if there's no object or update returns false. The object is only saved if it hasn't
+// changed since it was bound, otherwise the update is retried, so that no update is lost.
+func (s *Store) updateObject(parent context.Context, t *table, id string, obj interface{}, update func() bool) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	clause, args := where(t, "id = ?", id)
+	for {
+		var data []byte
+		err := s.queryRow(ctx, "SELECT data FROM "+t.name+clause, args...).Scan(&data)
+		if err == sql.ErrNoRows {
+			return store.ErrorNotFound
+		} else if err != nil {
+			return err
+		}
+
+		resetObject(obj)
+		if err := json.Unmarshal(data, obj); err != nil {
+			return err
+		}
+		if !update() {
+			return store.ErrorNotFound
+		}
+
+		ok, err := s.setObject(ctx, t, id, obj, " AND data = ?", string(data))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// consumeObject atomically binds and removes the object of the id.
+func (s *Store) consumeObject(parent context.Context, t *table, id string, obj interface{}) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	clause, args := where(t, "id = ?", id)
+	var data []byte
+	err := s.queryRow(ctx, "DELETE FROM "+t.name+clause+" RETURNING data", args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return store.ErrorNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, obj)
+}
+
+// removeObjects removes the objects in the table whose field equals val, and returns their ids.
+func (s *Store) removeObjects(parent context.Context, t *table, field, val string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	return s.queryIDs(ctx, "DELETE FROM "+t.name+" WHERE "+columnNames[field]+" = ? RETURNING id", val)
+}
+
+func (s *Store) removeObject(parent context.Context, t *table, id string) error {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	_, err := s.exec(ctx, "DELETE FROM "+t.name+" WHERE id = ?", id)
+
+	return err
+}
+
+// removeUserTokens removes the tokens of the user in the table, except the ones in the family
+// keepFamilyID if it isn't empty, and returns their ids.
+func (s *Store) removeUserTokens(parent context.Context, t *table, userID, keepFamilyID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	query := "DELETE FROM " + t.name + " WHERE user_id = ?"
+	args := []interface{}{userID}
+	if keepFamilyID != "" {
+		query += " AND family_id <> ?"
+		args = append(args, keepFamilyID)
+	}
+
+	return s.queryIDs(ctx, query+" RETURNING id", args...)
+}
+
+func (s *Store) GetUser(parent context.Context, id string) (*models.User, error) {
+	var user models.User
+	if err := s.getAndBindObject(parent, userTable, "ID", id, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *Store) GetUserByUsername(parent context.Context, username string) (*models.User, error) {
+	var user models.User
+	if err := s.getAndBindObject(parent, userTable, "Username", store.NormalizeUsername(username), &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *Store) SaveUser(parent context.Context, user *models.User) error {
+	user.Username = store.NormalizeUsername(user.Username)
+	return s.saveObject(parent, userTable, user)
+}
+
+func (s *Store) UpdateUser(parent context.Context, user *models.User) error {
+	user.Username = store.NormalizeUsername(user.Username)
+	var saved models.User
+	conflict := false
+	err := s.updateObject(parent, userTable, user.ID, &saved, func() bool {
+		if saved.Version != user.Version {
+			conflict = true
+			return false
+		}
+		saved = *user
+		saved.Version++
+
+		return true
+	})
+	if conflict {
+		return store.ErrConflict
+	} else if err != nil {
+		return err
+	}
+	user.Version = saved.Version
+
+	return nil
+}
+
+func (s *Store) RemoveUser(parent context.Context, id string) error {
+	return s.removeObject(parent, userTable, id)
+}
+
+// userCondition returns the condition of the users that the filter selects, if any, and its
+// arguments.
+func userCondition(filter *store.UserFilter) (string, []interface{}) {
+	if filter == nil {
+		return "", nil
+	}
+
+	var conds []string
+	var args []interface{}
+	if prefix := store.NormalizeUsername(filter.UsernamePrefix); prefix != "" {
+		conds = append(conds, "substr(username, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(prefix), prefix)
+	}
+	if filter.EmailVerified != nil {
+		conds = append(conds, "email_verified = ?")
+		args = append(args, *filter.EmailVerified)
+	}
+	if filter.MFAEnabled != nil {
+		conds = append(conds, "mfa_enabled = ?")
+		args = append(args, *filter.MFAEnabled)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+func (s *Store) ListUsers(parent context.Context, filter *store.UserFilter, cursor string, limit int) ([]*models.User, string, error) {
+	after, err := store.ParseUserCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	cond, args := userCondition(filter)
+	username := "username" + s.dialect.collate
+	if after != nil {
+		if cond != "" {
+			cond += " AND "
+		}
+		cond += "(" + username + " > ? OR (username = ? AND id > ?))"
+		args = append(args, after.Username, after.Username, after.ID)
+	}
+	clause, args := where(userTable, cond, args...)
+	query := "SELECT data FROM users" + clause + " ORDER BY " + username + ", id"
+	if limit > 0 {
+		// One more user tells if there's a next page.
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	docs, err := s.queryObjects(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := make([]*models.User, 0, len(docs))
+	for _, data := range docs {
+		var user models.User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, "", err
+		}
+		users = append(users, &user)
+	}
+	if limit <= 0 || len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+
+	return users, store.NewUserCursor(users[limit-1]).String(), nil
+}
+
+func (s *Store) CountUsers(parent context.Context, filter *store.UserFilter) (int64, error) {
+	ctx, cancel := context.WithTimeout(parent, atomicTimeout)
+	defer cancel()
+
+	cond, args := userCondition(filter)
+	clause, args := where(userTable, cond, args...)
+	var count int64
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM users"+clause, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *Store) SaveInvite(parent context.Context, invite *models.Invite) error {
+	return s.saveObject(parent, invTable, invite)
+}
+
+func (s *Store) ConsumeInvite(parent context.Context, id string) (*models.Invite, error) {
+	var invite models.Invite
+	if err := s.consumeObject(parent, invTable, id, &invite); err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (s *Store) GetPasswordReset(parent context.Context, id string) (*models.PasswordReset, error) {
+	var pr models.PasswordReset
+	if err := s.getAndBindObject(parent, prTable, "ID", id, &pr); err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) SavePasswordReset(parent context.Context, pr *models.PasswordReset) error {
+	return s.saveObject(parent, prTable, pr)
+}
+
+func (s *Store) ConsumePasswordReset(parent context.Context, id string) (*models.PasswordReset, error) {
+	var pr models.PasswordReset
+	if err := s.consumeObject(parent, prTable, id, &pr); err != nil {
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+func (s *Store) RemovePasswordResetsByUser(parent context.Context, userID string) error {
+	_, err := s.removeObjects(parent, prTable, "UserID", userID)
+
+	return err
+}
+
+func (s *Store) GetMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error) {
+	var mc models.MFAChallenge
+	if err := s.getAndBindObject(parent, mcTable, "ID", id, &mc); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveMFAChallenge(parent context.Context, mc *models.MFAChallenge) error {
+	return s.saveObject(parent, mcTable, mc)
+}
+
+func (s *Store) ConsumeMFAChallenge(parent context.Context, id string) (*models.MFAChallenge, error) {
+	var mc models.MFAChallenge
+	if err := s.consumeObject(parent, mcTable, id, &mc); err != nil {
+		return nil, err
+	}
+
+	return &mc, nil
+}
+
+func (s *Store) SaveWebAuthnSession(parent context.Context, ws *models.WebAuthnSession) error {
+	return s.saveObject(parent, wsTable, ws)
+}
+
+func (s *Store) ConsumeWebAuthnSession(parent context.Context, id string) (*models.WebAuthnSession, error) {
+	var ws models.WebAuthnSession
+	if err := s.consumeObject(parent, wsTable, id, &ws); err != nil {
+		return nil, err
+	}
+
+	return &ws, nil
+}
+
+func (s *Store) GetWebAuthnCredential(parent context.Context, id string) (*models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	if err := s.getAndBindObject(parent, wcTable, "ID", id, &cred); err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+func (s *Store) GetWebAuthnCredentialsByUser(parent context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	docs, err := s.findObjects(parent, wcTable, "UserID", userID, "CreatedAt")
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []*models.WebAuthnCredential
+	for _, data := range docs {
+		var cred models.WebAuthnCredential
+		if err := json.Unmarshal(data, &cred); err != nil {
+			return nil, err
+		}
+		creds = append(creds, &cred)
+	}
+
+	return creds, nil
+}
+
+func (s *Store) SaveWebAuthnCredential(parent context.Context, cred *models.WebAuthnCredential) error {
+	return s.saveObject(parent, wcTable, cred)
+}
+
+func (s *Store) UpdateWebAuthnSignCount(parent context.Context, id string, signCount uint32, usedAt time.Time) error {
+	var cred models.WebAuthnCredential
+	return s.updateObject(parent, wcTable, id, &cred, func() bool {
+		if signCount <= cred.SignCount && (signCount != 0 || cred.SignCount != 0) {
+			return false
+		}
+		cred.SignCount = signCount
+		cred.LastUsedAt = usedAt
+
+		return true
+	})
+}
+
+func (s *Store) RemoveWebAuthnCredential(parent context.Context, id string) error {
+	return s.removeObject(parent, wcTable, id)
+}
+
+func (s *Store) GetSignInFailures(parent context.Context, id string) (*models.SignInFailures, error) {
+	var sf models.SignInFailures
+	if err := s.getAndBindObject(parent, sfTable, "ID", id, &sf); err != nil {
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+func (s *Store) AddSignInFailure(parent context.Context, id string, at, expireAt time.Time) (*models.SignInFailures, error) {
+	for {
+		var sf models.SignInFailures
+		err := s.updateObject(parent, sfTable, id, &sf, func() bool {
+			sf.Count++
+			sf.LastFailureAt = at
+			sf.ExpireAt = expireAt
+
+			return true
+		})
+		if err == nil {
+			return &sf, nil
+		} else if err != store.ErrorNotFound {
+			return nil, err
+		}
+
+		// It's the first failure, unless another one has just been saved.
+		sf = models.SignInFailures{ID: id, Count: 1, LastFailureAt: at, ExpireAt: expireAt}
+		err = s.saveObject(parent, sfTable, &sf)
+		if err == nil {
+			return &sf, nil
+		} else if err != store.ErrDuplicate {
+			return nil, err
+		}
+	}
+}
+
+func (s *Store) ReleaseSignInFailure(parent context.Context, id string) error {
+	var sf models.SignInFailures
+	err := s.updateObject(parent, sfTable, id, &sf, func() bool {
+		if sf.Count == 0 {
+			return false
+		}
+		sf.Count--
+
+		return true
+	})
+	if err == store.ErrorNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Store) RemoveSignInFailures(parent context.Context, id string) error {
+	return s.removeObject(parent, sfTable, id)
+}
+
+func (s *Store) GetAccessToken(parent context.Context, id string) (*models.AccessToken, error) {
+	var at models.AccessToken
+	if err := s.getAndBindObject(parent, atTable, "ID", id, &at); err != nil {
+		return nil, err
+	}
+
+	return &at, nil
+}
+
+func (s *Store) SaveAccessToken(parent context.Context, at *models.AccessToken) error {
+	return s.saveObject(parent, atTable, at)
+}
+
+func (s *Store) RemoveAccessToken(parent context.Context, id string) error {
+	return s.removeObject(parent, atTable, id)
+}
+
+func (s *Store) RemoveAccessTokensByFamily(parent context.Context, familyID string) ([]string, error) {
+	return s.removeObjects(parent, atTable, "FamilyID", familyID)
+}
+
+func (s *Store) RemoveAccessTokensByUser(parent context.Context, userID, keepFamilyID string) ([]string, error) {
+	return s.removeUserTokens(parent, atTable, userID, keepFamilyID)
+}
+
+func (s *Store) GetRefreshToken(parent context.Context, id string) (*models.RefreshToken, error) {
+	var rt models.RefreshToken
+	if err := s.getAndBindObject(parent, rtTable, "ID", id, &rt); err != nil {
+		return nil, err
+	}
+
+	return &rt, nil
+}
+
+func (s *Store) SaveRefreshToken(parent context.Context, rt *models.RefreshToken) error {
+	return s.saveObject(parent, rtTable, rt)
+}
+
+func (s *Store) RemoveRefreshToken(parent context.Context, id string) error {
+	return s.removeObject(parent, rtTable, id)
+}
+
+func (s *Store) RemoveRefreshTokensByFamily(parent context.Context, familyID string) error {
+	_, err := s.removeObjects(parent, rtTable, "FamilyID", familyID)
+
+	return err
+}
+
+func (s *Store) RemoveRefreshTokensByUser(parent context.Context, userID, keepFamilyID string) error {
+	_, err := s.removeUserTokens(parent, rtTable, userID, keepFamilyID)
+
+	return err
+}
+
+func (s *Store) MarkRefreshTokenUsed(parent context.Context, id string) error {
+	var rt models.RefreshToken
+	return s.updateObject(parent, rtTable, id, &rt, func() bool {
+		if rt.Used {
+			return false
+		}
+		rt.Used = true
+
+		return true
+	})
+}
+
+func (s *Store) GetClient(parent context.Context, id string) (*models.Client, error) {
+	var client models.Client
+	if err := s.getAndBindObject(parent, clTable, "ID", id, &client); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s *Store) GetClients(parent context.Context) ([]*models.Client, error) {
+	docs, err := s.findObjects(parent, clTable, "", "", "ID")
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []*models.Client
+	for _, data := range docs {
+		var client models.Client
+		if err := json.Unmarshal(data, &client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, &client)
+	}
+
+	return clients, nil
+}
+
+func (s *Store) SaveClient(parent context.Context, client *models.Client) error {
+	return s.saveObject(parent, clTable, client)
+}
+
+func (s *Store) UpdateClient(parent context.Context, client *models.Client) error {
+	return s.replaceObject(parent, clTable, client.ID, client)
+}
+
+func (s *Store) RemoveClient(parent context.Context, id string) error {
+	return s.removeObject(parent, clTable, id)
+}
+
+func (s *Store) SaveAuthCode(parent context.Context, ac *models.AuthCode) error {
+	return s.saveObject(parent, acTable, ac)
+}
+
+func (s *Store) ConsumeAuthCode(parent context.Context, id string) (*models.AuthCode, error) {
+	var ac models.AuthCode
+	if err := s.consumeObject(parent, acTable, id, &ac); err != nil {
+		return nil, err
+	}
+
+	return &ac, nil
+}
+
+func (s *Store) GetSigningKeys(parent context.Context) ([]*models.SigningKey, error) {
+	docs, err := s.findObjects(parent, keyTable, "", "", "CreatedAt")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*models.SigningKey
+	for _, data := range docs {
+		var key models.SigningKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+func (s *Store) SaveSigningKey(parent context.Context, key *models.SigningKey) error {
+	return s.saveObject(parent, keyTable, key)
+}
+
+func (s *Store) RemoveSigningKey(parent context.Context, id string) error {
+	return s.removeObject(parent, keyTable, id)
+}
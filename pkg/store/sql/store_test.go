@@ -0,0 +1,147 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+	"github.com/cybersamx/authx/pkg/store/storetest"
+)
+
+// testDSNs returns the databases to test by dialect. SQLite is always tested, Postgres only if
+// AX_POSTGRES_ADDR is set.
+func testDSNs(t *testing.T) map[string]string {
+	dsns := map[string]string{
+		SQLite: filepath.Join(t.TempDir(), "authx.db"),
+	}
+	if addr := os.Getenv("AX_POSTGRES_ADDR"); addr != "" {
+		dsns[Postgres] = addr
+	}
+
+	return dsns
+}
+
+// clearTables removes all the objects in the store.
+func clearTables(t *testing.T, s *Store) {
+	for _, tbl := range tables {
+		_, err := s.exec(context.Background(), "DELETE FROM "+tbl.name)
+		require.NoError(t, err)
+	}
+}
+
+// runStores runs the test with an empty store of each dialect.
+func runStores(t *testing.T, test func(t *testing.T, s *Store)) {
+	for dialect, dsn := range testDSNs(t) {
+		dsn := dsn
+		t.Run(dialect, func(t *testing.T) {
+			s, err := New(dialect, dsn)
+			require.NoError(t, err)
+			defer s.Close()
+
+			clearTables(t, s)
+			test(t, s)
+		})
+	}
+}
+
+func Test_Rebind(t *testing.T) {
+	query := "SELECT data FROM users WHERE id = ? AND username = ?"
+	assert.Equal(t, query, dialects[SQLite].rebind(query))
+	assert.Equal(t, "SELECT data FROM users WHERE id = $1 AND username = $2", dialects[Postgres].rebind(query))
+}
+
+func Test_Migrate(t *testing.T) {
+	runStores(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+
+		// The migrations are only applied once.
+		require.NoError(t, migrate(ctx, s.db, s.dialect))
+		var count, version int
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*), MAX(version) FROM schema_migrations").Scan(&count, &version)
+		require.NoError(t, err)
+		assert.Equal(t, len(migrations), count)
+		assert.Equal(t, migrations[len(migrations)-1].version, version)
+	})
+}
+
+func Test_JSONObjects(t *testing.T) {
+	runStores(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		user := models.User{ID: "user1", Username: "chan", Claims: map[string]interface{}{"roles": []interface{}{"admin"}}}
+		require.NoError(t, s.SaveUser(ctx, &user))
+
+		// The objects are saved as JSON.
+		var data string
+		require.NoError(t, s.db.QueryRowContext(ctx, "SELECT data FROM users").Scan(&data))
+		var saved models.User
+		require.NoError(t, json.Unmarshal([]byte(data), &saved))
+		assert.Equal(t, user, saved)
+	})
+}
+
+func Test_Conformance(t *testing.T) {
+	for dialect, dsn := range testDSNs(t) {
+		dialect, dsn := dialect, dsn
+		t.Run(dialect, func(t *testing.T) {
+			s, err := New(dialect, dsn)
+			require.NoError(t, err)
+			defer s.Close()
+
+			storetest.RunConformance(t, func(t *testing.T) store.DataStore {
+				clearTables(t, s)
+
+				return s
+			})
+		})
+	}
+}
+
+func Test_Expiry(t *testing.T) {
+	runStores(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		require.NoError(t, s.SaveAccessToken(ctx, &models.AccessToken{
+			ID:       "at1",
+			UserID:   "user1",
+			ExpireAt: time.Now().Add(-time.Second),
+		}))
+		require.NoError(t, s.SaveAuthCode(ctx, &models.AuthCode{
+			ID:       "ac1",
+			ExpireAt: time.Now().Add(-time.Second),
+		}))
+		require.NoError(t, s.SaveAuthCode(ctx, &models.AuthCode{
+			ID:       "ac2",
+			ExpireAt: time.Now().Add(time.Hour),
+		}))
+
+		// The expired objects are gone before they are swept.
+		_, err := s.GetAccessToken(ctx, "at1")
+		assert.Equal(t, store.ErrorNotFound, err)
+		_, err = s.ConsumeAuthCode(ctx, "ac1")
+		assert.Equal(t, store.ErrorNotFound, err)
+
+		// An expired object that hasn't been swept can be replaced.
+		at := models.AccessToken{ID: "at1", UserID: "user1", ExpireAt: time.Now().Add(time.Hour)}
+		require.NoError(t, s.SaveAccessToken(ctx, &at))
+		got, err := s.GetAccessToken(ctx, "at1")
+		require.NoError(t, err)
+		assert.True(t, at.ExpireAt.Equal(got.ExpireAt))
+		assert.Equal(t, store.ErrDuplicate, s.SaveAccessToken(ctx, &at))
+
+		// The sweep removes the objects that have expired.
+		require.NoError(t, s.sweep(ctx, time.Now()))
+		var count int
+		require.NoError(t, s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auth_codes").Scan(&count))
+		assert.Equal(t, 1, count)
+		require.NoError(t, s.sweep(ctx, time.Now().Add(2*time.Hour)))
+		require.NoError(t, s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auth_codes").Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+}
@@ -0,0 +1,891 @@
+// Package storetest is a conformance test suite of the store.DataStore implementations, so that all
+// of them behave the same. Every implementation runs it in its tests:
+//
+//	func Test_Conformance(t *testing.T) {
+//		storetest.RunConformance(t, func(t *testing.T) store.DataStore {
+//			return newEmptyStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+	"github.com/cybersamx/authx/pkg/store"
+)
+
+// concurrency is the number of goroutines that access a store at the same time.
+const concurrency = 20
+
+// Factory returns an empty data store for a test. The factory cleans up the store, eg. with
+// t.Cleanup, as the suite doesn't close it.
+type Factory func(t *testing.T) store.DataStore
+
+// RunConformance runs the tests of every DataStore method as subtests, each with a new store from the
+// factory.
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ds store.DataStore)
+	}{
+		{"Users", testUsers},
+		{"Usernames", testUsernames},
+		{"ListUsers", testListUsers},
+		{"CountUsers", testCountUsers},
+		{"Invites", testInvites},
+		{"PasswordResets", testPasswordResets},
+		{"MFAChallenges", testMFAChallenges},
+		{"WebAuthnSessions", testWebAuthnSessions},
+		{"WebAuthnCredentials", testWebAuthnCredentials},
+		{"WebAuthnSignCount", testWebAuthnSignCount},
+		{"SignInFailures", testSignInFailures},
+		{"AccessTokens", testAccessTokens},
+		{"RefreshTokens", testRefreshTokens},
+		{"Clients", testClients},
+		{"AuthCodes", testAuthCodes},
+		{"SigningKeys", testSigningKeys},
+		{"Expiry", testExpiry},
+		{"ConcurrentConsumes", testConcurrentConsumes},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+// now returns the current time as the stores keep it, in UTC and truncated to milliseconds, so that
+// the saved objects can be compared with the ones read back.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// runConcurrently runs fn in concurrent goroutines and returns the number of calls that succeeded.
+func runConcurrently(fn func(i int) error) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if fn(i) == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return succeeded
+}
+
+func testUsers(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	user := models.User{
+		ID:            "user1",
+		Username:      "chan",
+		Name:          "Chan",
+		Email:         "chan@example.com",
+		EmailVerified: true,
+		Password:      "hash",
+		Salt:          "salt",
+		MFA: models.MFA{
+			Enabled:       true,
+			TOTPSecret:    "secret",
+			LastTOTPStep:  1,
+			RecoveryCodes: []string{"code1", "code2"},
+		},
+	}
+
+	_, err := ds.GetUser(ctx, user.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.GetUserByUsername(ctx, user.Username)
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.Equal(t, store.ErrorNotFound, ds.UpdateUser(ctx, &user))
+
+	require.NoError(t, ds.SaveUser(ctx, &user))
+	assert.Equal(t, store.ErrDuplicate, ds.SaveUser(ctx, &user))
+
+	got, err := ds.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user, *got)
+	got, err = ds.GetUserByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, user, *got)
+
+	// The store keeps a copy of the user.
+	got.MFA.RecoveryCodes[0] = "changed"
+	user.Name = "changed"
+	got, err = ds.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Chan", got.Name)
+	assert.Equal(t, "code1", got.MFA.RecoveryCodes[0])
+
+	// The claims are kept, though the slices may be read back as a different type.
+	user.Claims = map[string]interface{}{"roles": []string{"admin"}}
+	user.Username = "chan2"
+	require.NoError(t, ds.UpdateUser(ctx, &user))
+	got, err = ds.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "chan2", got.Username)
+	assert.Equal(t, "changed", got.Name)
+	assert.Len(t, got.Claims["roles"], 1)
+	_, err = ds.GetUserByUsername(ctx, "chan")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	// The update increments the version, so an update of a stale copy fails.
+	assert.Equal(t, int64(1), user.Version)
+	assert.Equal(t, int64(1), got.Version)
+	stale := *got
+	got.Name = "Chan"
+	require.NoError(t, ds.UpdateUser(ctx, got))
+	assert.Equal(t, int64(2), got.Version)
+	stale.Name = "stale"
+	assert.Equal(t, store.ErrConflict, ds.UpdateUser(ctx, &stale))
+	assert.Equal(t, int64(1), stale.Version)
+	got, err = ds.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Chan", got.Name)
+	assert.Equal(t, int64(2), got.Version)
+
+	require.NoError(t, ds.RemoveUser(ctx, user.ID))
+	_, err = ds.GetUser(ctx, user.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+	// Removing what doesn't exist isn't an error.
+	assert.NoError(t, ds.RemoveUser(ctx, user.ID))
+}
+
+// testUsernames checks that the usernames are normalized and unique regardless of case.
+func testUsernames(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	user := models.User{ID: "user1", Username: " Chan "}
+	require.NoError(t, ds.SaveUser(ctx, &user))
+	assert.Equal(t, "chan", user.Username)
+
+	for _, username := range []string{"chan", "CHAN", " cHaN", "ｃｈａｎ"} {
+		got, err := ds.GetUserByUsername(ctx, username)
+		require.NoError(t, err, username)
+		assert.Equal(t, user.ID, got.ID, username)
+		assert.Equal(t, "chan", got.Username, username)
+
+		assert.Equal(t, store.ErrDuplicate, ds.SaveUser(ctx, &models.User{ID: "user2", Username: username}), username)
+	}
+
+	other := models.User{ID: "user2", Username: "Straße"}
+	require.NoError(t, ds.SaveUser(ctx, &other))
+	assert.Equal(t, "strasse", other.Username)
+	got, err := ds.GetUserByUsername(ctx, "STRASSE")
+	require.NoError(t, err)
+	assert.Equal(t, other.ID, got.ID)
+
+	// A user can't be renamed to the username of another user, but can change its case.
+	got.Username = "Chan"
+	assert.Equal(t, store.ErrDuplicate, ds.UpdateUser(ctx, got))
+	user.Username = "CHAN"
+	require.NoError(t, ds.UpdateUser(ctx, &user))
+	assert.Equal(t, "chan", user.Username)
+
+	// The username of a removed user can be taken.
+	require.NoError(t, ds.RemoveUser(ctx, user.ID))
+	require.NoError(t, ds.SaveUser(ctx, &models.User{ID: "user3", Username: "Chan"}))
+
+	users, _, err := ds.ListUsers(ctx, &store.UserFilter{UsernamePrefix: " CH"}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "user3", users[0].ID)
+}
+
+// newUsers saves the users with the usernames and returns them, sorted as ListUsers sorts them. The
+// users with an odd index have verified their email address, and the ones with an index that is a
+// multiple of 3 have enabled the MFA.
+func newUsers(t *testing.T, ds store.DataStore, usernames ...string) []*models.User {
+	users := make([]*models.User, 0, len(usernames))
+	for i, username := range usernames {
+		user := models.User{
+			ID:            fmt.Sprintf("user%02d", i),
+			Username:      username,
+			EmailVerified: i%2 == 1,
+			MFA:           models.MFA{Enabled: i%3 == 0},
+		}
+		require.NoError(t, ds.SaveUser(context.Background(), &user))
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Username != users[j].Username {
+			return users[i].Username < users[j].Username
+		}
+
+		return users[i].ID < users[j].ID
+	})
+
+	return users
+}
+
+// listAllUsers returns the users that the filter selects, page by page.
+func listAllUsers(t *testing.T, ds store.DataStore, filter *store.UserFilter, limit int) []*models.User {
+	var all []*models.User
+	cursor := ""
+	for {
+		users, next, err := ds.ListUsers(context.Background(), filter, cursor, limit)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(users), limit)
+		all = append(all, users...)
+		if next == "" {
+			return all
+		}
+		require.Len(t, users, limit)
+		cursor = next
+	}
+}
+
+func testListUsers(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+
+	users, next, err := ds.ListUsers(ctx, nil, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Empty(t, next)
+
+	all := newUsers(t, ds, "dan", "ann", "bob", "anna", "carl", "ben", "annie", "dana")
+
+	users, next, err = ds.ListUsers(ctx, nil, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, all, users)
+	assert.Empty(t, next)
+
+	// The pages end where the next one starts.
+	for _, limit := range []int{1, 2, 3, len(all), len(all) + 1} {
+		assert.Equal(t, all, listAllUsers(t, ds, nil, limit), limit)
+	}
+	users, next, err = ds.ListUsers(ctx, nil, "", len(all))
+	require.NoError(t, err)
+	assert.Len(t, users, len(all))
+	assert.Empty(t, next)
+
+	_, _, err = ds.ListUsers(ctx, nil, "not a cursor", 1)
+	assert.Equal(t, store.ErrInvalidCursor, err)
+
+	verified, enabled := true, false
+	filters := []*store.UserFilter{
+		{UsernamePrefix: "ann"},
+		{UsernamePrefix: "b"},
+		{UsernamePrefix: "x"},
+		{UsernamePrefix: "a.*"},
+		{EmailVerified: &verified},
+		{MFAEnabled: &enabled},
+		{UsernamePrefix: "d", EmailVerified: &verified, MFAEnabled: &enabled},
+	}
+	for _, filter := range filters {
+		var want []*models.User
+		for _, user := range all {
+			if filter.Match(user) {
+				want = append(want, user)
+			}
+		}
+		assert.Equal(t, want, listAllUsers(t, ds, filter, 2), "%+v", *filter)
+	}
+}
+
+func testCountUsers(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+
+	count, err := ds.CountUsers(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	newUsers(t, ds, "dan", "ann", "bob", "anna", "carl", "ben")
+	verified, enabled := true, true
+	tests := []struct {
+		filter *store.UserFilter
+		count  int64
+	}{
+		{nil, 6},
+		{&store.UserFilter{}, 6},
+		{&store.UserFilter{UsernamePrefix: "an"}, 2},
+		{&store.UserFilter{UsernamePrefix: "anna"}, 1},
+		{&store.UserFilter{UsernamePrefix: "annabel"}, 0},
+		{&store.UserFilter{EmailVerified: &verified}, 3},
+		{&store.UserFilter{MFAEnabled: &enabled}, 2},
+		{&store.UserFilter{EmailVerified: &verified, MFAEnabled: &enabled}, 1},
+	}
+	for _, tt := range tests {
+		count, err := ds.CountUsers(ctx, tt.filter)
+		require.NoError(t, err)
+		assert.Equal(t, tt.count, count, "%+v", tt.filter)
+	}
+}
+
+func testInvites(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	invite := models.Invite{ID: "invite1", CreatedAt: now(), ExpireAt: now().Add(time.Hour)}
+
+	_, err := ds.ConsumeInvite(ctx, invite.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveInvite(ctx, &invite))
+	assert.Equal(t, store.ErrDuplicate, ds.SaveInvite(ctx, &invite))
+
+	got, err := ds.ConsumeInvite(ctx, invite.ID)
+	require.NoError(t, err)
+	assert.Equal(t, invite, *got)
+	_, err = ds.ConsumeInvite(ctx, invite.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+}
+
+func testPasswordResets(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	resets := []models.PasswordReset{
+		{ID: "pr1", UserID: "user1", ExpireAt: now().Add(time.Hour)},
+		{ID: "pr2", UserID: "user1", ExpireAt: now().Add(time.Hour)},
+		{ID: "pr3", UserID: "user2", ExpireAt: now().Add(time.Hour)},
+	}
+
+	_, err := ds.GetPasswordReset(ctx, "pr1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.ConsumePasswordReset(ctx, "pr1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	for i := range resets {
+		require.NoError(t, ds.SavePasswordReset(ctx, &resets[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SavePasswordReset(ctx, &resets[0]))
+
+	got, err := ds.GetPasswordReset(ctx, "pr1")
+	require.NoError(t, err)
+	assert.Equal(t, resets[0], *got)
+	got, err = ds.ConsumePasswordReset(ctx, "pr1")
+	require.NoError(t, err)
+	assert.Equal(t, resets[0], *got)
+	_, err = ds.GetPasswordReset(ctx, "pr1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.RemovePasswordResetsByUser(ctx, "user1"))
+	_, err = ds.GetPasswordReset(ctx, "pr2")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.GetPasswordReset(ctx, "pr3")
+	assert.NoError(t, err)
+}
+
+func testMFAChallenges(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	mc := models.MFAChallenge{ID: "mc1", UserID: "user1", ExpireAt: now().Add(time.Hour)}
+
+	_, err := ds.GetMFAChallenge(ctx, mc.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.ConsumeMFAChallenge(ctx, mc.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveMFAChallenge(ctx, &mc))
+	assert.Equal(t, store.ErrDuplicate, ds.SaveMFAChallenge(ctx, &mc))
+
+	got, err := ds.GetMFAChallenge(ctx, mc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, mc, *got)
+	got, err = ds.ConsumeMFAChallenge(ctx, mc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, mc, *got)
+	_, err = ds.ConsumeMFAChallenge(ctx, mc.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+}
+
+func testWebAuthnSessions(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	sessions := []models.WebAuthnSession{
+		{ID: "ws1", UserID: "user1", Ceremony: "registration", ExpireAt: now().Add(time.Hour)},
+		// A sign-in has no user.
+		{ID: "ws2", Ceremony: "signin", ExpireAt: now().Add(time.Hour)},
+	}
+
+	_, err := ds.ConsumeWebAuthnSession(ctx, "ws1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	for i := range sessions {
+		require.NoError(t, ds.SaveWebAuthnSession(ctx, &sessions[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveWebAuthnSession(ctx, &sessions[0]))
+
+	for _, ws := range sessions {
+		got, err := ds.ConsumeWebAuthnSession(ctx, ws.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ws, *got)
+		_, err = ds.ConsumeWebAuthnSession(ctx, ws.ID)
+		assert.Equal(t, store.ErrorNotFound, err)
+	}
+}
+
+func testWebAuthnCredentials(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	creds := []models.WebAuthnCredential{
+		{ID: "cred1", UserID: "user1", Name: "Laptop", PublicKey: []byte{1, 2}, SignCount: 1, CreatedAt: now().Add(time.Minute)},
+		{ID: "cred2", UserID: "user1", Name: "Phone", PublicKey: []byte{3, 4}, CreatedAt: now()},
+		{ID: "cred3", UserID: "user2", Name: "Key", PublicKey: []byte{5, 6}, CreatedAt: now()},
+	}
+
+	_, err := ds.GetWebAuthnCredential(ctx, "cred1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	got, err := ds.GetWebAuthnCredentialsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	for i := range creds {
+		require.NoError(t, ds.SaveWebAuthnCredential(ctx, &creds[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveWebAuthnCredential(ctx, &creds[0]))
+
+	cred, err := ds.GetWebAuthnCredential(ctx, "cred1")
+	require.NoError(t, err)
+	assert.Equal(t, creds[0], *cred)
+
+	// The credentials of the user are sorted by the time they were created.
+	got, err = ds.GetWebAuthnCredentialsByUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, creds[1], *got[0])
+	assert.Equal(t, creds[0], *got[1])
+
+	require.NoError(t, ds.RemoveWebAuthnCredential(ctx, "cred1"))
+	_, err = ds.GetWebAuthnCredential(ctx, "cred1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.NoError(t, ds.RemoveWebAuthnCredential(ctx, "cred1"))
+}
+
+func testWebAuthnSignCount(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	counted := models.WebAuthnCredential{ID: "cred1", UserID: "user1", SignCount: 5, CreatedAt: now()}
+	uncounted := models.WebAuthnCredential{ID: "cred2", UserID: "user1", CreatedAt: now()}
+	require.NoError(t, ds.SaveWebAuthnCredential(ctx, &counted))
+	require.NoError(t, ds.SaveWebAuthnCredential(ctx, &uncounted))
+
+	assert.Equal(t, store.ErrorNotFound, ds.UpdateWebAuthnSignCount(ctx, "cred3", 1, now()))
+
+	// The sign count must increase.
+	usedAt := now()
+	assert.Equal(t, store.ErrorNotFound, ds.UpdateWebAuthnSignCount(ctx, counted.ID, 5, usedAt))
+	assert.Equal(t, store.ErrorNotFound, ds.UpdateWebAuthnSignCount(ctx, counted.ID, 0, usedAt))
+	require.NoError(t, ds.UpdateWebAuthnSignCount(ctx, counted.ID, 6, usedAt))
+	got, err := ds.GetWebAuthnCredential(ctx, counted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(6), got.SignCount)
+	assert.Equal(t, usedAt, got.LastUsedAt)
+
+	// The sign count can stay 0 if the authenticator doesn't count the signatures.
+	require.NoError(t, ds.UpdateWebAuthnSignCount(ctx, uncounted.ID, 0, usedAt))
+	require.NoError(t, ds.UpdateWebAuthnSignCount(ctx, uncounted.ID, 0, usedAt))
+	got, err = ds.GetWebAuthnCredential(ctx, uncounted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), got.SignCount)
+	assert.Equal(t, usedAt, got.LastUsedAt)
+}
+
+func testSignInFailures(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	id := "user:chan"
+
+	_, err := ds.GetSignInFailures(ctx, id)
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	at := now()
+	expireAt := at.Add(time.Hour)
+	for i := 1; i <= 3; i++ {
+		sf, err := ds.AddSignInFailure(ctx, id, at, expireAt)
+		require.NoError(t, err)
+		assert.Equal(t, i, sf.Count)
+	}
+
+	got, err := ds.GetSignInFailures(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.SignInFailures{ID: id, Count: 3, LastFailureAt: at, ExpireAt: expireAt}, *got)
+
+	// A release never takes the count below 0.
+	for i := 0; i < 4; i++ {
+		require.NoError(t, ds.ReleaseSignInFailure(ctx, id))
+	}
+	got, err = ds.GetSignInFailures(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Count)
+	assert.NoError(t, ds.ReleaseSignInFailure(ctx, "user:john"))
+
+	require.NoError(t, ds.RemoveSignInFailures(ctx, id))
+	_, err = ds.GetSignInFailures(ctx, id)
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.NoError(t, ds.RemoveSignInFailures(ctx, id))
+
+	sf, err := ds.AddSignInFailure(ctx, id, at, expireAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sf.Count)
+}
+
+func testAccessTokens(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	expireAt := now().Add(time.Hour)
+	tokens := []models.AccessToken{
+		{ID: "at1", Value: "v1", UserID: "user1", Scope: "openid", FamilyID: "f1", ExpireAt: expireAt},
+		{ID: "at2", Value: "v2", UserID: "user1", FamilyID: "f2", ExpireAt: expireAt},
+		{ID: "at3", Value: "v3", UserID: "user1", FamilyID: "f3", ExpireAt: expireAt},
+		{ID: "at4", Value: "v4", UserID: "user2", FamilyID: "f4", ExpireAt: expireAt},
+		{ID: "at5", Value: "v5", ClientID: "client1", ExpireAt: expireAt},
+	}
+
+	_, err := ds.GetAccessToken(ctx, "at1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	for i := range tokens {
+		require.NoError(t, ds.SaveAccessToken(ctx, &tokens[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveAccessToken(ctx, &tokens[0]))
+
+	got, err := ds.GetAccessToken(ctx, "at1")
+	require.NoError(t, err)
+	assert.Equal(t, tokens[0], *got)
+
+	require.NoError(t, ds.RemoveAccessToken(ctx, "at1"))
+	ids, err := ds.RemoveAccessTokensByFamily(ctx, "f2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"at2"}, ids)
+	// Keep the family f3 of the user.
+	ids, err = ds.RemoveAccessTokensByUser(ctx, "user2", "f3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"at4"}, ids)
+	for _, id := range []string{"at1", "at2", "at4"} {
+		_, err := ds.GetAccessToken(ctx, id)
+		assert.Equal(t, store.ErrorNotFound, err, id)
+	}
+	for _, id := range []string{"at3", "at5"} {
+		_, err := ds.GetAccessToken(ctx, id)
+		assert.NoError(t, err, id)
+	}
+
+	ids, err = ds.RemoveAccessTokensByUser(ctx, "user1", "f3")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	_, err = ds.GetAccessToken(ctx, "at3")
+	assert.NoError(t, err)
+	ids, err = ds.RemoveAccessTokensByUser(ctx, "user1", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"at3"}, ids)
+	_, err = ds.GetAccessToken(ctx, "at3")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.GetAccessToken(ctx, "at5")
+	assert.NoError(t, err)
+}
+
+func testRefreshTokens(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	expireAt := now().Add(time.Hour)
+	tokens := []models.RefreshToken{
+		{ID: "rt1", Value: "v1", UserID: "user1", Scope: "openid", FamilyID: "f1", IssuedAt: now(), ExpireAt: expireAt},
+		{ID: "rt2", Value: "v2", UserID: "user1", FamilyID: "f2", ExpireAt: expireAt},
+		{ID: "rt3", Value: "v3", UserID: "user1", FamilyID: "f3", ExpireAt: expireAt},
+		{ID: "rt4", Value: "v4", UserID: "user2", FamilyID: "f4", ExpireAt: expireAt},
+	}
+
+	_, err := ds.GetRefreshToken(ctx, "rt1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.Equal(t, store.ErrorNotFound, ds.MarkRefreshTokenUsed(ctx, "rt1"))
+
+	for i := range tokens {
+		require.NoError(t, ds.SaveRefreshToken(ctx, &tokens[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveRefreshToken(ctx, &tokens[0]))
+
+	got, err := ds.GetRefreshToken(ctx, "rt1")
+	require.NoError(t, err)
+	assert.Equal(t, tokens[0], *got)
+
+	// A refresh token can only be marked as used once.
+	require.NoError(t, ds.MarkRefreshTokenUsed(ctx, "rt1"))
+	assert.Equal(t, store.ErrorNotFound, ds.MarkRefreshTokenUsed(ctx, "rt1"))
+	got, err = ds.GetRefreshToken(ctx, "rt1")
+	require.NoError(t, err)
+	assert.True(t, got.Used)
+
+	require.NoError(t, ds.RemoveRefreshToken(ctx, "rt1"))
+	require.NoError(t, ds.RemoveRefreshTokensByFamily(ctx, "f2"))
+	require.NoError(t, ds.RemoveRefreshTokensByUser(ctx, "user1", "f3"))
+	for _, id := range []string{"rt1", "rt2"} {
+		_, err := ds.GetRefreshToken(ctx, id)
+		assert.Equal(t, store.ErrorNotFound, err, id)
+	}
+	for _, id := range []string{"rt3", "rt4"} {
+		_, err := ds.GetRefreshToken(ctx, id)
+		assert.NoError(t, err, id)
+	}
+
+	require.NoError(t, ds.RemoveRefreshTokensByUser(ctx, "user1", ""))
+	_, err = ds.GetRefreshToken(ctx, "rt3")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.GetRefreshToken(ctx, "rt4")
+	assert.NoError(t, err)
+}
+
+func testClients(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	clients := []models.Client{
+		{
+			ID:           "client2",
+			Name:         "App",
+			Secret:       "hash",
+			Salt:         "salt",
+			RedirectURIs: []string{"https://app.example.com/callback"},
+			GrantTypes:   []string{"authorization_code", "refresh_token"},
+			Scopes:       []string{"openid"},
+			CreatedAt:    now(),
+		},
+		{ID: "client1", Name: "SPA", RedirectURIs: []string{}, GrantTypes: []string{}, Scopes: []string{}, CreatedAt: now()},
+	}
+
+	_, err := ds.GetClient(ctx, "client1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	got, err := ds.GetClients(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Equal(t, store.ErrorNotFound, ds.UpdateClient(ctx, &clients[0]))
+
+	for i := range clients {
+		require.NoError(t, ds.SaveClient(ctx, &clients[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveClient(ctx, &clients[0]))
+
+	client, err := ds.GetClient(ctx, "client2")
+	require.NoError(t, err)
+	assert.Equal(t, clients[0], *client)
+
+	// The clients are sorted by id.
+	got, err = ds.GetClients(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, clients[1], *got[0])
+	assert.Equal(t, clients[0], *got[1])
+
+	clients[0].Scopes = append(clients[0].Scopes, "profile")
+	require.NoError(t, ds.UpdateClient(ctx, &clients[0]))
+	client, err = ds.GetClient(ctx, "client2")
+	require.NoError(t, err)
+	assert.Equal(t, clients[0], *client)
+
+	require.NoError(t, ds.RemoveClient(ctx, "client2"))
+	_, err = ds.GetClient(ctx, "client2")
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.NoError(t, ds.RemoveClient(ctx, "client2"))
+}
+
+func testAuthCodes(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	ac := models.AuthCode{
+		ID:                  "ac1",
+		ClientID:            "client1",
+		UserID:              "user1",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+		Nonce:               "nonce",
+		AuthTime:            now(),
+		ExpireAt:            now().Add(time.Hour),
+	}
+
+	_, err := ds.ConsumeAuthCode(ctx, ac.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveAuthCode(ctx, &ac))
+	assert.Equal(t, store.ErrDuplicate, ds.SaveAuthCode(ctx, &ac))
+
+	got, err := ds.ConsumeAuthCode(ctx, ac.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ac, *got)
+	_, err = ds.ConsumeAuthCode(ctx, ac.ID)
+	assert.Equal(t, store.ErrorNotFound, err)
+}
+
+func testSigningKeys(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	keys := []models.SigningKey{
+		{
+			ID:            "key1",
+			Algorithm:     "ES256",
+			PrivateKey:    "pem1",
+			CreatedAt:     now().Add(time.Minute),
+			ActivateAt:    now().Add(time.Hour),
+			PredecessorID: "key2",
+		},
+		{ID: "key2", Algorithm: "RS256", PrivateKey: "pem2", CreatedAt: now(), ActivateAt: now()},
+	}
+
+	got, err := ds.GetSigningKeys(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	for i := range keys {
+		require.NoError(t, ds.SaveSigningKey(ctx, &keys[i]))
+	}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveSigningKey(ctx, &keys[0]))
+
+	// A key has a single successor.
+	successor := models.SigningKey{ID: "key3", Algorithm: "ES256", PrivateKey: "pem3", CreatedAt: now(), PredecessorID: "key2"}
+	assert.Equal(t, store.ErrDuplicate, ds.SaveSigningKey(ctx, &successor))
+
+	// The keys are sorted by the time they were created.
+	got, err = ds.GetSigningKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, keys[1], *got[0])
+	assert.Equal(t, keys[0], *got[1])
+
+	require.NoError(t, ds.RemoveSigningKey(ctx, "key2"))
+	got, err = ds.GetSigningKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, keys[0], *got[0])
+	assert.NoError(t, ds.RemoveSigningKey(ctx, "key2"))
+}
+
+// testExpiry checks that the objects are gone once they expire, even if the store removes the expired
+// objects only periodically.
+func testExpiry(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	expired := now().Add(-time.Second)
+
+	require.NoError(t, ds.SaveInvite(ctx, &models.Invite{ID: "invite1", ExpireAt: expired}))
+	_, err := ds.ConsumeInvite(ctx, "invite1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SavePasswordReset(ctx, &models.PasswordReset{ID: "pr1", UserID: "user1", ExpireAt: expired}))
+	_, err = ds.GetPasswordReset(ctx, "pr1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.ConsumePasswordReset(ctx, "pr1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveMFAChallenge(ctx, &models.MFAChallenge{ID: "mc1", UserID: "user1", ExpireAt: expired}))
+	_, err = ds.GetMFAChallenge(ctx, "mc1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	_, err = ds.ConsumeMFAChallenge(ctx, "mc1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveWebAuthnSession(ctx, &models.WebAuthnSession{ID: "ws1", Ceremony: "signin", ExpireAt: expired}))
+	_, err = ds.ConsumeWebAuthnSession(ctx, "ws1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveAccessToken(ctx, &models.AccessToken{ID: "at1", UserID: "user1", ExpireAt: expired}))
+	_, err = ds.GetAccessToken(ctx, "at1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	require.NoError(t, ds.SaveRefreshToken(ctx, &models.RefreshToken{ID: "rt1", UserID: "user1", ExpireAt: expired}))
+	_, err = ds.GetRefreshToken(ctx, "rt1")
+	assert.Equal(t, store.ErrorNotFound, err)
+	assert.Equal(t, store.ErrorNotFound, ds.MarkRefreshTokenUsed(ctx, "rt1"))
+
+	require.NoError(t, ds.SaveAuthCode(ctx, &models.AuthCode{ID: "ac1", ExpireAt: expired}))
+	_, err = ds.ConsumeAuthCode(ctx, "ac1")
+	assert.Equal(t, store.ErrorNotFound, err)
+
+	// The failures are forgotten once they expire, so the count starts over.
+	_, err = ds.AddSignInFailure(ctx, "user:chan", expired, expired)
+	require.NoError(t, err)
+	_, err = ds.GetSignInFailures(ctx, "user:chan")
+	assert.Equal(t, store.ErrorNotFound, err)
+	sf, err := ds.AddSignInFailure(ctx, "user:chan", now(), now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sf.Count)
+}
+
+// testConcurrentConsumes checks that an object that is consumed concurrently is only consumed once.
+func testConcurrentConsumes(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	expireAt := now().Add(time.Hour)
+	require.NoError(t, ds.SaveInvite(ctx, &models.Invite{ID: "invite1", ExpireAt: expireAt}))
+	require.NoError(t, ds.SavePasswordReset(ctx, &models.PasswordReset{ID: "pr1", ExpireAt: expireAt}))
+	require.NoError(t, ds.SaveMFAChallenge(ctx, &models.MFAChallenge{ID: "mc1", ExpireAt: expireAt}))
+	require.NoError(t, ds.SaveWebAuthnSession(ctx, &models.WebAuthnSession{ID: "ws1", ExpireAt: expireAt}))
+	require.NoError(t, ds.SaveAuthCode(ctx, &models.AuthCode{ID: "ac1", ExpireAt: expireAt}))
+
+	consumes := map[string]func() error{
+		"invite": func() error {
+			_, err := ds.ConsumeInvite(ctx, "invite1")
+			return err
+		},
+		"password reset": func() error {
+			_, err := ds.ConsumePasswordReset(ctx, "pr1")
+			return err
+		},
+		"mfa challenge": func() error {
+			_, err := ds.ConsumeMFAChallenge(ctx, "mc1")
+			return err
+		},
+		"webauthn session": func() error {
+			_, err := ds.ConsumeWebAuthnSession(ctx, "ws1")
+			return err
+		},
+		"auth code": func() error {
+			_, err := ds.ConsumeAuthCode(ctx, "ac1")
+			return err
+		},
+	}
+	for name, consume := range consumes {
+		consume := consume
+		assert.Equal(t, 1, runConcurrently(func(int) error { return consume() }), name)
+	}
+}
+
+// testConcurrentUpdates checks that the atomic updates don't lose or repeat an update when they run
+// concurrently.
+func testConcurrentUpdates(t *testing.T, ds store.DataStore) {
+	ctx := context.Background()
+	expireAt := now().Add(time.Hour)
+
+	succeeded := runConcurrently(func(int) error {
+		_, err := ds.AddSignInFailure(ctx, "user:chan", now(), expireAt)
+		return err
+	})
+	assert.Equal(t, concurrency, succeeded)
+	sf, err := ds.GetSignInFailures(ctx, "user:chan")
+	require.NoError(t, err)
+	assert.Equal(t, concurrency, sf.Count)
+	assert.Equal(t, concurrency, runConcurrently(func(int) error {
+		return ds.ReleaseSignInFailure(ctx, "user:chan")
+	}))
+	sf, err = ds.GetSignInFailures(ctx, "user:chan")
+	require.NoError(t, err)
+	assert.Equal(t, 0, sf.Count)
+
+	require.NoError(t, ds.SaveRefreshToken(ctx, &models.RefreshToken{ID: "rt1", ExpireAt: expireAt}))
+	assert.Equal(t, 1, runConcurrently(func(int) error {
+		return ds.MarkRefreshTokenUsed(ctx, "rt1")
+	}))
+
+	require.NoError(t, ds.SaveWebAuthnCredential(ctx, &models.WebAuthnCredential{ID: "cred1", CreatedAt: now()}))
+	assert.Equal(t, 1, runConcurrently(func(int) error {
+		return ds.UpdateWebAuthnSignCount(ctx, "cred1", 1, now())
+	}))
+
+	// Only one of the updates of the same version of a user succeeds.
+	user := models.User{ID: "user1", Username: "chan"}
+	require.NoError(t, ds.SaveUser(ctx, &user))
+	assert.Equal(t, 1, runConcurrently(func(i int) error {
+		updated := user
+		updated.Name = fmt.Sprintf("Chan %d", i)
+		return ds.UpdateUser(ctx, &updated)
+	}))
+	got, err := ds.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got.Version)
+
+	// Only one of the saves of the same id succeeds.
+	assert.Equal(t, 1, runConcurrently(func(i int) error {
+		return ds.SaveUser(ctx, &models.User{ID: "user2", Username: fmt.Sprintf("user%d", i)})
+	}))
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/cybersamx/authx/pkg/models"
+)
+
+// NormalizeUsername returns the username without the surrounding spaces, in the NFKC normal form and
+// case folded, so that the usernames that look the same are the same.
+func NormalizeUsername(username string) string {
+	// Case folding may undo the normal form.
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username))))
+}
+
+// UserFilter selects the users of ListUsers and CountUsers. The zero value selects all the users.
+type UserFilter struct {
+	// UsernamePrefix selects the users whose username starts with it, once normalized, if it isn't
+	// empty.
+	UsernamePrefix string
+	// EmailVerified selects the users whose email address is verified, or isn't, if it isn't nil.
+	EmailVerified *bool
+	// MFAEnabled selects the users who have enabled the MFA, or haven't, if it isn't nil.
+	MFAEnabled *bool
+}
+
+// Match returns true if the filter selects the user.
+func (f *UserFilter) Match(user *models.User) bool {
+	if f == nil {
+		return true
+	}
+	if !strings.HasPrefix(user.Username, NormalizeUsername(f.UsernamePrefix)) {
+		return false
+	}
+	if f.EmailVerified != nil && user.EmailVerified != *f.EmailVerified {
+		return false
+	}
+	if f.MFAEnabled != nil && user.MFA.Enabled != *f.MFAEnabled {
+		return false
+	}
+
+	return true
+}
+
+// UserCursor is the position of a page of ListUsers, which starts after the user of the username and
+// id, as the users are sorted by username and then id.
+type UserCursor struct {
+	Username string
+	ID       string
+}
+
+// NewUserCursor returns the cursor of the page that starts after the user.
+func NewUserCursor(user *models.User) *UserCursor {
+	return &UserCursor{Username: user.Username, ID: user.ID}
+}
+
+// ParseUserCursor parses the cursor that String returns, or returns nil if the cursor is empty, ie.
+// of the first page. It returns ErrInvalidCursor if the cursor is malformed.
+func ParseUserCursor(cursor string) (*UserCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var fields []string
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &UserCursor{Username: fields[0], ID: fields[1]}, nil
+}
+
+// String returns the opaque cursor, which is safe to use in a URL.
+func (c *UserCursor) String() string {
+	// A slice of strings can always be encoded.
+	data, _ := json.Marshal([]string{c.Username, c.ID})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Before returns true if the user is in a page before the cursor, ie. the user isn't after it. No
+// user is before the nil cursor.
+func (c *UserCursor) Before(user *models.User) bool {
+	if c == nil {
+		return false
+	}
+	if user.Username != c.Username {
+		return user.Username < c.Username
+	}
+
+	return user.ID <= c.ID
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cybersamx/authx/pkg/models"
+)
+
+func Test_NormalizeUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		expected string
+	}{
+		{"chan", "chan"},
+		{"  Chan\t", "chan"},
+		{"CHAN", "chan"},
+		// Fullwidth letters are compatibility equivalent to the ASCII ones.
+		{"ｃｈａｎ", "chan"},
+		{"Straße", "strasse"},
+		// The composed and decomposed forms of é are the same.
+		{"Ren\u00e9", "ren\u00e9"},
+		{"Rene\u0301", "ren\u00e9"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NormalizeUsername(tt.username), tt.username)
+	}
+}
+
+func Test_UserCursor(t *testing.T) {
+	cursor := NewUserCursor(&models.User{ID: "user1", Username: "chan"})
+	parsed, err := ParseUserCursor(cursor.String())
+	require.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+
+	assert.True(t, cursor.Before(&models.User{ID: "user2", Username: "ann"}))
+	assert.True(t, cursor.Before(&models.User{ID: "user1", Username: "chan"}))
+	assert.False(t, cursor.Before(&models.User{ID: "user2", Username: "chan"}))
+	assert.False(t, cursor.Before(&models.User{ID: "user0", Username: "dan"}))
+
+	parsed, err = ParseUserCursor("")
+	require.NoError(t, err)
+	assert.Nil(t, parsed)
+	assert.False(t, parsed.Before(&models.User{Username: "ann"}))
+
+	for _, c := range []string{"!", "bm90IGpzb24", "WyJjaGFuIl0"} {
+		_, err := ParseUserCursor(c)
+		assert.Equal(t, ErrInvalidCursor, err, c)
+	}
+}
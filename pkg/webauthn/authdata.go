@@ -0,0 +1,129 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Flags of the authenticator data.
+const (
+	FlagUserPresent   = 0x01
+	FlagUserVerified  = 0x04
+	FlagAttestedData  = 0x40
+	FlagExtensionData = 0x80
+)
+
+const (
+	rpIDHashLen   = 32
+	authDataLen   = rpIDHashLen + 1 + 4
+	aaguidLen     = 16
+	maxCredIDLen  = 1023
+	attestedStart = authDataLen + aaguidLen + 2
+)
+
+var (
+	ErrInvalidAuthData    = errors.New("invalid authenticator data")
+	ErrInvalidAttestation = errors.New("invalid attestation object")
+)
+
+// AuthenticatorData is the data returned by the authenticator in both ceremonies. The attested
+// credential data, ie. the id and the public key of the new credential, is only returned on
+// registration.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	AAGUID       []byte
+	CredentialID []byte
+	// PublicKey is the credential public key in the COSE key format.
+	PublicKey []byte
+}
+
+func (ad *AuthenticatorData) UserPresent() bool {
+	return ad.Flags&FlagUserPresent != 0
+}
+
+func (ad *AuthenticatorData) UserVerified() bool {
+	return ad.Flags&FlagUserVerified != 0
+}
+
+// MatchesRPID returns true if the data is scoped to the relying party id.
+func (ad *AuthenticatorData) MatchesRPID(rpID string) bool {
+	hash := sha256.Sum256([]byte(rpID))
+	return bytes.Equal(ad.RPIDHash, hash[:])
+}
+
+// ParseAuthenticatorData parses the authenticator data as defined in the WebAuthn specification.
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < authDataLen {
+		return nil, ErrInvalidAuthData
+	}
+
+	ad := AuthenticatorData{
+		RPIDHash:  append([]byte{}, data[:rpIDHashLen]...),
+		Flags:     data[rpIDHashLen],
+		SignCount: binary.BigEndian.Uint32(data[rpIDHashLen+1 : authDataLen]),
+	}
+	rest := data[authDataLen:]
+
+	if ad.Flags&FlagAttestedData != 0 {
+		if len(data) < attestedStart {
+			return nil, ErrInvalidAuthData
+		}
+		credIDLen := int(binary.BigEndian.Uint16(data[authDataLen+aaguidLen : attestedStart]))
+		if credIDLen == 0 || credIDLen > maxCredIDLen || len(data) < attestedStart+credIDLen {
+			return nil, ErrInvalidAuthData
+		}
+		ad.AAGUID = append([]byte{}, data[authDataLen:authDataLen+aaguidLen]...)
+		ad.CredentialID = append([]byte{}, data[attestedStart:attestedStart+credIDLen]...)
+
+		keyData := data[attestedStart+credIDLen:]
+		_, n, err := decodeCBOR(keyData)
+		if err != nil {
+			return nil, ErrInvalidAuthData
+		}
+		ad.PublicKey = append([]byte{}, keyData[:n]...)
+		rest = keyData[n:]
+	}
+
+	// The extensions, if any, are ignored but must be well-formed.
+	if ad.Flags&FlagExtensionData != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthData
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidAuthData
+	}
+
+	return &ad, nil
+}
+
+// ParseAttestationObject returns the authenticator data of the attestation object returned on
+// registration. The attestation statement isn't verified, as the relying party asks for no
+// attestation, ie. it doesn't restrict the makes of authenticators.
+func ParseAttestationObject(data []byte) (*AuthenticatorData, error) {
+	item, n, err := decodeCBOR(data)
+	if err != nil || n != len(data) {
+		return nil, ErrInvalidAttestation
+	}
+	m, err := toCBORMap(item)
+	if err != nil {
+		return nil, ErrInvalidAttestation
+	}
+
+	if _, ok := m["fmt"].(string); !ok {
+		return nil, ErrInvalidAttestation
+	}
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidAttestation
+	}
+
+	return ParseAuthenticatorData(authData)
+}
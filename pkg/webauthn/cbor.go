@@ -0,0 +1,180 @@
+package webauthn
+
+import (
+	"errors"
+)
+
+// CBOR (RFC 8949) major types.
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+
+	cborFalse = 20
+	cborTrue  = 21
+	cborNull  = 22
+
+	// cborMaxDepth limits the nesting of the decoded items, which is shallow in WebAuthn.
+	cborMaxDepth = 16
+)
+
+var (
+	ErrInvalidCBOR = errors.New("invalid cbor")
+)
+
+// cborDecoder decodes the subset of CBOR used by WebAuthn, ie. without indefinite lengths and floats.
+// The integers are decoded as int64, the byte strings as []byte, the text strings as string, the
+// arrays as []interface{} and the maps as map[interface{}]interface{}.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeCBOR decodes the first item of the data and returns the number of bytes it takes up.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+	item, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return item, d.pos, nil
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrInvalidCBOR
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+
+	return b, nil
+}
+
+// head returns the major type and the argument of the next item.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		arg, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, err
+		}
+		var v uint64
+		for _, c := range arg {
+			v = v<<8 | uint64(c)
+		}
+
+		return major, v, nil
+	}
+
+	return 0, 0, ErrInvalidCBOR
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, ErrInvalidCBOR
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if arg > 1<<63-1 {
+			return nil, ErrInvalidCBOR
+		}
+		return int64(arg), nil
+	case cborNegative:
+		if arg > 1<<63-1 {
+			return nil, ErrInvalidCBOR
+		}
+		return -1 - int64(arg), nil
+	case cborBytes:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case cborText:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		// Every item takes up at least a byte.
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, ErrInvalidCBOR
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, ErrInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, ErrInvalidCBOR
+			}
+			val, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = val
+		}
+		return m, nil
+	case cborTag:
+		// The tags are ignored.
+		return d.decode(depth + 1)
+	case cborSimple:
+		switch arg {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull:
+			return nil, nil
+		}
+	}
+
+	return nil, ErrInvalidCBOR
+}
+
+// toCBORMap returns the item as a map.
+func toCBORMap(item interface{}) (map[interface{}]interface{}, error) {
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidCBOR
+	}
+
+	return m, nil
+}
@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE (RFC 8152) algorithms of the credential public keys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key types, curves and parameters.
+const (
+	coseKeyType   = 1
+	coseKeyAlg    = 3
+	coseKeyCurve  = -1
+	coseKeyX      = -2
+	coseKeyY      = -3
+	coseKeyRSAN   = -1
+	coseKeyRSAE   = -2
+	coseKeyOKP    = 1
+	coseKeyEC2    = 2
+	coseKeyRSA    = 3
+	coseCurveP256 = 1
+	coseCurveEd   = 6
+
+	// minRSABits is the size of the smallest RSA key accepted.
+	minRSABits = 2048
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported credential public key")
+	ErrInvalidKey     = errors.New("invalid credential public key")
+	ErrInvalidSig     = errors.New("invalid signature")
+)
+
+// SupportedAlgs are the algorithms of the credential public keys accepted, in order of preference.
+var SupportedAlgs = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// PublicKey is a credential public key.
+type PublicKey struct {
+	Alg int
+	Key crypto.PublicKey
+}
+
+// ParsePublicKey parses a credential public key in the COSE key format.
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	item, n, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, ErrInvalidKey
+	}
+
+	return parseCOSEKey(item)
+}
+
+func parseCOSEKey(item interface{}) (*PublicKey, error) {
+	m, err := toCBORMap(item)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlg)].(int64)
+	switch {
+	case kty == coseKeyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseKeyCurve)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidKey
+		}
+		key := ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrInvalidKey
+		}
+
+		return &PublicKey{Alg: AlgES256, Key: &key}, nil
+	case kty == coseKeyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseKeyCurve)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		if crv != coseCurveEd || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidKey
+		}
+
+		return &PublicKey{Alg: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseKeyRSAN)].([]byte)
+		e, _ := m[int64(coseKeyRSAE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidKey
+		}
+		var exp int
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		key := rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		if key.N.BitLen() < minRSABits || exp < 3 {
+			return nil, ErrInvalidKey
+		}
+
+		return &PublicKey{Alg: AlgRS256, Key: &key}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// Verify checks the signature of the data.
+func (pk *PublicKey) Verify(data, sig []byte) error {
+	ok := false
+	switch key := pk.Key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+
+	if !ok {
+		return ErrInvalidSig
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+// Package webauthn implements the relying party side of the Web Authentication (WebAuthn) protocol,
+// ie. parsing and verifying what the authenticators return from the registration and the assertion
+// ceremonies.
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	// CredentialType is the only type of credential.
+	CredentialType = "public-key"
+
+	// Types of the client data of the ceremonies.
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+
+	// Requirements of user verification, eg. a biometric or a PIN, by the authenticator.
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+
+	// ResidentKeyRequired asks for a discoverable credential, ie. a passkey, that signs in without a
+	// username.
+	ResidentKeyRequired = "required"
+
+	// AttestationNone asks for no attestation of the make of the authenticator.
+	AttestationNone = "none"
+
+	challengeLen = 32
+)
+
+var (
+	ErrInvalidClientData = errors.New("invalid client data")
+)
+
+// Bytes is binary data that is encoded in JSON as unpadded base64url, as the JSON serialization of the
+// WebAuthn types.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+
+	return nil
+}
+
+// String returns the base64url encoding of the data, as in the client data and the credential ids.
+func (b Bytes) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewChallenge returns a random challenge for a ceremony.
+func NewChallenge() (Bytes, error) {
+	challenge := make([]byte, challengeLen)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// ClientData is the data collected by the browser that the authenticator signs.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData parses the client data JSON.
+func ParseClientData(data []byte) (*ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return nil, ErrInvalidClientData
+	}
+
+	return &cd, nil
+}
+
+// Check returns an error if the client data isn't of the ceremony type or the origin, or is from a
+// cross-origin iframe.
+func (cd *ClientData) Check(typ, origin string) error {
+	if cd.Type != typ || cd.Origin != origin || cd.CrossOrigin {
+		return ErrInvalidClientData
+	}
+
+	return nil
+}
+
+type RelyingParty struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey,omitempty"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions are the options of the registration ceremony, ie. the publicKey argument of
+// navigator.credentials.create().
+type CreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation,omitempty"`
+}
+
+// RequestOptions are the options of the assertion ceremony, ie. the publicKey argument of
+// navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId,omitempty"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    Bytes    `json:"clientDataJSON"`
+	AttestationObject Bytes    `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// RegistrationResponse is the credential created by the authenticator in the registration ceremony.
+type RegistrationResponse struct {
+	ID       string              `json:"id"`
+	RawID    Bytes               `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle,omitempty"`
+}
+
+// AuthenticationResponse is the assertion signed by the authenticator in the assertion ceremony.
+type AuthenticationResponse struct {
+	ID       string            `json:"id"`
+	RawID    Bytes             `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// SignedData returns the data signed by the authenticator in the assertion, ie. the authenticator data
+// followed by the hash of the client data.
+func (ar *AssertionResponse) SignedData() []byte {
+	hash := sha256.Sum256(ar.ClientDataJSON)
+	return append(append([]byte{}, ar.AuthenticatorData...), hash[:]...)
+}
@@ -0,0 +1,152 @@
+package webauthn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeCBOR(t *testing.T) {
+	// Examples of RFC 8949, appendix A.
+	tests := map[string]interface{}{
+		"00":                 int64(0),
+		"17":                 int64(23),
+		"1818":               int64(24),
+		"1903e8":             int64(1000),
+		"1b000000e8d4a51000": int64(1000000000000),
+		"20":                 int64(-1),
+		"3903e7":             int64(-1000),
+		"f4":                 false,
+		"f5":                 true,
+		"f6":                 nil,
+		"4401020304":         []byte{1, 2, 3, 4},
+		"6449455446":         "IETF",
+		"83010203":           []interface{}{int64(1), int64(2), int64(3)},
+		"a201020304":         map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)},
+		"a26161016162820203": map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+	}
+
+	for in, want := range tests {
+		data, err := hex.DecodeString(in)
+		require.NoError(t, err)
+		item, n, err := decodeCBOR(data)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, item, in)
+		assert.Equal(t, len(data), n, in)
+	}
+}
+
+func Test_DecodeCBOR_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"18",         // truncated argument
+		"4401",       // truncated byte string
+		"9f01ff",     // indefinite length
+		"fa47c35000", // float
+		"a1f401",     // map key that isn't an int or a string
+		"9b00000000ffffffff",
+	}
+
+	for _, in := range tests {
+		data, err := hex.DecodeString(in)
+		require.NoError(t, err)
+		_, _, err = decodeCBOR(data)
+		assert.Equal(t, ErrInvalidCBOR, err, in)
+	}
+}
+
+// ed25519COSEKey encodes the public key in the COSE key format.
+func ed25519COSEKey(key ed25519.PublicKey) []byte {
+	data := []byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}
+	return append(data, key...)
+}
+
+func Test_PublicKey_Verify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	msg := []byte("signed data")
+
+	key, err := ParsePublicKey(ed25519COSEKey(pub))
+	require.NoError(t, err)
+	assert.Equal(t, AlgEdDSA, key.Alg)
+
+	assert.NoError(t, key.Verify(msg, ed25519.Sign(priv, msg)))
+	assert.Equal(t, ErrInvalidSig, key.Verify([]byte("other data"), ed25519.Sign(priv, msg)))
+
+	// An EC2 key with the alg of EdDSA.
+	_, err = ParsePublicKey([]byte{0xa2, 0x01, 0x02, 0x03, 0x27})
+	assert.Equal(t, ErrUnsupportedKey, err)
+	_, err = ParsePublicKey(ed25519COSEKey(pub[:31]))
+	assert.Error(t, err)
+}
+
+func Test_ParseAuthenticatorData(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, FlagUserPresent|FlagUserVerified|FlagAttestedData, 0, 0, 1, 0)
+	data = append(data, make([]byte, aaguidLen)...)
+	data = append(data, 0, 2, 0xca, 0xfe)
+	data = append(data, ed25519COSEKey(pub)...)
+
+	ad, err := ParseAuthenticatorData(data)
+	require.NoError(t, err)
+	assert.True(t, ad.MatchesRPID("example.com"))
+	assert.False(t, ad.MatchesRPID("evil.example.com"))
+	assert.True(t, ad.UserPresent())
+	assert.True(t, ad.UserVerified())
+	assert.Equal(t, uint32(256), ad.SignCount)
+	assert.Equal(t, []byte{0xca, 0xfe}, ad.CredentialID)
+	assert.Equal(t, ed25519COSEKey(pub), ad.PublicKey)
+
+	// Trailing data.
+	_, err = ParseAuthenticatorData(append(data, 0))
+	assert.Equal(t, ErrInvalidAuthData, err)
+	// Truncated public key.
+	_, err = ParseAuthenticatorData(data[:len(data)-1])
+	assert.Equal(t, ErrInvalidAuthData, err)
+	_, err = ParseAuthenticatorData(data[:authDataLen-1])
+	assert.Equal(t, ErrInvalidAuthData, err)
+
+	// Without the attested credential data, as in an assertion.
+	ad, err = ParseAuthenticatorData(append(append([]byte{}, rpIDHash[:]...), FlagUserPresent, 0, 0, 0, 7))
+	require.NoError(t, err)
+	assert.False(t, ad.UserVerified())
+	assert.Equal(t, uint32(7), ad.SignCount)
+	assert.Nil(t, ad.CredentialID)
+}
+
+func Test_Bytes_JSON(t *testing.T) {
+	in := Bytes{0xfb, 0xff, 0x01}
+	data, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.Equal(t, `"-_8B"`, string(data))
+
+	var out Bytes
+	require.NoError(t, json.Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+	// The padding is accepted.
+	require.NoError(t, json.Unmarshal([]byte(`"AQ=="`), &out))
+	assert.Equal(t, Bytes{1}, out)
+	assert.Error(t, json.Unmarshal([]byte(`"+/8B"`), &out))
+}
+
+func Test_ClientData_Check(t *testing.T) {
+	cd, err := ParseClientData([]byte(`{"type":"webauthn.get","challenge":"AQ","origin":"https://example.com"}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, cd.Check(TypeGet, "https://example.com"))
+	assert.Equal(t, ErrInvalidClientData, cd.Check(TypeCreate, "https://example.com"))
+	assert.Equal(t, ErrInvalidClientData, cd.Check(TypeGet, "https://evil.com"))
+
+	_, err = ParseClientData([]byte("not json"))
+	assert.Equal(t, ErrInvalidClientData, err)
+}
@@ -0,0 +1,196 @@
+// Package webauthntest provides a software authenticator to test the WebAuthn ceremonies without a
+// browser or a security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/cybersamx/authx/pkg/webauthn"
+)
+
+const credentialIDLen = 16
+
+var (
+	ErrWrongRPID          = errors.New("wrong relying party id")
+	ErrNoCredential       = errors.New("no credential")
+	ErrCredentialExcluded = errors.New("credential already registered")
+)
+
+type credential struct {
+	id        []byte
+	key       *ecdsa.PrivateKey
+	userID    []byte
+	signCount uint32
+}
+
+// Authenticator is a software authenticator of ES256 credentials that creates and signs the responses
+// the browser returns for the ceremonies, ie. with the client data of the origin.
+type Authenticator struct {
+	RPID   string
+	Origin string
+	// UserVerified sets the user verified flag in the responses, as after a biometric or a PIN.
+	UserVerified bool
+
+	credentials []*credential
+}
+
+func New(rpID, origin string) *Authenticator {
+	return &Authenticator{
+		RPID:   rpID,
+		Origin: origin,
+	}
+}
+
+// Clone returns a copy of the authenticator and its credentials, eg. to test the detection of cloned
+// authenticators by the sign count.
+func (a *Authenticator) Clone() *Authenticator {
+	clone := *a
+	clone.credentials = make([]*credential, len(a.credentials))
+	for i, cred := range a.credentials {
+		c := *cred
+		clone.credentials[i] = &c
+	}
+
+	return &clone
+}
+
+// Register creates a credential as navigator.credentials.create().
+func (a *Authenticator) Register(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	if options.RP.ID != "" && options.RP.ID != a.RPID {
+		return nil, ErrWrongRPID
+	}
+	for _, desc := range options.ExcludeCredentials {
+		if a.find(desc.ID) != nil {
+			return nil, ErrCredentialExcluded
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, credentialIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cred := credential{id: id, key: key, userID: append([]byte{}, options.User.ID...)}
+
+	clientData, err := a.clientData(webauthn.TypeCreate, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authData(webauthn.FlagAttestedData, 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = append(authData, byte(len(id)>>8), byte(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, coseKey(&key.PublicKey)...)
+
+	var attObj []byte
+	attObj = appendHead(attObj, majorMap, 3)
+	attObj = appendText(attObj, "fmt")
+	attObj = appendText(attObj, webauthn.AttestationNone)
+	attObj = appendText(attObj, "attStmt")
+	attObj = appendHead(attObj, majorMap, 0)
+	attObj = appendText(attObj, "authData")
+	attObj = appendBytes(attObj, authData)
+
+	a.credentials = append(a.credentials, &cred)
+
+	return &webauthn.RegistrationResponse{
+		ID:    webauthn.Bytes(id).String(),
+		RawID: id,
+		Type:  webauthn.CredentialType,
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    clientData,
+			AttestationObject: attObj,
+		},
+	}, nil
+}
+
+// Assert signs an assertion as navigator.credentials.get(), with the first allowed credential or, if no
+// credentials are allowed ie. a passkey sign-in, with the last registered credential.
+func (a *Authenticator) Assert(options *webauthn.RequestOptions) (*webauthn.AuthenticationResponse, error) {
+	if options.RPID != "" && options.RPID != a.RPID {
+		return nil, ErrWrongRPID
+	}
+
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		if len(a.credentials) > 0 {
+			cred = a.credentials[len(a.credentials)-1]
+		}
+	} else {
+		for _, desc := range options.AllowCredentials {
+			if cred = a.find(desc.ID); cred != nil {
+				break
+			}
+		}
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	clientData, err := a.clientData(webauthn.TypeGet, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	cred.signCount++
+	resp := webauthn.AssertionResponse{
+		ClientDataJSON:    clientData,
+		AuthenticatorData: a.authData(0, cred.signCount),
+		UserHandle:        cred.userID,
+	}
+	digest := sha256.Sum256(resp.SignedData())
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	resp.Signature = sig
+
+	return &webauthn.AuthenticationResponse{
+		ID:       webauthn.Bytes(cred.id).String(),
+		RawID:    cred.id,
+		Type:     webauthn.CredentialType,
+		Response: resp,
+	}, nil
+}
+
+func (a *Authenticator) find(id []byte) *credential {
+	for _, cred := range a.credentials {
+		if string(cred.id) == string(id) {
+			return cred
+		}
+	}
+
+	return nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge webauthn.Bytes) ([]byte, error) {
+	return json.Marshal(webauthn.ClientData{
+		Type:      typ,
+		Challenge: challenge.String(),
+		Origin:    a.Origin,
+	})
+}
+
+func (a *Authenticator) authData(flags byte, signCount uint32) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+
+	hash := sha256.Sum256([]byte(a.RPID))
+	data := append([]byte{}, hash[:]...)
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], signCount)
+
+	return data
+}
@@ -0,0 +1,62 @@
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+)
+
+// CBOR major types of the items encoded by the authenticator.
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorMap      = 5
+)
+
+func appendHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= 0xff:
+		return append(b, major|24, byte(arg))
+	case arg <= 0xffff:
+		return append(b, major|25, byte(arg>>8), byte(arg))
+	}
+
+	return append(b, major|26, byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+}
+
+func appendInt(b []byte, v int64) []byte {
+	if v < 0 {
+		return appendHead(b, majorNegative, uint64(-1-v))
+	}
+
+	return appendHead(b, majorUnsigned, uint64(v))
+}
+
+func appendBytes(b, data []byte) []byte {
+	return append(appendHead(b, majorBytes, uint64(len(data))), data...)
+}
+
+func appendText(b []byte, s string) []byte {
+	return append(appendHead(b, majorText, uint64(len(s))), s...)
+}
+
+// coseKey encodes the P-256 public key in the COSE key format.
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	var b []byte
+	b = appendHead(b, majorMap, 5)
+	b = appendInt(appendInt(b, 1), 2)  // kty: EC2
+	b = appendInt(appendInt(b, 3), -7) // alg: ES256
+	b = appendInt(appendInt(b, -1), 1) // crv: P-256
+	b = appendBytes(appendInt(b, -2), x)
+	b = appendBytes(appendInt(b, -3), y)
+
+	return b
+}